@@ -8,7 +8,24 @@ import (
 type QueryStmt struct {
 	Select *Select
 	Insert *Insert
+	Update *Update
+	Delete *Delete
 	Create *Create
+	Drop   *Drop
+	Alter  *Alter
+
+	CreateIndex *CreateIndex
+
+	Checkpoint *Checkpoint
+	Vacuum     *Vacuum
+
+	Begin     *Begin
+	Commit    *Commit
+	Rollback  *Rollback
+	Savepoint *Savepoint
+	Release   *Release
+
+	Set *Set
 }
 
 func (qs *QueryStmt) String() string {
@@ -26,21 +43,92 @@ func (qs *QueryStmt) String() string {
 }
 
 /*
- * Select
+ * Expression
  */
+
+// Expr is a node of an expression tree. Exactly one of the fields is set.
+type Expr struct {
+	Column  string   `json:",omitempty"` // column reference
+	Literal *Literal `json:",omitempty"`
+	Binary  *Binary  `json:",omitempty"`
+	Unary   *Unary   `json:",omitempty"`
+	Logical *Logical `json:",omitempty"`
+	In      *In      `json:",omitempty"`
+	Between *Between `json:",omitempty"`
+
+	Subquery *Select `json:",omitempty"` // scalar subquery which returns one column in at most one row
+	Exists   *Select `json:",omitempty"` // "exists (subquery)"
+
+	Aggregate *Aggregate `json:",omitempty"` // allowed only in having
+}
+
+type LiteralKind string
+
+const (
+	LitString = LiteralKind("string")
+	LitInt    = LiteralKind("int")
+	LitFloat  = LiteralKind("float")
+	LitBool   = LiteralKind("bool")
+	LitNull   = LiteralKind("null")
+)
+
+type Literal struct {
+	Kind  LiteralKind
+	Value string
+}
+
+// Binary is a comparison (e.g. "=", "!=", "<", "like").
 type Binary struct {
-	Column string
-	Value  string
+	Op    TkType
+	Left  *Expr
+	Right *Expr
+}
+
+type UnaryOp string
+
+const (
+	OpNot       = UnaryOp("not")
+	OpIsNull    = UnaryOp("is null")
+	OpIsNotNull = UnaryOp("is not null")
+)
+
+type Unary struct {
+	Op      UnaryOp
+	Operand *Expr
 }
 
-type Where struct {
-	Equal    *Binary
-	NotEqual *Binary
+// Logical is "and" or "or".
+type Logical struct {
+	Op    TkType
+	Left  *Expr
+	Right *Expr
 }
 
+// In is "operand in (list...)" or "operand in (subquery)". "not in" is represented as "not" Unary wrapping In.
+type In struct {
+	Operand  *Expr
+	List     []*Expr `json:",omitempty"`
+	Subquery *Select `json:",omitempty"`
+}
+
+// Between is "operand between low and high". Both ends are inclusive.
+type Between struct {
+	Operand *Expr
+	Low     *Expr
+	High    *Expr
+}
+
+/*
+ * Select
+ */
+// Order is a sort key in the order clause. The key is a column, an alias of a select item,
+// or the position of a select item which begins with 1 (Position is 0 if Column is given).
+// NULLs come first if NullsFirst is true; by default, they are last in asc and first in desc.
 type Order struct {
-	Column string
-	Dir    string // asc/desc
+	Column     string `json:",omitempty"`
+	Position   int    `json:",omitempty"`
+	Dir        string // asc/desc
+	NullsFirst bool
 }
 
 type Limit struct {
@@ -51,29 +139,193 @@ type Offset struct {
 	Count int
 }
 
+type JoinType string
+
+const (
+	JoinInner = JoinType("inner")
+	JoinLeft  = JoinType("left")
+	JoinCross = JoinType("cross")
+)
+
+// Join is a table joined to the tables on its left. On is nil for cross join.
+type Join struct {
+	Type  JoinType
+	Table string
+	Alias string `json:",omitempty"`
+	On    *Expr  `json:",omitempty"`
+}
+
+type AggFunc string
+
+const (
+	AggCount = AggFunc("count")
+	AggSum   = AggFunc("sum")
+	AggAvg   = AggFunc("avg")
+	AggMin   = AggFunc("min")
+	AggMax   = AggFunc("max")
+)
+
+// Aggregate is an aggregate function call. Column is "*" for "count(*)".
+type Aggregate struct {
+	Func   AggFunc
+	Column string
+}
+
+func (a *Aggregate) String() string {
+	return fmt.Sprintf("%s(%s)", a.Func, a.Column)
+}
+
+// SelectItem is an item in the select list, which is a column ("*" means every column) or an aggregate function call.
+// Alias is the name of the item in the result, which can also be referred in the order clause.
+type SelectItem struct {
+	Column    string     `json:",omitempty"`
+	Aggregate *Aggregate `json:",omitempty"`
+	Alias     string     `json:",omitempty"`
+}
+
+// Select is the select statement. The columns can be qualified by the table name or its alias (e.g. "p.name").
+// If Subquery is set, the records are read from it instead of Table (derived table).
+// If Distinct is true, the duplicate records in the result are removed.
+// Lock is "update" or "share" if the records in the result are locked in the mode (see lock.go).
 type Select struct {
-	Columns []string
-	Table   string
-	Where   *Where
-	Order   *Order
-	Limit   *Limit
-	Offset  *Offset
+	Distinct bool
+	Items    []*SelectItem
+	Table    string
+	Subquery *Select
+	Alias    string
+	Joins    []*Join
+	Where    *Expr
+	GroupBy  []string
+	Having   *Expr
+	Order    []*Order
+	Limit    *Limit
+	Offset   *Offset
+	Lock     string
 }
 
 /*
  * Insert
  */
+// Insert is the insert statement. The records are given in Rows, or Select returns them.
 type Insert struct {
+	Table  string
+	Cols   []string
+	Rows   [][]*string `json:",omitempty"` // nil means NULL
+	Select *Select     `json:",omitempty"`
+}
+
+/*
+ * Update
+ */
+type Update struct {
 	Table string
 	Cols  []string
-	Vals  []string
+	Vals  []*string // nil means NULL
+	Where *Expr
+}
+
+/*
+ * Delete
+ */
+type Delete struct {
+	Table string
+	Where *Expr
 }
 
 /*
  * Create
  */
 type Create struct {
-	Table string
-	Cols  []string
-	Types []string
+	Table       string
+	IfNotExists bool
+	Cols        []string
+	Types       []string
+	NotNulls    []bool
+	Defaults    []*string  // nil means no default value (NULL)
+	PrimaryKey  []string   `json:",omitempty"`
+	Uniques     [][]string `json:",omitempty"`
+}
+
+type ColumnDef struct {
+	Name       string
+	Type       string
+	NotNull    bool
+	Default    *string // nil means no default value (NULL)
+	PrimaryKey bool
+	Unique     bool
+}
+
+/*
+ * Create index
+ */
+type CreateIndex struct {
+	Name   string
+	Table  string
+	Cols   []string
+	Unique bool
+}
+
+/*
+ * Drop
+ */
+type Drop struct {
+	Table    string
+	IfExists bool
+}
+
+/*
+ * Alter
+ */
+
+// Alter changes the table. Exactly one of AddCol, DropCol, RenameCol and RenameTo is set.
+type Alter struct {
+	Table     string
+	AddCol    *ColumnDef `json:",omitempty"`
+	DropCol   string     `json:",omitempty"`
+	RenameCol *RenameCol `json:",omitempty"`
+	RenameTo  string     `json:",omitempty"`
+}
+
+type RenameCol struct {
+	From string
+	To   string
+}
+
+/*
+ * Checkpoint
+ */
+type Checkpoint struct{}
+
+/*
+ * Vacuum
+ */
+type Vacuum struct {
+	Table string `json:",omitempty"` // empty means every table
+}
+
+/*
+ * Transaction
+ */
+type Begin struct{}
+
+type Commit struct{}
+
+type Rollback struct {
+	Savepoint string `json:",omitempty"` // rolls back to the savepoint if not empty
+}
+
+type Savepoint struct {
+	Name string
+}
+
+type Release struct {
+	Savepoint string
+}
+
+/*
+ * Set
+ */
+type Set struct {
+	LockTimeout *int   `json:",omitempty"` // milliseconds, 0 means waiting forever
+	Isolation   string `json:",omitempty"` // "read committed", "repeatable read" or "serializable"
 }
@@ -0,0 +1,177 @@
+package main
+
+import "sort"
+
+// btreeMaxItems is the maximum number of items in a node. A node is split when it exceeds this.
+const btreeMaxItems = 64
+
+// BTree is an in-memory B+tree which maps a key (a tuple of column values) to the RIDs of the records.
+// Items are ordered by the key then by the RID, so the same key can be stored for different records.
+// Leaves are linked, so the items can be scanned in order from any position.
+//
+// On delete, the item is just removed from its leaf and the nodes are not merged.
+// This is fine because the index is rebuilt from the heap file on every launch.
+type BTree struct {
+	root *btreeNode
+	len  int
+}
+
+type btreeItem struct {
+	key []any
+	rid RID
+}
+
+type btreeNode struct {
+	// items are the entries in a leaf. In an internal node, items[i] is the smallest item in children[i+1].
+	items    []btreeItem
+	children []*btreeNode
+	next     *btreeNode // next leaf
+}
+
+func (n *btreeNode) leaf() bool {
+	return n.children == nil
+}
+
+func NewBTree() *BTree {
+	return &BTree{root: &btreeNode{}}
+}
+
+// compareKeys compares the keys value by value. Only the common prefix is compared,
+// so a shorter key is equal to the longer keys beginning with it.
+func compareKeys(a, b []any) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if c := compareValues(a[i], b[i]); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+func (it btreeItem) less(other btreeItem) bool {
+	if c := compareKeys(it.key, other.key); c != 0 {
+		return c < 0
+	}
+	return it.rid.Less(other.rid)
+}
+
+func (t *BTree) Len() int {
+	return t.len
+}
+
+// Insert adds the key of the record at the rid. Nothing is done if it is already added.
+func (t *BTree) Insert(key []any, rid RID) {
+	it := btreeItem{key: key, rid: rid}
+	sep, right, added := t.root.insert(it)
+	if right != nil {
+		t.root = &btreeNode{items: []btreeItem{sep}, children: []*btreeNode{t.root, right}}
+	}
+	if added {
+		t.len++
+	}
+}
+
+// insert adds the item in the subtree. If the node is split, the new right node and its smallest item are returned.
+// false is returned if the item already exists.
+func (n *btreeNode) insert(it btreeItem) (btreeItem, *btreeNode, bool) {
+	i := sort.Search(len(n.items), func(i int) bool { return it.less(n.items[i]) })
+
+	if n.leaf() {
+		if i > 0 && !n.items[i-1].less(it) {
+			return btreeItem{}, nil, false
+		}
+
+		n.items = append(n.items, btreeItem{})
+		copy(n.items[i+1:], n.items[i:])
+		n.items[i] = it
+	} else {
+		sep, right, added := n.children[i].insert(it)
+		if right == nil {
+			return btreeItem{}, nil, added
+		}
+
+		n.items = append(n.items, btreeItem{})
+		copy(n.items[i+1:], n.items[i:])
+		n.items[i] = sep
+		n.children = append(n.children, nil)
+		copy(n.children[i+2:], n.children[i+1:])
+		n.children[i+1] = right
+	}
+
+	if len(n.items) <= btreeMaxItems {
+		return btreeItem{}, nil, true
+	}
+
+	sep, right := n.split()
+	return sep, right, true
+}
+
+func (n *btreeNode) split() (btreeItem, *btreeNode) {
+	mid := len(n.items) / 2
+
+	if n.leaf() {
+		right := &btreeNode{items: append([]btreeItem{}, n.items[mid:]...), next: n.next}
+		n.items = n.items[:mid:mid]
+		n.next = right
+		return right.items[0], right
+	}
+
+	// the separator moves up to the parent
+	sep := n.items[mid]
+	right := &btreeNode{
+		items:    append([]btreeItem{}, n.items[mid+1:]...),
+		children: append([]*btreeNode{}, n.children[mid+1:]...),
+	}
+	n.items = n.items[:mid:mid]
+	n.children = n.children[: mid+1 : mid+1]
+	return sep, right
+}
+
+// Delete removes the key of the record at the rid. false is returned if it is not found.
+func (t *BTree) Delete(key []any, rid RID) bool {
+	it := btreeItem{key: key, rid: rid}
+
+	n := t.root
+	for !n.leaf() {
+		n = n.children[sort.Search(len(n.items), func(i int) bool { return it.less(n.items[i]) })]
+	}
+
+	i := sort.Search(len(n.items), func(i int) bool { return !n.items[i].less(it) })
+	if i == len(n.items) || it.less(n.items[i]) {
+		return false
+	}
+
+	n.items = append(n.items[:i], n.items[i+1:]...)
+	t.len--
+	return true
+}
+
+// Ascend calls fn with the items in order, beginning with the first item whose key is not less than from.
+// from can be a prefix of the keys, and nil means the beginning. The scan stops when fn returns false.
+func (t *BTree) Ascend(from []any, fn func(key []any, rid RID) bool) {
+	n := t.root
+	for !n.leaf() {
+		n = n.children[sort.Search(len(n.items), func(i int) bool { return compareKeys(n.items[i].key, from) >= 0 })]
+	}
+
+	i := sort.Search(len(n.items), func(i int) bool { return compareKeys(n.items[i].key, from) >= 0 })
+	for ; n != nil; n, i = n.next, 0 {
+		for ; i < len(n.items); i++ {
+			if !fn(n.items[i].key, n.items[i].rid) {
+				return
+			}
+		}
+	}
+}
+
+// Lookup returns the RIDs of the records whose key begins with the given key.
+func (t *BTree) Lookup(key []any) []RID {
+	rids := []RID{}
+	t.Ascend(key, func(k []any, rid RID) bool {
+		if compareKeys(k, key) != 0 {
+			return false
+		}
+		rids = append(rids, rid)
+		return true
+	})
+	return rids
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func TestBTree(t *testing.T) {
+	tree := NewBTree()
+
+	// enough items to split the nodes several times
+	n := btreeMaxItems * btreeMaxItems * 2
+	for _, i := range rand.New(rand.NewSource(1)).Perm(n) {
+		tree.Insert([]any{int64(i / 2), "k"}, RID{Page: uint32(i), Slot: 0})
+	}
+
+	// the same item is added only once
+	for i := 0; i < n; i += 7 {
+		tree.Insert([]any{int64(i / 2), "k"}, RID{Page: uint32(i), Slot: 0})
+	}
+
+	if tree.Len() != n {
+		t.Fatalf("unexpected length: got: %d, expected: %d", tree.Len(), n)
+	}
+
+	// items are in the order of the key then the RID
+	i := 0
+	tree.Ascend(nil, func(key []any, rid RID) bool {
+		if key[0] != int64(i/2) || rid.Page != uint32(i) {
+			t.Fatalf("unexpected item at %d: %v %v", i, key, rid)
+		}
+		i++
+		return true
+	})
+	if i != n {
+		t.Fatalf("unexpected number of scanned items: %d", i)
+	}
+
+	if rids := tree.Lookup([]any{int64(100)}); !reflect.DeepEqual(rids, []RID{{Page: 200}, {Page: 201}}) {
+		t.Fatalf("unexpected lookup result: %v", rids)
+	}
+
+	if rids := tree.Lookup([]any{int64(100), "x"}); len(rids) != 0 {
+		t.Fatalf("unexpected lookup result: %v", rids)
+	}
+
+	// ascend from the middle and stop
+	scanned := []any{}
+	tree.Ascend([]any{int64(n/4 - 1)}, func(key []any, rid RID) bool {
+		scanned = append(scanned, key[0])
+		return len(scanned) < 3
+	})
+	if !reflect.DeepEqual(scanned, []any{int64(n/4 - 1), int64(n/4 - 1), int64(n / 4)}) {
+		t.Fatalf("unexpected scanned keys: %v", scanned)
+	}
+
+	if tree.Delete([]any{int64(100), "k"}, RID{Page: 999999}) {
+		t.Fatalf("missing item must not be deleted")
+	}
+
+	// delete every item with even key
+	for i := 0; i < n; i++ {
+		if (i/2)%2 == 0 && !tree.Delete([]any{int64(i / 2), "k"}, RID{Page: uint32(i)}) {
+			t.Fatalf("item %d must be deleted", i)
+		}
+	}
+
+	if tree.Len() != n/2 {
+		t.Fatalf("unexpected length after delete: %d", tree.Len())
+	}
+
+	tree.Ascend(nil, func(key []any, rid RID) bool {
+		if key[0].(int64)%2 == 0 {
+			t.Fatalf("deleted item is scanned: %v", key)
+		}
+		return true
+	})
+
+	if rids := tree.Lookup([]any{int64(100)}); len(rids) != 0 {
+		t.Fatalf("deleted items are found: %v", rids)
+	}
+
+	if rids := tree.Lookup([]any{int64(101)}); !reflect.DeepEqual(rids, []RID{{Page: 202}, {Page: 203}}) {
+		t.Fatalf("unexpected lookup result: %v", rids)
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// bufferPoolSize is the number of records the buffer pool can hold.
+const bufferPoolSize = 4096
+
+// BufferPool caches the records in the tablespace on memory,
+// so that the select can be done without reading the heap files.
+// Every change is written through the heap file, so the cached records are never dirty.
+type BufferPool struct {
+	lru *LRU
+
+	// rids is the location of the records in each table in the order of the heap file.
+	// The table is missing if it has never been read since the launch.
+	rids map[string][]RID
+	m    sync.Mutex
+
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+var bufpool = NewBufferPool(bufferPoolSize)
+
+func NewBufferPool(cap int) *BufferPool {
+	return &BufferPool{
+		lru:  NewLRU(cap),
+		rids: map[string][]RID{},
+	}
+}
+
+func recordKey(tbl string, rid RID) string {
+	return fmt.Sprintf("%s/%d/%d", tbl, rid.Page, rid.Slot)
+}
+
+// Scan returns all the records in the table.
+// If any of them is not cached, load is called to read the records from the heap file and they are cached.
+// The returned records are copies, so the caller can modify them.
+func (bp *BufferPool) Scan(tbl string, load func() ([]*Record, error)) ([]*Record, error) {
+	bp.m.Lock()
+	defer bp.m.Unlock()
+
+	rids, ok := bp.rids[tbl]
+	if ok {
+		rs := make([]*Record, len(rids))
+		hits := 0
+		for i, rid := range rids {
+			if r, ok := bp.lru.Get(recordKey(tbl, rid)); ok {
+				rs[i] = r.Clone()
+				hits++
+			}
+		}
+
+		bp.hits.Add(int64(hits))
+		bp.misses.Add(int64(len(rids) - hits))
+
+		if hits == len(rids) {
+			return rs, nil
+		}
+	}
+
+	rs, err := load()
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		bp.misses.Add(int64(len(rs)))
+	}
+
+	rids = make([]RID, len(rs))
+	for i, r := range rs {
+		bp.lru.Put(recordKey(tbl, r.RID), r.Clone())
+		rids[i] = r.RID
+	}
+	bp.rids[tbl] = rids
+
+	return rs, nil
+}
+
+// Get returns the record at the rid in the table.
+// If it is not cached, load is called to read the record from the heap file and it is cached.
+// nil is returned if load returns nil because the record is not found.
+// The returned record is a copy, so the caller can modify it.
+func (bp *BufferPool) Get(tbl string, rid RID, load func() (*Record, error)) (*Record, error) {
+	bp.m.Lock()
+	defer bp.m.Unlock()
+
+	if r, ok := bp.lru.Get(recordKey(tbl, rid)); ok {
+		bp.hits.Add(1)
+		return r.Clone(), nil
+	}
+	bp.misses.Add(1)
+
+	r, err := load()
+	if err != nil || r == nil {
+		return nil, err
+	}
+
+	// the record is cached only if the table is known, so that Scan can tell which records are missing
+	if _, ok := bp.rids[tbl]; ok {
+		bp.lru.Put(recordKey(tbl, rid), r.Clone())
+	}
+
+	return r, nil
+}
+
+// Put caches the record in the table. This must be called after the record is written in the heap file.
+func (bp *BufferPool) Put(tbl string, r *Record) {
+	bp.m.Lock()
+	defer bp.m.Unlock()
+
+	rids, ok := bp.rids[tbl]
+	if !ok {
+		return // the table will be loaded on the next scan
+	}
+
+	bp.lru.Put(recordKey(tbl, r.RID), r.Clone())
+
+	i := sort.Search(len(rids), func(i int) bool { return !rids[i].Less(r.RID) })
+	if i < len(rids) && rids[i] == r.RID {
+		return
+	}
+	bp.rids[tbl] = slices.Insert(rids, i, r.RID)
+}
+
+// Remove drops the record at the rid from the cache. This must be called after the record is removed from the heap file.
+func (bp *BufferPool) Remove(tbl string, rid RID) {
+	bp.m.Lock()
+	defer bp.m.Unlock()
+
+	bp.lru.Remove(recordKey(tbl, rid))
+
+	rids := bp.rids[tbl]
+	i := sort.Search(len(rids), func(i int) bool { return !rids[i].Less(rid) })
+	if i < len(rids) && rids[i] == rid {
+		bp.rids[tbl] = slices.Delete(rids, i, i+1)
+	}
+}
+
+// Drop drops every cached record in the table.
+// This must be called when the table is dropped or altered.
+func (bp *BufferPool) Drop(tbl string) {
+	bp.m.Lock()
+	defer bp.m.Unlock()
+
+	for _, rid := range bp.rids[tbl] {
+		bp.lru.Remove(recordKey(tbl, rid))
+	}
+	delete(bp.rids, tbl)
+}
+
+// Stats returns the number of records which are found/not found in the buffer pool.
+func (bp *BufferPool) Stats() (hits, misses int64) {
+	return bp.hits.Load(), bp.misses.Load()
+}
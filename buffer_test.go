@@ -0,0 +1,67 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBufferPool(t *testing.T) {
+	bp := NewBufferPool(3)
+
+	data := []*Record{
+		{RID: RID{Page: 0, Slot: 0}, Cols: []string{"id"}, Vals: []any{"1"}},
+		{RID: RID{Page: 0, Slot: 1}, Cols: []string{"id"}, Vals: []any{"2"}},
+	}
+	loaded := 0
+	load := func() ([]*Record, error) {
+		loaded++
+		rs := []*Record{}
+		for _, r := range data {
+			rs = append(rs, r.Clone())
+		}
+		return rs, nil
+	}
+
+	assert := func(t *testing.T, expected []*Record, expectedLoaded int, expectedHits, expectedMisses int64) {
+		t.Helper()
+		rs, err := bp.Scan("tbl", load)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(rs, expected) {
+			t.Fatalf("unexpected records: got: %v, expected: %v", rs, expected)
+		}
+
+		if loaded != expectedLoaded {
+			t.Fatalf("unexpected load count: got: %d, expected: %d", loaded, expectedLoaded)
+		}
+
+		if hits, misses := bp.Stats(); hits != expectedHits || misses != expectedMisses {
+			t.Fatalf("unexpected stats: got: %d/%d, expected: %d/%d", hits, misses, expectedHits, expectedMisses)
+		}
+	}
+
+	// first scan loads the table
+	assert(t, data, 1, 0, 2)
+
+	// served from the buffer pool
+	rs, _ := bp.Scan("tbl", load)
+	rs[0].Vals[0] = "modified" // must not affect the cached record
+	assert(t, data, 1, 4, 2)
+
+	// written record is cached
+	data = append(data, &Record{RID: RID{Page: 1, Slot: 0}, Cols: []string{"id"}, Vals: []any{"3"}})
+	bp.Put("tbl", data[2])
+	assert(t, data, 1, 7, 2)
+
+	// the capacity is exceeded then the first record gets evicted
+	data = append(data, &Record{RID: RID{Page: 1, Slot: 1}, Cols: []string{"id"}, Vals: []any{"4"}})
+	bp.Put("tbl", data[3])
+	assert(t, data, 2, 10, 3)
+
+	// removed record is not returned, and the rest is served from the buffer pool
+	bp.Remove("tbl", data[0].RID)
+	data = data[1:]
+	assert(t, data, 2, 13, 3)
+}
@@ -3,6 +3,8 @@ package main
 import (
 	"fmt"
 	"os"
+	"slices"
+	"strings"
 )
 
 // schema
@@ -11,9 +13,12 @@ import (
 //     {
 //       "name": "tbl1",
 //       "cols": [
-//         {"name": "col1", "type": "string"},
-//         {"name": "col2", "type": "string"},
-//         {"name": "col3", "type": "string"}
+//         {"name": "col1", "type": "string", "notnull": true},
+//         {"name": "col2", "type": "int", "default": "0"},
+//         {"name": "col3", "type": "timestamp"}
+//       ],
+//       "indexes": [
+//         {"name": "tbl1_pkey", "cols": ["col1"], "unique": true, "primary": true}
 //       ]
 //     },
 //     {
@@ -60,81 +65,227 @@ func init() {
 }
 
 type CtCol struct {
-	Name string
-	Type string
+	Name    string
+	Type    string
+	NotNull bool    `json:",omitempty"`
+	Default *string `json:",omitempty"` // literal of the default value, nil means NULL
+}
+
+// DefaultValue returns the value used when the column value is not given on insert.
+func (c *CtCol) DefaultValue() (any, error) {
+	if c.Default == nil {
+		return nil, nil
+	}
+
+	return parseValue(c.Type, *c.Default)
 }
 
 type CtTable struct {
-	Name string
-	Cols []*CtCol
+	Name    string
+	Cols    []*CtCol
+	Indexes []*CtIndex `json:",omitempty"`
+}
+
+// ColIndex returns the position of the column in the table. -1 is returned if not found.
+func (t *CtTable) ColIndex(col string) int {
+	for i := range t.Cols {
+		if t.Cols[i].Name == col {
+			return i
+		}
+	}
+	return -1
+}
+
+// CtIndex is an index on the columns of the table. Primary key and unique constraints are unique indexes.
+type CtIndex struct {
+	Name    string
+	Cols    []string
+	Unique  bool `json:",omitempty"`
+	Primary bool `json:",omitempty"`
 }
 
 type Catalog struct {
 	Tables []*CtTable
 }
 
-func addTable(tbl string, cols []string, types []string) error {
-	if len(cols) != len(types) {
-		return fmt.Errorf("the length of cols and types must be the same")
+// putTable puts the table definition in the catalog. The existing definition of the same name is replaced.
+func putTable(tDef *CtTable) error {
+	c, err := readCatalogFile()
+	if err != nil {
+		return err
 	}
 
+	for i, t := range c.Tables {
+		if t.Name == tDef.Name {
+			c.Tables[i] = tDef
+			return writeCatalogFile(c)
+		}
+	}
+
+	c.Tables = append(c.Tables, tDef)
+	return writeCatalogFile(c)
+}
+
+// newConstraintIndex returns the unique index backing the primary key or unique constraint on the columns.
+func newConstraintIndex(tbl string, cols []string, primary bool) *CtIndex {
+	name := tbl + "_pkey"
+	if !primary {
+		name = tbl + "_" + strings.Join(cols, "_") + "_key"
+	}
+
+	return &CtIndex{Name: name, Cols: cols, Unique: true, Primary: primary}
+}
+
+// validateTable validates the columns and the indexes of the table definition.
+func validateTable(tDef *CtTable) error {
+	if err := validateCols(tDef.Cols); err != nil {
+		return err
+	}
+
+	names := map[string]bool{}
+	primary := false
+	for _, idx := range tDef.Indexes {
+		if names[idx.Name] {
+			return fmt.Errorf("index '%s' already exists in table '%s'", idx.Name, tDef.Name)
+		}
+		names[idx.Name] = true
+
+		if idx.Primary {
+			if primary {
+				return fmt.Errorf("multiple primary keys for table '%s' are not allowed", tDef.Name)
+			}
+			primary = true
+		}
+
+		if len(idx.Cols) == 0 {
+			return fmt.Errorf("index '%s' must have at least one column", idx.Name)
+		}
+
+		for j, col := range idx.Cols {
+			if slices.Index(idx.Cols, col) != j {
+				return fmt.Errorf("column '%s' is specified more than once in index '%s'", col, idx.Name)
+			}
+
+			i := tDef.ColIndex(col)
+			if i < 0 {
+				return fmt.Errorf("column '%s' of index '%s' is not found in table '%s'", col, idx.Name, tDef.Name)
+			}
+
+			if idx.Primary && !tDef.Cols[i].NotNull {
+				return fmt.Errorf("column '%s' of primary key must be not null", col)
+			}
+		}
+	}
+
+	return nil
+}
+
+func validateCols(cols []*CtCol) error {
 	if len(cols) == 0 {
 		return fmt.Errorf("table must have at least one column")
 	}
 
-	f, err := os.OpenFile(catfile, os.O_RDWR|os.O_CREATE, 0755)
-	if err != nil {
-		return fmt.Errorf("open catalog file: %w", err)
+	names := map[string]bool{}
+	for _, col := range cols {
+		if names[col.Name] {
+			return fmt.Errorf("column '%s' is specified more than once", col.Name)
+		}
+		names[col.Name] = true
+
+		if !Contains(columnTypes, col.Type) {
+			return fmt.Errorf("type must be one of %v but '%s'", columnTypes, col.Type)
+		}
+
+		if _, err := col.DefaultValue(); err != nil {
+			return fmt.Errorf("invalid default value for column '%s': %w", col.Name, err)
+		}
 	}
-	defer f.Close()
 
-	c := Catalog{}
+	return nil
+}
 
-	if err := readJsonFile(f, &c); err != nil {
-		return fmt.Errorf("read catalog file: %w", err)
+// removeTable removes the table from the catalog. Nothing is done if the table is not found.
+func removeTable(tbl string) error {
+	c, err := readCatalogFile()
+	if err != nil {
+		return err
 	}
 
+	n := 0
 	for _, t := range c.Tables {
-		if t.Name == tbl {
-			return fmt.Errorf("table %s already exists in catalog", tbl)
+		if t.Name != tbl {
+			c.Tables[n] = t
+			n++
 		}
 	}
 
-	cs := make([]*CtCol, len(cols))
-	for i := range cols {
-		if types[i] != "string" {
-			return fmt.Errorf("type must be 'string' but '%s'", types[i])
-		}
-		cs[i] = &CtCol{Name: cols[i], Type: types[i]}
+	if n == len(c.Tables) {
+		return nil
 	}
+	c.Tables = c.Tables[:n]
 
-	c.Tables = append(c.Tables, &CtTable{
-		Name: tbl,
-		Cols: cs,
-	})
+	return writeCatalogFile(c)
+}
 
-	if err := updateJsonFile(f, &c); err != nil {
-		return fmt.Errorf("update catalog file: %w", err)
+// replaceTable replaces the definition of the table with tDef. The table is renamed if tDef has another name.
+// Nothing is done if the table is not found. If the table of the new name already exists, it is already renamed,
+// so the table is just removed in the same manner as renameHeap.
+func replaceTable(tbl string, tDef *CtTable) error {
+	c, err := readCatalogFile()
+	if err != nil {
+		return err
 	}
 
-	f.Sync()
+	index := -1
+	for i, t := range c.Tables {
+		if t.Name == tbl {
+			index = i
+		}
 
-	return nil
+		if tDef.Name != tbl && t.Name == tDef.Name {
+			return removeTable(tbl) // already renamed
+		}
+	}
+
+	if index < 0 {
+		return nil
+	}
+	c.Tables[index] = tDef
+
+	return writeCatalogFile(c)
 }
 
-func readCatalog(tbl string) (*CtTable, error) {
+func readCatalogFile() (*Catalog, error) {
 	f, err := os.OpenFile(catfile, os.O_RDONLY|os.O_CREATE, 0755)
 	if err != nil {
 		return nil, fmt.Errorf("open catalog file: %w", err)
 	}
 	defer f.Close()
 
-	c := Catalog{}
+	c := &Catalog{}
 
-	if err := readJsonFile(f, &c); err != nil {
+	if err := readJsonFile(f, c); err != nil {
 		return nil, fmt.Errorf("read catalog file: %w", err)
 	}
 
+	return c, nil
+}
+
+// writeCatalogFile atomically replaces the catalog file, so that readers never see it half-written.
+func writeCatalogFile(c *Catalog) error {
+	if err := replaceJsonFile(catfile, c); err != nil {
+		return fmt.Errorf("update catalog file: %w", err)
+	}
+
+	return nil
+}
+
+func readCatalog(tbl string) (*CtTable, error) {
+	c, err := readCatalogFile()
+	if err != nil {
+		return nil, err
+	}
+
 	for _, t := range c.Tables {
 		if t.Name == tbl {
 			return t, nil
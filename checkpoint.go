@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// schema
+// {
+//   "CheckpointLSN": 42,
+//   "NextXid": 10,
+//   "Uncommitted": [3, 7]
+// }
+
+var ctlfile = "data/incdb.control"
+
+// checkpointInterval is the interval of the checkpoint done in background.
+const checkpointInterval = time.Minute
+
+func init() {
+	// test mode
+	if os.Getenv("INCDB_TEST") == "1" {
+		ctlfile = "data/test.incdb.control"
+	}
+}
+
+// Control records the state of the database which must survive the restart.
+type Control struct {
+	// CheckpointLSN is the LSN until which every WAL entry is flushed into the heap files.
+	CheckpointLSN uint64
+
+	// NextXid is the id of the next transaction at the checkpoint.
+	NextXid uint64 `json:",omitempty"`
+
+	// Uncommitted is the transactions which were aborted or in progress at the checkpoint.
+	// They are aborted on recovery unless their commit is found in WAL after the checkpoint.
+	Uncommitted []uint64 `json:",omitempty"`
+}
+
+func readControl() (*Control, error) {
+	f, err := os.OpenFile(ctlfile, os.O_RDONLY|os.O_CREATE, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("open control file: %w", err)
+	}
+	defer f.Close()
+
+	c := &Control{}
+
+	if err := readJsonFile(f, c); err != nil {
+		return nil, fmt.Errorf("read control file: %w", err)
+	}
+
+	return c, nil
+}
+
+// checkpoint flushes every heap file into the disk, records the checkpoint LSN,
+// then removes the WAL segments which are no longer needed for the recovery.
+// If the process dies before the removal, the remaining segments are just skipped on the recovery
+// and removed on the next checkpoint.
+func checkpoint() (uint64, error) {
+	tsMu.Lock()
+	defer tsMu.Unlock()
+
+	tbls, err := listHeaps()
+	if err != nil {
+		return 0, err
+	}
+
+	for _, tbl := range tbls {
+		if err := syncHeap(tbl); err != nil {
+			return 0, fmt.Errorf("flush table %s: %w", tbl, err)
+		}
+	}
+
+	uncommitted, next := uncommittedTxs()
+	if err := replaceJsonFile(ctlfile, &Control{CheckpointLSN: lastLSN, NextXid: next, Uncommitted: uncommitted}); err != nil {
+		return 0, fmt.Errorf("record checkpoint LSN: %w", err)
+	}
+
+	removed, err := removeWalSegments(lastLSN)
+	if err != nil {
+		return 0, fmt.Errorf("remove WAL segments: %w", err)
+	}
+
+	Debug("checkpoint LSN: ", lastLSN, ", removed WAL segments: ", removed)
+
+	return lastLSN, nil
+}
+
+// runCheckpointer does checkpoint periodically. This never returns.
+func runCheckpointer(interval time.Duration) {
+	for range time.Tick(interval) {
+		if _, err := checkpoint(); err != nil {
+			fmt.Fprintf(os.Stderr, "checkpoint: %s\n", err)
+		}
+	}
+}
@@ -21,12 +21,14 @@ func runQuery() error {
 	}
 
 	type Req struct {
-		Query string
+		Query   string
+		Session string
 	}
 
 	query := os.Args[1]
 
-	qReq := Req{Query: query}
+	// the queries with the same session name can run in a transaction block
+	qReq := Req{Query: query, Session: os.Getenv("INCDB_SESSION")}
 	b, err := json.Marshal(&qReq)
 	if err != nil {
 		return fmt.Errorf("marshal request: %w", err)
@@ -43,10 +45,13 @@ func runQuery() error {
 	}
 
 	type Result struct {
-		Msg      string
-		Hdr      []string
-		Vals     [][]string
-		ErrorMsg string
+		Msg       string
+		Hdr       []string
+		Types     []string
+		Vals      [][]*string // nil means NULL
+		ErrorMsg  string
+		ErrorCode string
+		Retryable bool
 	}
 
 	result := Result{}
@@ -54,6 +59,10 @@ func runQuery() error {
 		return fmt.Errorf("decode response: %w", err)
 	}
 
+	if result.ErrorMsg != "" && result.Retryable {
+		return fmt.Errorf("run query: %s (SQLSTATE %s, the transaction can be retried)", result.ErrorMsg, result.ErrorCode)
+	}
+
 	if result.ErrorMsg != "" {
 		return fmt.Errorf("run query: %s", result.ErrorMsg)
 	}
@@ -67,7 +76,7 @@ func runQuery() error {
 		// in test, output will be structured for testability
 		type Output struct {
 			Hdr  []string
-			Vals [][]string
+			Vals [][]*string
 		}
 		o := Output{Hdr: result.Hdr, Vals: result.Vals}
 
@@ -82,6 +91,7 @@ func runQuery() error {
 
 	tw := &TableWriter{}
 	tw.SetHeader(result.Hdr)
+	tw.SetTypes(result.Types)
 	for _, val := range result.Vals {
 		tw.Append(val)
 	}
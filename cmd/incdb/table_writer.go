@@ -12,6 +12,7 @@ type TableWriter struct {
 	rows   [][]string
 	header []string
 	maxes  []int
+	rights []bool // right-aligned columns
 }
 
 const (
@@ -28,7 +29,28 @@ func (w *TableWriter) SetHeader(hdr []string) {
 	w.header = hdr
 }
 
-func (w *TableWriter) Append(row []string) {
+// SetTypes sets the column types. Numeric columns are right-aligned.
+func (w *TableWriter) SetTypes(types []string) {
+	w.rights = make([]bool, len(types))
+	for i, typ := range types {
+		w.rights[i] = typ == "int" || typ == "float"
+	}
+}
+
+// Null is shown in place of NULL value.
+const Null = "NULL"
+
+// Append appends the row. nil in the row means NULL.
+func (w *TableWriter) Append(vals []*string) {
+	row := make([]string, len(vals))
+	for i, val := range vals {
+		if val == nil {
+			row[i] = Null
+		} else {
+			row[i] = *val
+		}
+	}
+
 	for i := range row {
 		if w.maxes[i] < len(row[i])+2 {
 			w.maxes[i] = len(row[i]) + 2
@@ -42,10 +64,10 @@ func (w *TableWriter) Render() {
 		w.Writer = os.Stdout // default
 	}
 	w.printLine()
-	w.print(w.header)
+	w.print(w.header, false)
 	w.printLine()
 	for _, row := range w.rows {
-		w.print(row)
+		w.print(row, true)
 	}
 	w.printLine()
 }
@@ -59,11 +81,15 @@ func (w *TableWriter) printLine() {
 	fmt.Fprintf(w.Writer, "\n")
 }
 
-func (w *TableWriter) print(row []string) {
+func (w *TableWriter) print(row []string, align bool) {
 	fmt.Fprintf(w.Writer, "|")
 	for i, val := range row {
 		spacesCnt := w.maxes[i] - len(val) - 1
-		fmt.Fprintf(w.Writer, " %s%s", val, strings.Repeat(" ", spacesCnt))
+		if align && i < len(w.rights) && w.rights[i] {
+			fmt.Fprintf(w.Writer, "%s%s ", strings.Repeat(" ", spacesCnt), val)
+		} else {
+			fmt.Fprintf(w.Writer, " %s%s", val, strings.Repeat(" ", spacesCnt))
+		}
 		fmt.Fprintf(w.Writer, "%s", "|")
 	}
 	fmt.Fprintf(w.Writer, "\n")
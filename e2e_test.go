@@ -2,8 +2,11 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
+	"net/http"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"reflect"
 	"strings"
 	"testing"
@@ -15,18 +18,20 @@ func TestE2E(t *testing.T) {
 		query string
 		// used in insert/create
 		msg string
+		// used in failing query, the error message must contain it
+		errMsg string
 		// used in select
 		rHdr []string
 		rDat [][]string
 		// file verification
-		data map[string][]map[string]string
+		data map[string][][]string
 		cat  *Catalog
 	}{
 		// create table
 		{
 			query: "create table item (id string, name string)",
 			msg:   "table item created",
-			data:  map[string][]map[string]string{"item": {}},
+			data:  map[string][][]string{"item": {}},
 			cat: &Catalog{Tables: []*CtTable{
 				{
 					Name: "item",
@@ -40,7 +45,7 @@ func TestE2E(t *testing.T) {
 		{
 			query: "create table user (id string, name string, city string)",
 			msg:   "table user created",
-			data:  map[string][]map[string]string{"item": {}, "user": {}},
+			data:  map[string][][]string{"item": {}, "user": {}},
 			cat: &Catalog{Tables: []*CtTable{
 				{
 					Name: "item",
@@ -64,35 +69,47 @@ func TestE2E(t *testing.T) {
 		{
 			query: `insert into item (id, name) values ("1", "laptop")`,
 			msg:   "inserted",
-			data: map[string][]map[string]string{"user": {}, "item": {
-				{"id": "1", "name": "laptop"},
+			data: map[string][][]string{"user": {}, "item": {
+				{"1", "laptop"},
 			}},
 		},
 		{
 			query: `insert into item (id, name) values ("2", "iPhone")`,
 			msg:   "inserted",
-			data: map[string][]map[string]string{"user": {}, "item": {
-				{"id": "1", "name": "laptop"},
-				{"id": "2", "name": "iPhone"},
+			data: map[string][][]string{"user": {}, "item": {
+				{"1", "laptop"},
+				{"2", "iPhone"},
 			}},
 		},
 		{
 			query: `insert into item values ("3", "radio")`,
 			msg:   "inserted",
-			data: map[string][]map[string]string{"user": {}, "item": {
-				{"id": "1", "name": "laptop"},
-				{"id": "2", "name": "iPhone"},
-				{"id": "3", "name": "radio"},
+			data: map[string][][]string{"user": {}, "item": {
+				{"1", "laptop"},
+				{"2", "iPhone"},
+				{"3", "radio"},
 			}},
 		},
 		{
 			query: `insert into item (id) values ("4")`,
 			msg:   "inserted",
-			data: map[string][]map[string]string{"user": {}, "item": {
-				{"id": "1", "name": "laptop"},
-				{"id": "2", "name": "iPhone"},
-				{"id": "3", "name": "radio"},
-				{"id": "4"},
+			data: map[string][][]string{"user": {}, "item": {
+				{"1", "laptop"},
+				{"2", "iPhone"},
+				{"3", "radio"},
+				{"4", "NULL"},
+			}},
+		},
+
+		// checkpoint
+		{
+			query: "checkpoint",
+			msg:   "checkpoint done at LSN 10", // every insert is followed by its commit
+			data: map[string][][]string{"user": {}, "item": {
+				{"1", "laptop"},
+				{"2", "iPhone"},
+				{"3", "radio"},
+				{"4", "NULL"},
 			}},
 		},
 
@@ -104,7 +121,7 @@ func TestE2E(t *testing.T) {
 				{"1", "laptop"},
 				{"2", "iPhone"},
 				{"3", "radio"},
-				{"4", ""},
+				{"4", "NULL"},
 			},
 		},
 		{
@@ -134,7 +151,6 @@ func TestE2E(t *testing.T) {
 			rDat: [][]string{
 				{"iPhone"},
 				{"radio"},
-				{""},
 			},
 		},
 		{
@@ -159,7 +175,7 @@ func TestE2E(t *testing.T) {
 				{"1", "laptop"},
 				{"2", "iPhone"},
 				{"3", "radio"},
-				{"4", ""},
+				{"4", "NULL"},
 			},
 		},
 		{
@@ -168,7 +184,7 @@ func TestE2E(t *testing.T) {
 			rDat: [][]string{
 				{"2", "iPhone"},
 				{"3", "radio"},
-				{"4", ""},
+				{"4", "NULL"},
 			},
 		},
 		{
@@ -188,7 +204,7 @@ func TestE2E(t *testing.T) {
 			rDat: [][]string{
 				{"2", "iPhone"},
 				{"3", "radio"},
-				{"4", ""},
+				{"4", "NULL"},
 			},
 		},
 		{
@@ -197,7 +213,7 @@ func TestE2E(t *testing.T) {
 			rDat: [][]string{
 				{"2", "iPhone"},
 				{"3", "radio"},
-				{"4", ""},
+				{"4", "NULL"},
 			},
 		},
 		{
@@ -207,14 +223,14 @@ func TestE2E(t *testing.T) {
 				{"1", "laptop"},
 				{"2", "iPhone"},
 				{"3", "radio"},
-				{"4", ""},
+				{"4", "NULL"},
 			},
 		},
 		{
 			query: "select * from item order by id desc",
 			rHdr:  []string{"id", "name"},
 			rDat: [][]string{
-				{"4", ""},
+				{"4", "NULL"},
 				{"3", "radio"},
 				{"2", "iPhone"},
 				{"1", "laptop"},
@@ -224,17 +240,17 @@ func TestE2E(t *testing.T) {
 			query: "select * from item order by name",
 			rHdr:  []string{"id", "name"},
 			rDat: [][]string{
-				{"4", ""},
 				{"2", "iPhone"},
 				{"1", "laptop"},
 				{"3", "radio"},
+				{"4", "NULL"},
 			},
 		},
 		{
 			query: "select * from item order by id desc limit 2",
 			rHdr:  []string{"id", "name"},
 			rDat: [][]string{
-				{"4", ""},
+				{"4", "NULL"},
 				{"3", "radio"},
 			},
 		},
@@ -254,97 +270,2442 @@ func TestE2E(t *testing.T) {
 				{"laptop"},
 			},
 		},
-	}
 
-	// prepare test
-	os.Setenv("INCDB_TEST", "1")
-	t.Cleanup(func() { os.Unsetenv("INCDB_TEST") })
+		// typed columns
+		{
+			query: "create table person (id int, name string, height float, admin bool, born timestamp)",
+			msg:   "table person created",
+		},
+		{
+			query: `insert into person values (10, "alice", 1.62, true, "2000-01-02 03:04:05")`,
+			msg:   "inserted",
+		},
+		{
+			query: `insert into person values (9, "bob", 1.8, false, "1999-12-31")`,
+			msg:   "inserted",
+		},
+		{
+			query: `insert into person (id, name) values ("-2", "chris")`,
+			msg:   "inserted",
+		},
+		{
+			query:  `insert into person (id, name) values ("two", "donald")`,
+			errMsg: "invalid value for column 'id': 'two' is not a valid int",
+		},
+		{
+			query:  `insert into person (name, admin) values ("donald", "yes")`,
+			errMsg: "invalid value for column 'admin': 'yes' is not a valid bool",
+		},
+		{
+			query: "select * from person order by id",
+			rHdr:  []string{"id", "name", "height", "admin", "born"},
+			rDat: [][]string{
+				{"-2", "chris", "NULL", "NULL", "NULL"},
+				{"9", "bob", "1.8", "false", "1999-12-31 00:00:00"},
+				{"10", "alice", "1.62", "true", "2000-01-02 03:04:05"},
+			},
+		},
+		{
+			query: "select name from person order by height desc",
+			rHdr:  []string{"name"},
+			rDat: [][]string{
+				{"chris"},
+				{"bob"},
+				{"alice"},
+			},
+		},
+		{
+			query: "select name from person order by born",
+			rHdr:  []string{"name"},
+			rDat: [][]string{
+				{"bob"},
+				{"alice"},
+				{"chris"},
+			},
+		},
+		{
+			query: "select name from person where id = 9",
+			rHdr:  []string{"name"},
+			rDat:  [][]string{{"bob"}},
+		},
+		{
+			query: "select name from person where height = 1.62",
+			rHdr:  []string{"name"},
+			rDat:  [][]string{{"alice"}},
+		},
+		{
+			query: "select name from person where admin != true",
+			rHdr:  []string{"name"},
+			rDat:  [][]string{{"bob"}},
+		},
+		{
+			query: "select name from person where born = '2000-01-02T03:04:05Z'",
+			rHdr:  []string{"name"},
+			rDat:  [][]string{{"alice"}},
+		},
+		{
+			query:  "select name from person where id = 'x'",
+			errMsg: "invalid value for column 'id'",
+		},
+		{
+			query: "select name from person where id < 9",
+			rHdr:  []string{"name"},
+			rDat:  [][]string{{"chris"}},
+		},
+		{
+			query: "select name from person where id >= 9 order by id",
+			rHdr:  []string{"name"},
+			rDat:  [][]string{{"bob"}, {"alice"}},
+		},
+		{
+			query: "select name from person where height > 1.7 or height <= 1.62",
+			rHdr:  []string{"name"},
+			rDat:  [][]string{{"alice"}, {"bob"}},
+		},
+		{
+			query: "select name from person where born < '2000-01-01'",
+			rHdr:  []string{"name"},
+			rDat:  [][]string{{"bob"}},
+		},
+		{
+			query: "select name from person where name like '%o%'",
+			rHdr:  []string{"name"},
+			rDat:  [][]string{{"bob"}},
+		},
+		{
+			query: "select name from person where name like '_li%' or name not like '%s'",
+			rHdr:  []string{"name"},
+			rDat:  [][]string{{"alice"}, {"bob"}},
+		},
+		{
+			query: "select name from person where id in (1, 9, -2) order by id",
+			rHdr:  []string{"name"},
+			rDat:  [][]string{{"chris"}, {"bob"}},
+		},
+		{
+			// not in with NULL in the list is never true
+			query: "select name from person where id not in (9, null)",
+			msg:   "no results",
+		},
+		{
+			query: "select name from person where id not in (9)",
+			rHdr:  []string{"name"},
+			rDat:  [][]string{{"alice"}, {"chris"}},
+		},
+		{
+			query: "select name from person where id between -2 and 9 and admin is null",
+			rHdr:  []string{"name"},
+			rDat:  [][]string{{"chris"}},
+		},
+		{
+			query: "select name from person where height not between 1.7 and 2",
+			rHdr:  []string{"name"},
+			rDat:  [][]string{{"alice"}},
+		},
+		{
+			query:  "select name from person where id in ('x')",
+			errMsg: "invalid value for column 'id'",
+		},
+		{
+			query:  "select name from person where id between 1",
+			errMsg: "and is expected",
+		},
 
-	exec.Command("rm", "-f", "./data/test.incdb.data").Run()
-	exec.Command("rm", "-f", "./data/test.incdb.catalog").Run()
-	incdbd := exec.Command("./incdbd")
-	if err := incdbd.Start(); err != nil {
-		t.Fatal(err)
-	}
-	t.Cleanup(func() {
-		process, err := os.FindProcess(incdbd.Process.Pid)
-		if err != nil {
-			t.Fatal(err)
-		}
-		if err = process.Kill(); err != nil {
-			t.Fatal(err)
-		}
-	})
+		// null and default
+		{
+			query:  "create table account (id int not null, plan string default 1.5, active bool default 'yes')",
+			errMsg: "invalid default value for column 'active'",
+		},
+		{
+			query: "create table account (id int not null, plan string default 'free', active bool not null default true, note string null)",
+			msg:   "table account created",
+		},
+		{
+			query: "insert into account (id) values (1)",
+			msg:   "inserted",
+		},
+		{
+			query: "insert into account values (2, null, false, 'vip')",
+			msg:   "inserted",
+		},
+		{
+			query: "insert into account (id, plan, note) values (3, 'pro', null)",
+			msg:   "inserted",
+		},
+		{
+			query:  "insert into account (plan) values ('pro')",
+			errMsg: "column 'id' must not be null",
+		},
+		{
+			query:  "insert into account (id, active) values (4, null)",
+			errMsg: "column 'active' must not be null",
+		},
+		{
+			query: "select * from account",
+			rHdr:  []string{"id", "plan", "active", "note"},
+			rDat: [][]string{
+				{"1", "free", "true", "NULL"},
+				{"2", "NULL", "false", "vip"},
+				{"3", "pro", "true", "NULL"},
+			},
+		},
+		{
+			query: "select id from account where plan is null",
+			rHdr:  []string{"id"},
+			rDat:  [][]string{{"2"}},
+		},
+		{
+			query: "select id from account where note is not null",
+			rHdr:  []string{"id"},
+			rDat:  [][]string{{"2"}},
+		},
+		{
+			query: "select id from account where plan != 'free'",
+			rHdr:  []string{"id"},
+			rDat:  [][]string{{"3"}},
+		},
 
-	time.Sleep(time.Second)
+		// compound where
+		{
+			query: "select id from account where plan = 'free' or plan = 'pro'",
+			rHdr:  []string{"id"},
+			rDat:  [][]string{{"1"}, {"3"}},
+		},
+		{
+			query: "select id from account where active = true and plan != 'free'",
+			rHdr:  []string{"id"},
+			rDat:  [][]string{{"3"}},
+		},
+		{
+			query: "select id from account where not active",
+			rHdr:  []string{"id"},
+			rDat:  [][]string{{"2"}},
+		},
+		{
+			// "and" binds tighter than "or"
+			query: "select id from account where plan = 'pro' or plan = 'free' and active = false",
+			rHdr:  []string{"id"},
+			rDat:  [][]string{{"3"}},
+		},
+		{
+			query: "select id from account where (plan = 'pro' or plan = 'free') and active = false",
+			msg:   "no results",
+		},
+		{
+			// NULL or true is true
+			query: "select id from account where not plan = 'free' or id = 2",
+			rHdr:  []string{"id"},
+			rDat:  [][]string{{"2"}, {"3"}},
+		},
+		{
+			// NULL and true is NULL, so not (NULL) is not true either
+			query: "select id from account where not (plan = 'free' and id = 2)",
+			rHdr:  []string{"id"},
+			rDat:  [][]string{{"1"}, {"3"}},
+		},
+		{
+			query: "select id from account where (id = 1 or id = 2) and (note is null or not active) order by id desc",
+			rHdr:  []string{"id"},
+			rDat:  [][]string{{"2"}, {"1"}},
+		},
+		{
+			query:  "select id from account where (id = 1 or id = 2",
+			errMsg: ") is expected",
+		},
 
-	for _, tc := range tests {
-		out, err := exec.Command("./incdb", tc.query).CombinedOutput()
-		if err != nil {
-			t.Fatalf("[%s] err: out: %s", tc.query, string(out))
-		}
+		// update
+		{
+			query: "update account set plan = 'pro', note = 'upgraded' where id = 1",
+			msg:   "1 rows updated",
+		},
+		{
+			query:  "update account set active = null where plan = 'pro'",
+			errMsg: "column 'active' must not be null",
+		},
+		{
+			query:  "update account set foo = 1",
+			errMsg: "column 'foo' is not found in table 'account'",
+		},
+		{
+			query:  "update account set id = 'x' where id = 99",
+			errMsg: "invalid value for column 'id'",
+		},
+		{
+			query: "update account set plan = 'free' where id = 99",
+			msg:   "0 rows updated",
+		},
+		{
+			query: "select * from account order by id", // the updated record is moved to the new version
+			rHdr:  []string{"id", "plan", "active", "note"},
+			rDat: [][]string{
+				{"1", "pro", "true", "upgraded"},
+				{"2", "NULL", "false", "vip"},
+				{"3", "pro", "true", "NULL"},
+			},
+		},
+		{
+			query: "update account set active = false, note = null where plan = 'pro' and active",
+			msg:   "2 rows updated",
+		},
+		{
+			query: "select * from account order by id",
+			rHdr:  []string{"id", "plan", "active", "note"},
+			rDat: [][]string{
+				{"1", "pro", "false", "NULL"},
+				{"2", "NULL", "false", "vip"},
+				{"3", "pro", "false", "NULL"},
+			},
+		},
+		{
+			// the records no longer fit in a page, so they are moved to other pages
+			query: "update account set note = '" + strings.Repeat("a", 2000) + "'",
+			msg:   "3 rows updated",
+		},
+		{
+			query: "select id from account where note like 'aaa%' order by id",
+			rHdr:  []string{"id"},
+			rDat:  [][]string{{"1"}, {"2"}, {"3"}},
+		},
 
-		// verify output
-		if tc.msg != "" {
-			// check message output
-			if o := strings.TrimSuffix(string(out), "\n"); tc.msg != o {
-				t.Fatalf("[%s] out: expected: '%s', got: '%s'", tc.query, tc.msg, o)
-			}
-		} else {
-			// check query result
-			type Output struct {
-				Hdr  []string
-				Vals [][]string
-			}
+		// delete
+		{
+			query: "delete from account where id = 2",
+			msg:   "1 rows deleted",
+		},
+		{
+			query:  "delete from account where foo = 1",
+			errMsg: "column 'foo' is not found",
+		},
+		{
+			query: "delete from account where id = 99",
+			msg:   "0 rows deleted",
+		},
+		{
+			query: "select id from account order by id",
+			rHdr:  []string{"id"},
+			rDat:  [][]string{{"1"}, {"3"}},
+		},
+		{
+			query: "delete from account",
+			msg:   "2 rows deleted",
+		},
+		{
+			query: "select * from account",
+			msg:   "no results",
+		},
+		{
+			query: "insert into account (id) values (5)",
+			msg:   "inserted",
+		},
+		{
+			query: "select * from account",
+			rHdr:  []string{"id", "plan", "active", "note"},
+			rDat:  [][]string{{"5", "free", "true", "NULL"}},
+		},
 
-			var o Output
-			if err := json.Unmarshal(out, &o); err != nil {
-				t.Fatalf("[%s] unmarshal output into json: %v", tc.query, err)
-			}
+		// drop table
+		{
+			query: "drop table user",
+			msg:   "table user dropped",
+		},
+		{
+			query:  "select * from user",
+			errMsg: "table 'user' not found",
+		},
+		{
+			query:  "drop table user",
+			errMsg: "table 'user' not found",
+		},
+		{
+			query: "drop table if exists user",
+			msg:   "table user not found, skipped",
+		},
+		{
+			query: "create table user (id int)",
+			msg:   "table user created",
+		},
+		{
+			query:  "create table user (id int)",
+			errMsg: "table 'user' already exists",
+		},
+		{
+			query: "create table if not exists user (id int, name string)",
+			msg:   "table user already exists, skipped",
+		},
+		{
+			query:  "create table tag (id int, id string)",
+			errMsg: "column 'id' is specified more than once",
+		},
+		{
+			query:  "select * from tag",
+			errMsg: "table 'tag' not found",
+		},
+		{
+			query: "create table if not exists tag (id int)",
+			msg:   "table tag created",
+		},
+
+		// alter table
+		{
+			query: "alter table item add column price int default 100",
+			msg:   "table item altered",
+		},
+		{
+			query: "select * from item",
+			rHdr:  []string{"id", "name", "price"},
+			rDat: [][]string{
+				{"1", "laptop", "100"},
+				{"2", "iPhone", "100"},
+				{"3", "radio", "100"},
+				{"4", "NULL", "100"},
+			},
+		},
+		{
+			query:  "alter table item add stock int not null",
+			errMsg: "column 'stock' must have default value to be not null because table 'item' is not empty",
+		},
+		{
+			query:  "alter table item add name string",
+			errMsg: "column 'name' already exists in table 'item'",
+		},
+		{
+			query:  "alter table item add qty int default 'x'",
+			errMsg: "invalid default value for column 'qty'",
+		},
+		{
+			query: "insert into item values ('5', 'pen', 3)",
+			msg:   "inserted",
+		},
+		{
+			query: "alter table item drop column name",
+			msg:   "table item altered",
+		},
+		{
+			query:  "alter table item drop column foo",
+			errMsg: "column 'foo' is not found in table 'item'",
+		},
+		{
+			query: "select * from item where price = 100",
+			rHdr:  []string{"id", "price"},
+			rDat:  [][]string{{"1", "100"}, {"2", "100"}, {"3", "100"}, {"4", "100"}},
+		},
+		{
+			query: "alter table item rename column price to cost",
+			msg:   "table item altered",
+		},
+		{
+			query:  "alter table item rename cost to id",
+			errMsg: "column 'id' already exists in table 'item'",
+		},
+		{
+			query: "alter table item rename to product",
+			msg:   "table item altered",
+		},
+		{
+			query:  "alter table product rename to user",
+			errMsg: "table 'user' already exists",
+		},
+		{
+			query:  "select * from item",
+			errMsg: "table 'item' not found",
+		},
+		{
+			query: "select id, cost from product where cost < 100",
+			rHdr:  []string{"id", "cost"},
+			rDat:  [][]string{{"5", "3"}},
+		},
+		{
+			query: "insert into product (id) values ('6')",
+			msg:   "inserted",
+		},
+		{
+			query: "select * from product where id > '4'",
+			rHdr:  []string{"id", "cost"},
+			rDat:  [][]string{{"5", "3"}, {"6", "100"}},
+		},
+
+		// primary key and unique
+		{
+			query:  "create table member (id int primary key, email string unique, name string, code int primary key)",
+			errMsg: "multiple primary keys are not allowed",
+		},
+		{
+			query: "create table member (id int primary key, email string unique, name string)",
+			msg:   "table member created",
+		},
+		{
+			query: "insert into member values (1, 'a@x', 'alice')",
+			msg:   "inserted",
+		},
+		{
+			query:  "insert into member values (1, 'b@x', 'bob')",
+			errMsg: "duplicate key (id)=(1) violates unique constraint 'member_pkey'",
+		},
+		{
+			query:  "insert into member values (2, 'a@x', 'bob')",
+			errMsg: "duplicate key (email)=(a@x) violates unique constraint 'member_email_key'",
+		},
+		{
+			query:  "insert into member (email, name) values ('b@x', 'bob')",
+			errMsg: "column 'id' must not be null",
+		},
+		{
+			// NULL is not equal to another NULL
+			query: "insert into member (id, name) values (2, 'bob')",
+			msg:   "inserted",
+		},
+		{
+			query: "insert into member (id, name) values (3, 'chris')",
+			msg:   "inserted",
+		},
+		{
+			query:  "update member set id = 1 where id = 2",
+			errMsg: "duplicate key (id)=(1) violates unique constraint 'member_pkey'",
+		},
+		{
+			query:  "update member set email = 'z@x'",
+			errMsg: "duplicate key (email)=(z@x) violates unique constraint 'member_email_key'",
+		},
+		{
+			query: "update member set id = 10 where id = 1",
+			msg:   "1 rows updated",
+		},
+		{
+			query: "insert into member values (1, 'd@x', 'don')",
+			msg:   "inserted",
+		},
+		{
+			query: "delete from member where id = 10",
+			msg:   "1 rows deleted",
+		},
+		{
+			query: "insert into member values (10, 'a@x', 'alice')",
+			msg:   "inserted",
+		},
+		{
+			query: "select * from member order by id",
+			rHdr:  []string{"id", "email", "name"},
+			rDat: [][]string{
+				{"1", "d@x", "don"},
+				{"2", "NULL", "bob"},
+				{"3", "NULL", "chris"},
+				{"10", "a@x", "alice"},
+			},
+		},
+		{
+			query:  "alter table member add column rank int unique default 1",
+			errMsg: "duplicate key (rank)=(1) violates unique constraint 'member_rank_key'",
+		},
+		{
+			query: "alter table member add column code int unique",
+			msg:   "table member altered",
+		},
+		{
+			query: "update member set code = 1 where id = 1",
+			msg:   "1 rows updated",
+		},
+		{
+			query:  "update member set code = 1 where id = 2",
+			errMsg: "duplicate key (code)=(1) violates unique constraint 'member_code_key'",
+		},
+		{
+			query: "create table membership (tenant int, id int, role string, primary key (tenant, id), unique (role, tenant))",
+			msg:   "table membership created",
+		},
+		{
+			query: "insert into membership values (1, 1, 'admin')",
+			msg:   "inserted",
+		},
+		{
+			query: "insert into membership values (1, 2, 'user')",
+			msg:   "inserted",
+		},
+		{
+			query: "insert into membership values (2, 1, 'admin')",
+			msg:   "inserted",
+		},
+		{
+			query:  "insert into membership values (1, 1, 'guest')",
+			errMsg: "duplicate key (tenant, id)=(1, 1) violates unique constraint 'membership_pkey'",
+		},
+		{
+			query:  "insert into membership values (1, 3, 'admin')",
+			errMsg: "duplicate key (role, tenant)=(admin, 1) violates unique constraint 'membership_role_tenant_key'",
+		},
+		{
+			query: "alter table membership drop column role",
+			msg:   "table membership altered",
+		},
+		{
+			query: "insert into membership values (1, 3)",
+			msg:   "inserted",
+		},
+		{
+			query: "alter table membership rename column id to uid",
+			msg:   "table membership altered",
+		},
+		{
+			query:  "insert into membership values (1, 3)",
+			errMsg: "duplicate key (tenant, uid)=(1, 3) violates unique constraint 'membership_pkey'",
+		},
+		{
+			query: "create table book (id int primary key, title string, price int)",
+			msg:   "table book created",
+		},
+		{
+			query: "insert into book values (1, 'go', 30)",
+			msg:   "inserted",
+		},
+		{
+			query: "insert into book values (2, 'sql', 20)",
+			msg:   "inserted",
+		},
+		{
+			query: "insert into book values (3, 'rust', 40)",
+			msg:   "inserted",
+		},
+		{
+			query: "insert into book values (4, 'c', null)",
+			msg:   "inserted",
+		},
+		{
+			query: "create index bookprice on book (price)",
+			msg:   "index bookprice created",
+		},
+		{
+			query:  "create index bookprice on book (title)",
+			errMsg: "index 'bookprice' already exists in table 'book'",
+		},
+		{
+			query:  "create index bookisbn on book (isbn)",
+			errMsg: "column 'isbn' of index 'bookisbn' is not found in table 'book'",
+		},
+		{
+			query:  "create index booktitle on nobook (title)",
+			errMsg: "table 'nobook' not found",
+		},
+		{
+			query: "insert into book values (5, 'java', 20)",
+			msg:   "inserted",
+		},
+		{
+			query: "select * from book where price = 20",
+			rHdr:  []string{"id", "title", "price"},
+			rDat: [][]string{
+				{"2", "sql", "20"},
+				{"5", "java", "20"},
+			},
+		},
+		{
+			query: "select title from book where price > 20 and price <= 40",
+			rHdr:  []string{"title"},
+			rDat: [][]string{
+				{"go"},
+				{"rust"},
+			},
+		},
+		{
+			query: "select title from book where 30 < price or id = 4",
+			rHdr:  []string{"title"},
+			rDat: [][]string{
+				{"rust"},
+				{"c"},
+			},
+		},
+		{
+			query: "select title from book where price between 20 and 30 and title != 'sql'",
+			rHdr:  []string{"title"},
+			rDat: [][]string{
+				{"go"},
+				{"java"},
+			},
+		},
+		{
+			query: "select title from book where price >= 10",
+			rHdr:  []string{"title"},
+			rDat: [][]string{
+				{"go"},
+				{"sql"},
+				{"rust"},
+				{"java"},
+			},
+		},
+		{
+			query: "update book set price = 50 where id = 2",
+			msg:   "1 rows updated",
+		},
+		{
+			query: "delete from book where title = 'java'",
+			msg:   "1 rows deleted",
+		},
+		{
+			query: "select title from book where price < 100 order by id",
+			rHdr:  []string{"title"},
+			rDat: [][]string{
+				{"go"},
+				{"sql"},
+				{"rust"},
+			},
+		},
+		{
+			query: "select title from book where price = 20",
+			msg:   "no results",
+		},
+		{
+			query:  "select title from book where price = 'cheap'",
+			errMsg: "invalid value for column 'price'",
+		},
+		{
+			query: "create unique index booktitle on book (title)",
+			msg:   "index booktitle created",
+		},
+		{
+			query:  "insert into book values (6, 'go', 10)",
+			errMsg: "duplicate key (title)=(go) violates unique constraint 'booktitle'",
+		},
+		{
+			query: "create unique index bookpricekey on book (price)",
+			msg:   "index bookpricekey created",
+		},
+		{
+			query:  "insert into book values (6, 'perl', 30)",
+			errMsg: "duplicate key (price)=(30) violates unique constraint 'bookpricekey'",
+		},
+		{
+			query: "insert into book values (6, 'perl', 10)",
+			msg:   "inserted",
+		},
+		{
+			query: "select id from book where title = 'perl'",
+			rHdr:  []string{"id"},
+			rDat: [][]string{
+				{"6"},
+			},
+		},
+		{
+			query: "create table tenantuser (tenant int, id int, name string)",
+			msg:   "table tenantuser created",
+		},
+		{
+			query: "insert into tenantuser values (2, 2, 'dave')",
+			msg:   "inserted",
+		},
+		{
+			query: "insert into tenantuser values (1, 3, 'carol')",
+			msg:   "inserted",
+		},
+		{
+			query: "insert into tenantuser values (1, 1, 'alice')",
+			msg:   "inserted",
+		},
+		{
+			query: "insert into tenantuser values (null, 9, 'nobody')",
+			msg:   "inserted",
+		},
+		{
+			query: "insert into tenantuser values (2, 1, 'erin')",
+			msg:   "inserted",
+		},
+		{
+			query: "insert into tenantuser values (1, 2, 'bob')",
+			msg:   "inserted",
+		},
+		{
+			query:  "create index tenantuserid on tenantuser (id, id)",
+			errMsg: "column 'id' is specified more than once in index 'tenantuserid'",
+		},
+		{
+			query: "create unique index tenantusertenant on tenantuser (tenant, id)",
+			msg:   "index tenantusertenant created",
+		},
+		{
+			query:  "insert into tenantuser values (1, 2, 'bill')",
+			errMsg: "duplicate key (tenant, id)=(1, 2) violates unique constraint 'tenantusertenant'",
+		},
+		{
+			query: "select * from tenantuser where tenant = 1",
+			rHdr:  []string{"tenant", "id", "name"},
+			rDat: [][]string{
+				{"1", "3", "carol"},
+				{"1", "1", "alice"},
+				{"1", "2", "bob"},
+			},
+		},
+		{
+			query: "select name from tenantuser where tenant = 1 and id >= 2 order by id desc",
+			rHdr:  []string{"name"},
+			rDat: [][]string{
+				{"carol"},
+				{"bob"},
+			},
+		},
+		{
+			query: "select * from tenantuser where id = 1 order by tenant",
+			rHdr:  []string{"tenant", "id", "name"},
+			rDat: [][]string{
+				{"1", "1", "alice"},
+				{"2", "1", "erin"},
+			},
+		},
+		{
+			query: "select id, tenant from tenantuser where tenant = 2 order by id",
+			rHdr:  []string{"tenant", "id"},
+			rDat: [][]string{
+				{"2", "1"},
+				{"2", "2"},
+			},
+		},
+		{
+			query: "select tenant, id from tenantuser order by tenant limit 4 offset 2",
+			rHdr:  []string{"tenant", "id"},
+			rDat: [][]string{
+				{"1", "3"},
+				{"2", "1"},
+				{"2", "2"},
+				{"NULL", "9"},
+			},
+		},
+		{
+			query: "select id from tenantuser where tenant > 1 or tenant is null order by tenant desc",
+			rHdr:  []string{"id"},
+			rDat: [][]string{
+				{"9"},
+				{"2"},
+				{"1"},
+			},
+		},
+		{
+			query: "update tenantuser set id = 5 where name = 'alice'",
+			msg:   "1 rows updated",
+		},
+		{
+			query: "select id from tenantuser where tenant = 1 and id between 2 and 5 order by id",
+			rHdr:  []string{"id"},
+			rDat: [][]string{
+				{"2"},
+				{"3"},
+				{"5"},
+			},
+		},
+		{
+			query: "create table dept (id int primary key, name string)",
+			msg:   "table dept created",
+		},
+		{
+			query: "insert into dept values (1, 'sales')",
+			msg:   "inserted",
+		},
+		{
+			query: "insert into dept values (2, 'dev')",
+			msg:   "inserted",
+		},
+		{
+			query: "insert into dept values (3, 'hr')",
+			msg:   "inserted",
+		},
+		{
+			query: "create table emp (id int, name string, dept int, boss int)",
+			msg:   "table emp created",
+		},
+		{
+			query: "insert into emp values (1, 'alice', 2, null)",
+			msg:   "inserted",
+		},
+		{
+			query: "insert into emp values (2, 'bob', 1, 1)",
+			msg:   "inserted",
+		},
+		{
+			query: "insert into emp values (3, 'chris', 2, 1)",
+			msg:   "inserted",
+		},
+		{
+			query: "insert into emp values (4, 'dan', null, 2)",
+			msg:   "inserted",
+		},
+		{
+			query: "select e.name, d.name from emp e join dept d on e.dept = d.id",
+			rHdr:  []string{"name", "name"},
+			rDat: [][]string{
+				{"alice", "dev"},
+				{"bob", "sales"},
+				{"chris", "dev"},
+			},
+		},
+		{
+			query: "select * from emp inner join dept on dept.id = emp.dept where dept.name = 'dev' order by emp.id desc",
+			rHdr:  []string{"id", "name", "dept", "boss", "id", "name"},
+			rDat: [][]string{
+				{"3", "chris", "2", "1", "2", "dev"},
+				{"1", "alice", "2", "NULL", "2", "dev"},
+			},
+		},
+		{
+			query: "select e.name, d.id from emp as e left outer join dept as d on e.dept = d.id and d.name != 'sales'",
+			rHdr:  []string{"name", "id"},
+			rDat: [][]string{
+				{"alice", "2"},
+				{"bob", "NULL"},
+				{"chris", "2"},
+				{"dan", "NULL"},
+			},
+		},
+		{
+			query: "select d.name from dept d left join emp e on e.dept = d.id where e.id is null",
+			rHdr:  []string{"name"},
+			rDat: [][]string{
+				{"hr"},
+			},
+		},
+		{
+			query: "select e.name, b.name from emp e join emp b on e.boss = b.id order by e.name desc",
+			rHdr:  []string{"name", "name"},
+			rDat: [][]string{
+				{"dan", "bob"},
+				{"chris", "alice"},
+				{"bob", "alice"},
+			},
+		},
+		{
+			query: "select e.name, d.name from emp e join dept d on e.dept < d.id where e.id <= 2",
+			rHdr:  []string{"name", "name"},
+			rDat: [][]string{
+				{"alice", "hr"},
+				{"bob", "dev"},
+				{"bob", "hr"},
+			},
+		},
+		{
+			query: "select e.id, d.id from emp e cross join dept d where e.id = 1",
+			rHdr:  []string{"id", "id"},
+			rDat: [][]string{
+				{"1", "1"},
+				{"1", "2"},
+				{"1", "3"},
+			},
+		},
+		{
+			query: "select e.name, b.name, d.name from emp e join emp b on e.boss = b.id left join dept d on b.dept = d.id where e.id > 2",
+			rHdr:  []string{"name", "name", "name"},
+			rDat: [][]string{
+				{"chris", "alice", "dev"},
+				{"dan", "bob", "sales"},
+			},
+		},
+		{
+			query: "select d.name from dept d where d.id = 3",
+			rHdr:  []string{"name"},
+			rDat: [][]string{
+				{"hr"},
+			},
+		},
+		{
+			query:  "select name from emp join dept on dept = dept.id",
+			errMsg: "column reference 'name' is ambiguous",
+		},
+		{
+			query:  "select e.name from emp e join dept d on e.dept = dept.id",
+			errMsg: "column 'dept.id' is not found",
+		},
+		{
+			query:  "select emp.name from emp e",
+			errMsg: "column 'emp.name' is not found",
+		},
+		{
+			query:  "select * from emp join emp on emp.boss = emp.id",
+			errMsg: "table name 'emp' is specified more than once",
+		},
+		{
+			query:  "select * from emp join nodept on emp.dept = nodept.id",
+			errMsg: "table 'nodept' not found",
+		},
+		{
+			query: "select name from emp where dept in (select id from dept where name = 'dev')",
+			rHdr:  []string{"name"},
+			rDat: [][]string{
+				{"alice"},
+				{"chris"},
+			},
+		},
+		{
+			query: "select name from emp where dept not in (select id from dept where name = 'dev')",
+			rHdr:  []string{"name"},
+			rDat: [][]string{
+				{"bob"},
+			},
+		},
+		{
+			query: "select name from dept d where exists (select * from emp e where e.dept = d.id)",
+			rHdr:  []string{"name"},
+			rDat: [][]string{
+				{"sales"},
+				{"dev"},
+			},
+		},
+		{
+			query: "select name from dept d where not exists (select * from emp where dept = d.id)",
+			rHdr:  []string{"name"},
+			rDat: [][]string{
+				{"hr"},
+			},
+		},
+		{
+			query: "select name from emp where dept = (select id from dept where name = 'sales')",
+			rHdr:  []string{"name"},
+			rDat: [][]string{
+				{"bob"},
+			},
+		},
+		{
+			query: "select e.name from emp e where e.dept = (select d.id from dept d where d.id = e.dept and d.name = 'dev')",
+			rHdr:  []string{"name"},
+			rDat: [][]string{
+				{"alice"},
+				{"chris"},
+			},
+		},
+		{
+			query: "select name from emp where (select name from dept where id = 9) is null and id = 1",
+			rHdr:  []string{"name"},
+			rDat: [][]string{
+				{"alice"},
+			},
+		},
+		{
+			query:  "select name from emp where dept = (select id from dept)",
+			errMsg: "more than one row returned by a subquery used as an expression",
+		},
+		{
+			query:  "select name from emp where dept in (select * from dept)",
+			errMsg: "subquery must return only one column",
+		},
+		{
+			query:  "select name from emp where exists (select * from dept where nocol = 1)",
+			errMsg: "column 'nocol' is not found",
+		},
+		{
+			query: "select x.name from (select name, dept from emp where dept = 2) as x order by x.name desc",
+			rHdr:  []string{"name"},
+			rDat: [][]string{
+				{"chris"},
+				{"alice"},
+			},
+		},
+		{
+			query: "select x.name, d.name from (select * from emp where boss = 1) x join dept d on x.dept = d.id",
+			rHdr:  []string{"name", "name"},
+			rDat: [][]string{
+				{"bob", "sales"},
+				{"chris", "dev"},
+			},
+		},
+		{
+			query:  "select * from (select * from emp)",
+			errMsg: "subquery in from must have an alias",
+		},
+		{
+			query: "update emp set boss = 2 where dept in (select id from dept where name = 'sales')",
+			msg:   "1 rows updated",
+		},
+		{
+			query: "delete from dept where not exists (select * from emp where emp.dept = dept.id)",
+			msg:   "1 rows deleted",
+		},
+		{
+			query: "select name from dept",
+			rHdr:  []string{"name"},
+			rDat: [][]string{
+				{"sales"},
+				{"dev"},
+			},
+		},
+
+		// aggregate
+		{
+			query: "create table sale (id int, shop string, item string, qty int, price float)",
+			msg:   "table sale created",
+		},
+		{
+			query: "select count(*), count(qty), sum(qty), avg(price), min(item), max(price) from sale",
+			rHdr:  []string{"count", "count", "sum", "avg", "min", "max"},
+			rDat: [][]string{
+				{"0", "0", "NULL", "NULL", "NULL", "NULL"},
+			},
+		},
+		{
+			query: "select shop, count(*) from sale group by shop",
+			msg:   "no results",
+		},
+		{
+			query: "insert into sale values (1, 'tokyo', 'pen', 3, 1.5)",
+			msg:   "inserted",
+		},
+		{
+			query: "insert into sale values (2, 'osaka', 'pen', 1, 1.5)",
+			msg:   "inserted",
+		},
+		{
+			query: "insert into sale values (3, 'tokyo', 'ink', 2, 4.0)",
+			msg:   "inserted",
+		},
+		{
+			query: "insert into sale values (4, 'tokyo', 'pen', null, 2.0)",
+			msg:   "inserted",
+		},
+		{
+			query: "insert into sale values (5, null, 'ink', 5, null)",
+			msg:   "inserted",
+		},
+		{
+			query: "select count(*), count(qty), sum(qty), avg(price), min(item), max(price) from sale",
+			rHdr:  []string{"count", "count", "sum", "avg", "min", "max"},
+			rDat: [][]string{
+				{"5", "4", "11", "2.25", "ink", "4"},
+			},
+		},
+		{
+			query: "select count(*), sum(qty) from sale where shop = 'tokyo'",
+			rHdr:  []string{"count", "sum"},
+			rDat: [][]string{
+				{"3", "5"},
+			},
+		},
+		{
+			query: "select shop, count(*), sum(qty) from sale group by shop",
+			rHdr:  []string{"shop", "count", "sum"},
+			rDat: [][]string{
+				{"tokyo", "3", "5"},
+				{"osaka", "1", "1"},
+				{"NULL", "1", "5"},
+			},
+		},
+		{
+			query: "select shop, item, count(*) from sale group by shop, item order by shop",
+			rHdr:  []string{"shop", "item", "count"},
+			rDat: [][]string{
+				{"osaka", "pen", "1"},
+				{"tokyo", "pen", "2"},
+				{"tokyo", "ink", "1"},
+				{"NULL", "ink", "1"},
+			},
+		},
+		{
+			query: "select item, sum(qty) from sale group by item having count(*) > 2 and max(price) < 3.0",
+			rHdr:  []string{"item", "sum"},
+			rDat: [][]string{
+				{"pen", "4"},
+			},
+		},
+		{
+			query: "select item from sale group by item having item = 'ink'",
+			rHdr:  []string{"item"},
+			rDat: [][]string{
+				{"ink"},
+			},
+		},
+		{
+			query: "select d.name, count(*) from emp e join dept d on e.dept = d.id group by d.name",
+			rHdr:  []string{"name", "count"},
+			rDat: [][]string{
+				{"dev", "2"},
+				{"sales", "1"},
+			},
+		},
+		{
+			query:  "select count(*) from sale group by nocol",
+			errMsg: "column 'nocol' is not found",
+		},
+		{
+			query: "select x.count from (select shop, count(*) from sale group by shop) x where x.shop = 'tokyo'",
+			rHdr:  []string{"count"},
+			rDat: [][]string{
+				{"3"},
+			},
+		},
+		{
+			query: "select name from dept d where (select count(*) from emp e where e.dept = d.id) = 2",
+			rHdr:  []string{"name"},
+			rDat: [][]string{
+				{"dev"},
+			},
+		},
+		{
+			query: "create index saleshop on sale (shop)",
+			msg:   "index saleshop created",
+		},
+		{
+			query: "select shop, count(*) from sale where shop >= 'osaka' group by shop having count(*) < 3",
+			rHdr:  []string{"shop", "count"},
+			rDat: [][]string{
+				{"osaka", "1"},
+			},
+		},
+		{
+			query:  "select shop, count(*) from sale",
+			errMsg: "column 'shop' must appear in the group by clause or be used in an aggregate function",
+		},
+		{
+			query:  "select shop from sale group by item having qty > 1",
+			errMsg: "column 'shop' must appear in the group by clause or be used in an aggregate function",
+		},
+		{
+			query:  "select item from sale group by item having qty > 1",
+			errMsg: "column 'qty' must appear in the group by clause or be used in an aggregate function",
+		},
+		{
+			query:  "select * from sale group by item",
+			errMsg: "'*' cannot be selected with aggregate functions or group by",
+		},
+		{
+			query:  "select sum(item) from sale",
+			errMsg: "sum is not defined for string column 'item'",
+		},
+		{
+			query:  "select item from sale where count(*) > 1",
+			errMsg: "aggregate function count(*) is not allowed here",
+		},
+		{
+			query:  "select sum(*) from sale",
+			errMsg: "sum(*) is not allowed",
+		},
+
+		// distinct and order
+		{
+			query: "select distinct item from sale",
+			rHdr:  []string{"item"},
+			rDat: [][]string{
+				{"pen"},
+				{"ink"},
+			},
+		},
+		{
+			query: "select distinct shop, item from sale order by shop desc, item limit 3",
+			rHdr:  []string{"shop", "item"},
+			rDat: [][]string{
+				{"NULL", "ink"},
+				{"tokyo", "ink"},
+				{"tokyo", "pen"},
+			},
+		},
+		{
+			query: "select id, shop from sale order by shop nulls first, price desc",
+			rHdr:  []string{"id", "shop"},
+			rDat: [][]string{
+				{"5", "NULL"},
+				{"2", "osaka"},
+				{"3", "tokyo"},
+				{"4", "tokyo"},
+				{"1", "tokyo"},
+			},
+		},
+		{
+			query: "select id, qty from sale order by qty desc nulls last",
+			rHdr:  []string{"id", "qty"},
+			rDat: [][]string{
+				{"5", "5"},
+				{"1", "3"},
+				{"3", "2"},
+				{"2", "1"},
+				{"4", "NULL"},
+			},
+		},
+		{
+			// the records having the same key stay in the order they are read
+			query: "select id, item from sale order by item",
+			rHdr:  []string{"id", "item"},
+			rDat: [][]string{
+				{"3", "ink"},
+				{"5", "ink"},
+				{"1", "pen"},
+				{"2", "pen"},
+				{"4", "pen"},
+			},
+		},
+		{
+			query: "select id as no, item as product from sale where shop = 'tokyo' order by product desc, no desc",
+			rHdr:  []string{"no", "product"},
+			rDat: [][]string{
+				{"4", "pen"},
+				{"1", "pen"},
+				{"3", "ink"},
+			},
+		},
+		{
+			query: "select item, id from sale where shop = 'tokyo' order by 1, 2 desc",
+			rHdr:  []string{"id", "item"},
+			rDat: [][]string{
+				{"3", "ink"},
+				{"4", "pen"},
+				{"1", "pen"},
+			},
+		},
+		{
+			query: "select * from sale where qty > 1 order by 4 desc",
+			rHdr:  []string{"id", "shop", "item", "qty", "price"},
+			rDat: [][]string{
+				{"5", "NULL", "ink", "5", "NULL"},
+				{"1", "tokyo", "pen", "3", "1.5"},
+				{"3", "tokyo", "ink", "2", "4"},
+			},
+		},
+		{
+			query: "select item, count(*) as n, sum(qty) total from sale group by item order by n desc",
+			rHdr:  []string{"item", "n", "total"},
+			rDat: [][]string{
+				{"pen", "3", "4"},
+				{"ink", "2", "7"},
+			},
+		},
+		{
+			query: "select item as product, max(price) from sale group by item order by item, 2",
+			rHdr:  []string{"product", "max"},
+			rDat: [][]string{
+				{"ink", "4"},
+				{"pen", "2"},
+			},
+		},
+		{
+			query: "select x.product from (select distinct item as product from sale) x where x.product = 'pen'",
+			rHdr:  []string{"product"},
+			rDat: [][]string{
+				{"pen"},
+			},
+		},
+		{
+			query:  "select item from sale order by 2",
+			errMsg: "order by position 2 is not in select list",
+		},
+		{
+			query:  "select item from sale order by 0",
+			errMsg: "order by position 0 is not in select list",
+		},
+		{
+			query:  "select distinct item from sale order by id",
+			errMsg: "for select distinct, order by column 'id' must appear in select list",
+		},
+		{
+			query:  "select item from sale order by item nulls middle",
+			errMsg: "unexpected 'middle' after nulls, expected first or last",
+		},
+	}
+
+	// prepare test
+	os.Setenv("INCDB_TEST", "1")
+	t.Cleanup(func() { os.Unsetenv("INCDB_TEST") })
+
+	cleanTestData()
+	startIncdbd(t)
+
+	for _, tc := range tests {
+		out, err := exec.Command("./incdb", tc.query).CombinedOutput()
+		if tc.errMsg != "" {
+			if err == nil {
+				t.Fatalf("[%s] must fail but succeeded: out: %s", tc.query, string(out))
+			}
+
+			if !strings.Contains(string(out), tc.errMsg) {
+				t.Fatalf("[%s] err: expected: '%s', got: '%s'", tc.query, tc.errMsg, string(out))
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Fatalf("[%s] err: out: %s", tc.query, string(out))
+		}
+
+		// verify output
+		if tc.msg != "" {
+			// check message output
+			if o := strings.TrimSuffix(string(out), "\n"); tc.msg != o {
+				t.Fatalf("[%s] out: expected: '%s', got: '%s'", tc.query, tc.msg, o)
+			}
+		} else {
+			// check query result
+			type Output struct {
+				Hdr  []string
+				Vals [][]*string
+			}
+
+			var o Output
+			if err := json.Unmarshal(out, &o); err != nil {
+				t.Fatalf("[%s] unmarshal output into json: %v", tc.query, err)
+			}
+
+			// NULL is "NULL" in rDat
+			vals := [][]string{}
+			for _, row := range o.Vals {
+				r := make([]string, len(row))
+				for i, v := range row {
+					if v == nil {
+						r[i] = "NULL"
+					} else {
+						r[i] = *v
+					}
+				}
+				vals = append(vals, r)
+			}
 
 			if !reflect.DeepEqual(tc.rHdr, o.Hdr) {
 				t.Fatalf("[%s] out/header: expected: '%s', got: '%s'", tc.query, tc.rHdr, o.Hdr)
 			}
 
-			if !reflect.DeepEqual(tc.rDat, o.Vals) {
-				t.Fatalf("[%s] out/data: expected: '%s', got: '%s'", tc.query, tc.rDat, o.Vals)
-			}
+			if !reflect.DeepEqual(tc.rDat, vals) {
+				t.Fatalf("[%s] out/data: expected: '%s', got: '%s'", tc.query, tc.rDat, vals)
+			}
+		}
+
+		// verify data file
+		if tc.data != nil {
+			d := map[string][][]string{}
+			paths, _ := filepath.Glob("./data/test.incdb.heap.*")
+			for _, path := range paths {
+				rows := [][]string{}
+				if err := scanTuples(path, func(rid RID, ver Version, vals []any) error {
+					if ver.Xmax != 0 {
+						return nil // deleted by the committed transaction, as the queries are in autocommit mode
+					}
+
+					row := make([]string, len(vals))
+					for i, v := range vals {
+						row[i] = formatValue(v)
+					}
+					rows = append(rows, row)
+					return nil
+				}); err != nil {
+					t.Fatalf("[%s] read test heap file: %v", tc.query, err)
+				}
+				d[strings.TrimPrefix(path, "data/test.incdb.heap.")] = rows
+			}
+
+			if !reflect.DeepEqual(tc.data, d) {
+				t.Fatalf("[%s] data: expected: '%v', got: '%v'", tc.query, tc.data, d)
+			}
+		}
+
+		// verify catalog file
+		if tc.cat != nil {
+			c, err := os.ReadFile("./data/test.incdb.catalog")
+			if err != nil {
+				t.Fatalf("[%s] read test catalog file: %v", tc.query, err)
+			}
+			c = c[:len(c)-1] // trim new line
+
+			e, err := json.Marshal(&tc.cat)
+			if err != nil {
+				t.Fatalf("[%s] marshal expected catalog into json: %v", tc.query, err)
+			}
+
+			if !reflect.DeepEqual(c, e) {
+				t.Fatalf("[%s] catalog: expected: '%s', got: '%s'", tc.query, string(e), string(c))
+			}
+		}
+	}
+}
+
+func TestE2ERecoverFromWal(t *testing.T) {
+	os.Setenv("INCDB_TEST", "1")
+	t.Cleanup(func() { os.Unsetenv("INCDB_TEST") })
+
+	cleanTestData()
+
+	// Simulate incdbd died after appending WAL entries but before applying them to the tablespace file.
+	wal := `{"LSN":1,"Op":"create","Table":"item","Def":{"Name":"item","Cols":[{"Name":"id","Type":"string"},{"Name":"name","Type":"string"}]}}` + "\n"
+	wal += testWal(t, 2, []*WalEntry{
+		{Op: WalInsert, Xid: 1, Table: "item", Page: 0, Slot: 0, Tuple: testTuple(t, 1, "1", "laptop")},
+		{Op: WalInsert, Xid: 1, Table: "item", Page: 0, Slot: 1, Tuple: testTuple(t, 1, "2", "iPhone")},
+		{Op: WalCommit, Xid: 1},
+		// the new version is put in the next page
+		{Op: WalUpdate, Xid: 2, Table: "item", Page: 0, Slot: 1, Tuple: testTuple(t, 2, "2", "iPad"), To: &RID{Page: 1, Slot: 0}},
+		{Op: WalDelete, Xid: 2, Table: "item", Page: 0, Slot: 0},
+		{Op: WalCommit, Xid: 2},
+		// aborted on recovery because the commit is not found
+		{Op: WalInsert, Xid: 3, Table: "item", Page: 1, Slot: 1, Tuple: testTuple(t, 3, "4", "tv")},
+		{Op: WalDelete, Xid: 3, Table: "item", Page: 1, Slot: 0},
+	})
+	wal += `{"LSN":10,"Op":"insert","Ta`
+
+	if err := os.WriteFile("./data/test.incdb.wal.0000000000000001", []byte(wal), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	startIncdbd(t)
+
+	out, err := exec.Command("./incdb", `insert into item values ("3", "radio")`).CombinedOutput()
+	if err != nil {
+		t.Fatalf("insert: %s", string(out))
+	}
+
+	out, err = exec.Command("./incdb", "select * from item").CombinedOutput()
+	if err != nil {
+		t.Fatalf("select: %s", string(out))
+	}
+
+	// radio is put in the first page which has space
+	expected := `{"Hdr":["id","name"],"Vals":[["3","radio"],["2","iPad"]]}`
+	if o := strings.TrimSuffix(string(out), "\n"); o != expected {
+		t.Fatalf("select: expected: '%s', got: '%s'", expected, o)
+	}
+}
+
+func TestE2ERecoverDDLFromWal(t *testing.T) {
+	os.Setenv("INCDB_TEST", "1")
+	t.Cleanup(func() { os.Unsetenv("INCDB_TEST") })
+
+	cleanTestData()
+
+	// Simulate incdbd died after appending DDL entries but before applying them to the catalog and heap files.
+	wal := `{"LSN":1,"Op":"create","Table":"item","Def":{"Name":"item","Cols":[{"Name":"id","Type":"string"},{"Name":"name","Type":"string"}]}}` + "\n"
+	wal += testWal(t, 2, []*WalEntry{
+		{Op: WalInsert, Xid: 1, Table: "item", Page: 0, Slot: 0, Tuple: testTuple(t, 1, "1", "laptop")},
+		{Op: WalInsert, Xid: 1, Table: "item", Page: 0, Slot: 1, Tuple: testTuple(t, 1, "2", "iPhone")},
+		{Op: WalCommit, Xid: 1},
+	})
+	wal += `{"LSN":5,"Op":"alter","Table":"item","Def":{"Name":"product","Cols":[{"Name":"id","Type":"string"}]},"DropCol":1}` + "\n"
+	wal += `{"LSN":6,"Op":"create","Table":"tmp","Def":{"Name":"tmp","Cols":[{"Name":"id","Type":"int"}]}}` + "\n"
+	wal += `{"LSN":7,"Op":"drop","Table":"tmp"}` + "\n"
+	wal += `{"LSN":8,"Op":"create","Table":"item","Def":{"Name":"item","Cols":[{"Name":"id","Type":"int"}]}}` + "\n"
+	wal += testWal(t, 9, []*WalEntry{
+		{Op: WalInsert, Xid: 2, Table: "item", Page: 0, Slot: 0, Tuple: testTuple(t, 2, int64(9))},
+		{Op: WalCommit, Xid: 2},
+	})
+
+	if err := os.WriteFile("./data/test.incdb.wal.0000000000000001", []byte(wal), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	verify := func() {
+		t.Helper()
+		for query, expected := range map[string]string{
+			"select * from product": `{"Hdr":["id"],"Vals":[["1"],["2"]]}`,
+			"select * from item":    `{"Hdr":["id"],"Vals":[["9"]]}`,
+		} {
+			out, err := exec.Command("./incdb", query).CombinedOutput()
+			if err != nil {
+				t.Fatalf("[%s]: %s", query, string(out))
+			}
+
+			if o := strings.TrimSuffix(string(out), "\n"); o != expected {
+				t.Fatalf("[%s] expected: '%s', got: '%s'", query, expected, o)
+			}
+		}
+
+		if out, err := exec.Command("./incdb", "select * from tmp").CombinedOutput(); err == nil {
+			t.Fatalf("table tmp must not exist: %s", string(out))
+		}
+
+		if ok, err := heapExists("tmp"); err != nil || ok {
+			t.Fatalf("heap file of tmp must not exist: %v", err)
+		}
+	}
+
+	stop := startIncdbd(t)
+	verify()
+
+	// WAL is redone again on the applied tables because no checkpoint is done
+	stop()
+	stop = startIncdbd(t)
+	verify()
+
+	// the changes on the tables dropped or renamed after the checkpoint are skipped on redo, since their heap files are gone
+	run := func(query, expected string) {
+		t.Helper()
+		out, _ := exec.Command("./incdb", query).CombinedOutput()
+		if o := strings.TrimSuffix(string(out), "\n"); !strings.Contains(o, expected) {
+			t.Fatalf("[%s] expected: '%s', got: '%s'", query, expected, o)
+		}
+	}
+	run("create table dropped (id int)", "table dropped created")
+	run("create table renamed (id int)", "table renamed created")
+	run("checkpoint", "checkpoint done")
+	run("insert into dropped values (1)", "inserted")
+	run("insert into renamed values (2)", "inserted")
+	run("drop table dropped", "table dropped dropped")
+	run("alter table renamed rename to moved", "table renamed altered")
+
+	stop()
+	startIncdbd(t)
+	verify()
+	run("select * from moved", `{"Hdr":["id"],"Vals":[["2"]]}`)
+	run("select * from dropped", "table 'dropped' not found")
+}
+
+func TestE2ECreateTableOverOrphanedCatalog(t *testing.T) {
+	os.Setenv("INCDB_TEST", "1")
+	t.Cleanup(func() { os.Unsetenv("INCDB_TEST") })
+
+	cleanTestData()
+
+	// Simulate the catalog entry was left without the heap file by the older version.
+	if err := os.WriteFile("./data/test.incdb.catalog", []byte(`{"Tables":[{"Name":"item","Cols":[{"Name":"id","Type":"string"}]}]}`), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	startIncdbd(t)
+
+	for _, q := range []struct{ query, expected string }{
+		{"create table if not exists item (id int, name string)", "table item created"},
+		{"create table if not exists item (id int, name string)", "table item already exists, skipped"},
+		{`insert into item values (1, "laptop")`, "inserted"},
+		{"select * from item", `{"Hdr":["id","name"],"Vals":[["1","laptop"]]}`},
+	} {
+		out, err := exec.Command("./incdb", q.query).CombinedOutput()
+		if err != nil {
+			t.Fatalf("[%s]: %s", q.query, string(out))
+		}
+
+		if o := strings.TrimSuffix(string(out), "\n"); o != q.expected {
+			t.Fatalf("[%s] expected: '%s', got: '%s'", q.query, q.expected, o)
+		}
+	}
+}
+
+func TestE2EUniqueAfterRestart(t *testing.T) {
+	os.Setenv("INCDB_TEST", "1")
+	t.Cleanup(func() { os.Unsetenv("INCDB_TEST") })
+
+	cleanTestData()
+	stop := startIncdbd(t)
+
+	run := func(query, expected string) {
+		t.Helper()
+		out, _ := exec.Command("./incdb", query).CombinedOutput()
+		if o := strings.TrimSuffix(string(out), "\n"); !strings.Contains(o, expected) {
+			t.Fatalf("[%s] expected: '%s', got: '%s'", query, expected, o)
+		}
+	}
+
+	run("create table item (id int primary key, name string)", "table item created")
+	run("insert into item values (1, 'laptop')", "inserted")
+	run("create unique index itemname on item (name)", "index itemname created")
+
+	// the index is rebuilt from the heap file
+	stop()
+	startIncdbd(t)
+
+	run("insert into item values (1, 'radio')", "duplicate key (id)=(1) violates unique constraint 'item_pkey'")
+	run("insert into item values (2, 'radio')", "inserted")
+	run("insert into item values (3, 'laptop')", "duplicate key (name)=(laptop) violates unique constraint 'itemname'")
+	run("select id from item where name = 'radio'", `{"Hdr":["id"],"Vals":[["2"]]}`)
+}
+
+func TestE2ECheckpoint(t *testing.T) {
+	os.Setenv("INCDB_TEST", "1")
+	t.Cleanup(func() { os.Unsetenv("INCDB_TEST") })
+
+	cleanTestData()
+	stop := startIncdbd(t)
+
+	run := func(query, expected string) {
+		t.Helper()
+		out, err := exec.Command("./incdb", query).CombinedOutput()
+		if err != nil {
+			t.Fatalf("[%s] err: out: %s", query, string(out))
 		}
 
-		// verify data file
-		if tc.data != nil {
-			d, err := os.ReadFile("./data/test.incdb.data")
-			if err != nil {
-				t.Fatalf("[%s] read test data file: %v", tc.query, err)
-			}
-			d = d[:len(d)-1] // trim new line
+		if o := strings.TrimSuffix(string(out), "\n"); o != expected {
+			t.Fatalf("[%s] expected: '%s', got: '%s'", query, expected, o)
+		}
+	}
 
-			e, err := json.Marshal(&tc.data)
-			if err != nil {
-				t.Fatalf("[%s] marshal expected data into json: %v", tc.query, err)
-			}
+	run("create table item (id string, name string)", "table item created")
+	run(`insert into item values ("1", "laptop")`, "inserted")
+	run("checkpoint", "checkpoint done at LSN 3")
 
-			if !reflect.DeepEqual(d, e) {
-				t.Fatalf("[%s] data: expected: '%s', got: '%s'", tc.query, string(e), string(d))
-			}
+	if segs, _ := filepath.Glob("./data/test.incdb.wal.*"); len(segs) != 0 {
+		t.Fatalf("WAL segments must be removed on checkpoint: %v", segs)
+	}
+
+	run(`insert into item values ("2", "iPhone")`, "inserted")
+
+	// restart
+	stop()
+	startIncdbd(t)
+
+	run(`insert into item values ("3", "radio")`, "inserted")
+	run("select * from item", `{"Hdr":["id","name"],"Vals":[["1","laptop"],["2","iPhone"],["3","radio"]]}`)
+	run("checkpoint", "checkpoint done at LSN 7")
+}
+
+func TestE2ETransaction(t *testing.T) {
+	os.Setenv("INCDB_TEST", "1")
+	t.Cleanup(func() { os.Unsetenv("INCDB_TEST") })
+
+	cleanTestData()
+	stop := startIncdbd(t)
+
+	// empty session runs the query in autocommit mode
+	query := func(session, query string) string {
+		cmd := exec.Command("./incdb", query)
+		cmd.Env = append(os.Environ(), "INCDB_SESSION="+session)
+		out, _ := cmd.CombinedOutput()
+		return strings.TrimSuffix(string(out), "\n")
+	}
+	run := func(session, q, expected string) {
+		t.Helper()
+		if o := query(session, q); !strings.Contains(o, expected) {
+			t.Fatalf("[%s: %s] expected: '%s', got: '%s'", session, q, expected, o)
 		}
+	}
 
-		// verify catalog file
-		if tc.cat != nil {
-			c, err := os.ReadFile("./data/test.incdb.catalog")
-			if err != nil {
-				t.Fatalf("[%s] read test catalog file: %v", tc.query, err)
-			}
-			c = c[:len(c)-1] // trim new line
+	run("", "create table acct (id int primary key, balance int)", "table acct created")
+	run("", "insert into acct values (1, 100)", "inserted")
+	run("", "insert into acct values (2, 100)", "inserted")
 
-			e, err := json.Marshal(&tc.cat)
-			if err != nil {
-				t.Fatalf("[%s] marshal expected catalog into json: %v", tc.query, err)
-			}
+	// the changes are not visible to others until commit
+	run("s1", "begin", "transaction started")
+	run("s1", "update acct set balance = 50 where id = 1", "1 rows updated")
+	run("s1", "insert into acct values (3, 10)", "inserted")
+	run("s1", "select * from acct order by id", `{"Hdr":["id","balance"],"Vals":[["1","50"],["2","100"],["3","10"]]}`)
+	run("", "select * from acct order by id", `{"Hdr":["id","balance"],"Vals":[["1","100"],["2","100"]]}`)
+	run("", "select balance from acct where id = 1", `{"Hdr":["balance"],"Vals":[["100"]]}`)
 
-			if !reflect.DeepEqual(c, e) {
-				t.Fatalf("[%s] catalog: expected: '%s', got: '%s'", tc.query, string(e), string(c))
-			}
+	run("", "insert into acct values (3, 0)", "duplicate key (id)=(3) violates unique constraint 'acct_pkey'")
+
+	// the record changed by another transaction in progress is waited for, and cannot be changed once it commits
+	run("s2", "begin", "transaction started")
+	updated := make(chan string)
+	go func() { updated <- query("s2", "update acct set balance = 0 where id = 1") }()
+	select {
+	case o := <-updated:
+		t.Fatalf("update must wait for the transaction changing the record, got: '%s'", o)
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	run("s1", "commit", "transaction committed")
+	if o := <-updated; !strings.Contains(o, "could not serialize access due to concurrent update") {
+		t.Fatalf("update after the concurrent update is committed must fail, got: '%s'", o)
+	}
+	run("s2", "select * from acct", "current transaction is aborted, commands ignored until end of transaction block")
+	run("s2", "commit", "transaction rolled back")
+	run("", "select * from acct order by id", `{"Hdr":["id","balance"],"Vals":[["1","50"],["2","100"],["3","10"]]}`)
+
+	// rollback
+	run("s1", "begin", "transaction started")
+	run("s1", "delete from acct where id = 2", "1 rows deleted")
+	run("s1", "insert into acct values (2, 0)", "inserted")
+	run("s1", "rollback", "transaction rolled back")
+	run("", "select * from acct order by id", `{"Hdr":["id","balance"],"Vals":[["1","50"],["2","100"],["3","10"]]}`)
+
+	// the snapshot is taken on begin
+	run("s1", "begin", "transaction started")
+	run("s1", "select balance from acct where id = 2", `{"Hdr":["balance"],"Vals":[["100"]]}`)
+	run("", "update acct set balance = 80 where id = 2", "1 rows updated")
+	run("s1", "select balance from acct where id = 2", `{"Hdr":["balance"],"Vals":[["100"]]}`)
+	run("s1", "update acct set balance = 90 where id = 2", "could not serialize access due to concurrent update")
+	run("s1", "rollback", "transaction rolled back")
+	run("", "select balance from acct where id = 2", `{"Hdr":["balance"],"Vals":[["80"]]}`)
+
+	// errors on the transaction block
+	run("", "begin", "transaction block needs a session")
+	run("", "commit", "there is no transaction in progress")
+	run("s1", "rollback", "there is no transaction in progress")
+	run("s1", "begin", "transaction started")
+	run("s1", "begin", "there is already a transaction in progress")
+	run("s1", "create table tmp (id int)", "create table cannot run inside a transaction block")
+	run("s1", "rollback", "transaction rolled back")
+
+	// the transaction in progress is aborted on restart
+	run("s1", "begin", "transaction started")
+	run("s1", "insert into acct values (4, 40)", "inserted")
+	run("s1", "delete from acct where id = 1", "1 rows deleted")
+	stop()
+	startIncdbd(t)
+
+	run("", "select * from acct order by id", `{"Hdr":["id","balance"],"Vals":[["1","50"],["2","80"],["3","10"]]}`)
+	run("s1", "commit", "there is no transaction in progress")
+	run("", "insert into acct values (4, 0)", "inserted")
+	run("", "update acct set balance = 0 where id = 1", "1 rows updated")
+}
+
+func TestE2ERowLock(t *testing.T) {
+	os.Setenv("INCDB_TEST", "1")
+	t.Cleanup(func() { os.Unsetenv("INCDB_TEST") })
+
+	cleanTestData()
+	startIncdbd(t)
+
+	query := func(session, query string) string {
+		cmd := exec.Command("./incdb", query)
+		cmd.Env = append(os.Environ(), "INCDB_SESSION="+session)
+		out, _ := cmd.CombinedOutput()
+		return strings.TrimSuffix(string(out), "\n")
+	}
+	run := func(session, q, expected string) {
+		t.Helper()
+		if o := query(session, q); !strings.Contains(o, expected) {
+			t.Fatalf("[%s: %s] expected: '%s', got: '%s'", session, q, expected, o)
+		}
+	}
+	// wait runs the query in background, and checks that it waits for a lock
+	wait := func(session, q string) <-chan string {
+		t.Helper()
+		ch := make(chan string, 1)
+		go func() { ch <- query(session, q) }()
+		select {
+		case o := <-ch:
+			t.Fatalf("[%s: %s] must wait for the lock, got: '%s'", session, q, o)
+		case <-time.After(500 * time.Millisecond):
+		}
+		return ch
+	}
+
+	run("", "create table seat (id int primary key, owner string)", "table seat created")
+	run("", "insert into seat values (1, null)", "inserted")
+	run("", "insert into seat values (2, null)", "inserted")
+
+	// for update blocks the writers and the other lockers until commit, but not the readers
+	run("s1", "begin", "transaction started")
+	run("s1", "select * from seat where id = 1 for update", `{"Hdr":["id","owner"],"Vals":[["1",null]]}`)
+	run("", "select * from seat where id = 1", `{"Hdr":["id","owner"],"Vals":[["1",null]]}`)
+	run("", "update seat set owner = 'b' where id = 2", "1 rows updated")
+	updated := wait("", "update seat set owner = 'c' where id = 1")
+	run("s1", "commit", "transaction committed")
+	if o := <-updated; !strings.Contains(o, "1 rows updated") {
+		t.Fatalf("update after the lock is released must succeed, got: '%s'", o)
+	}
+	run("", "select * from seat order by id", `{"Hdr":["id","owner"],"Vals":[["1","c"],["2","b"]]}`)
+
+	// for share is compatible with itself, but not with for update
+	run("s1", "begin", "transaction started")
+	run("s2", "begin", "transaction started")
+	run("s1", "select id from seat order by id for share", `{"Hdr":["id"],"Vals":[["1"],["2"]]}`)
+	run("s2", "select id from seat where id = 2 for share", `{"Hdr":["id"],"Vals":[["2"]]}`)
+	locked := wait("s2", "select id from seat where id = 1 for update")
+	run("s1", "rollback", "transaction rolled back")
+	if o := <-locked; !strings.Contains(o, `{"Hdr":["id"],"Vals":[["1"]]}`) {
+		t.Fatalf("for update after the lock is released must succeed, got: '%s'", o)
+	}
+	run("s2", "rollback", "transaction rolled back")
+
+	// the record changed by the transaction committed while waiting cannot be locked
+	run("s1", "begin", "transaction started")
+	run("s2", "begin", "transaction started")
+	run("s2", "select id from seat where id = 2", `{"Hdr":["id"],"Vals":[["2"]]}`)
+	run("s1", "delete from seat where id = 2", "1 rows deleted")
+	locked = wait("s2", "select id from seat where id = 2 for share")
+	run("s1", "commit", "transaction committed")
+	if o := <-locked; !strings.Contains(o, "could not serialize access due to concurrent update") {
+		t.Fatalf("for share on the record deleted meanwhile must fail, got: '%s'", o)
+	}
+	run("s2", "rollback", "transaction rolled back")
+	run("", "insert into seat values (2, null)", "inserted")
+
+	// the deadlock victim is aborted, and the other goes on
+	run("s1", "begin", "transaction started")
+	run("s2", "begin", "transaction started")
+	run("s1", "select id from seat where id = 1 for update", `{"Hdr":["id"],"Vals":[["1"]]}`)
+	run("s2", "update seat set owner = 'y' where id = 2", "1 rows updated")
+	updated = wait("s1", "update seat set owner = 'x' where id = 2")
+	run("s2", "select id from seat where id = 1 for update", "deadlock detected")
+	if o := <-updated; !strings.Contains(o, "1 rows updated") {
+		t.Fatalf("update after the deadlock victim is aborted must succeed, got: '%s'", o)
+	}
+	run("s2", "select * from seat", "current transaction is aborted, commands ignored until end of transaction block")
+	run("s2", "commit", "transaction rolled back")
+	run("s1", "commit", "transaction committed")
+	run("", "select * from seat order by id", `{"Hdr":["id","owner"],"Vals":[["1","c"],["2","x"]]}`)
+
+	// lock timeout
+	run("", "set lock timeout 100", "lock timeout needs a session")
+	run("s1", "begin", "transaction started")
+	run("s1", "select id from seat where id = 1 for update", `{"Hdr":["id"],"Vals":[["1"]]}`)
+	run("s2", "set lock timeout 100", "lock timeout set to 100 ms")
+	run("s2", "delete from seat where id = 1", "canceling statement due to lock timeout")
+	run("s2", "select id from seat where id = 1 for share", "canceling statement due to lock timeout")
+	run("s2", "set lock timeout 0", "lock timeout set to 0 ms")
+	run("s1", "commit", "transaction committed")
+
+	// errors on the locking clause
+	run("", "select id from seat for delete", "update or share is expected after for")
+	run("", "select count(*) from seat for update", "for update is not allowed with group by clause or aggregate functions")
+	run("", "select distinct owner from seat for share", "for share is not allowed with distinct clause")
+	run("", "select s.id from (select id from seat) s for update", "for update is not allowed with subquery in from")
+	run("", "select * from seat a join seat b on a.id = b.id for update", "for update is not allowed with joins")
+	run("", "select * from seat where id in (select id from seat for update)", "for update is not allowed in subqueries")
+	run("", "set lock timeout -1", "lock timeout must not be negative")
+}
+
+func TestE2EIsolation(t *testing.T) {
+	os.Setenv("INCDB_TEST", "1")
+	t.Cleanup(func() { os.Unsetenv("INCDB_TEST") })
+
+	cleanTestData()
+	startIncdbd(t)
+
+	query := func(session, query string) string {
+		cmd := exec.Command("./incdb", query)
+		cmd.Env = append(os.Environ(), "INCDB_SESSION="+session)
+		out, _ := cmd.CombinedOutput()
+		return strings.TrimSuffix(string(out), "\n")
+	}
+	run := func(session, q, expected string) {
+		t.Helper()
+		if o := query(session, q); !strings.Contains(o, expected) {
+			t.Fatalf("[%s: %s] expected: '%s', got: '%s'", session, q, expected, o)
+		}
+	}
+
+	run("", "create table doctor (id int primary key, oncall bool)", "table doctor created")
+	run("", "insert into doctor values (1, true)", "inserted")
+	run("", "insert into doctor values (2, true)", "inserted")
+
+	// write skew: each takes the other on call and leaves, in repeatable read
+	offcall := func(level string, expected string) {
+		t.Helper()
+		for _, s := range []string{"s1", "s2"} {
+			run(s, "begin", "transaction started")
+			run(s, "set transaction isolation level "+level, "isolation level set to "+level)
+			run(s, "select count(*) from doctor where oncall = true", `{"Hdr":["count"],"Vals":[["2"]]}`)
+		}
+		run("s1", "update doctor set oncall = false where id = 1", "1 rows updated")
+		run("s2", "update doctor set oncall = false where id = 2", expected)
+		run("s1", "commit", "transaction committed")
+		query("s2", "commit")
+	}
+	offcall("repeatable read", "1 rows updated")
+	run("", "select count(*) from doctor where oncall = true", `{"Hdr":["count"],"Vals":[["0"]]}`)
+
+	run("", "update doctor set oncall = true", "2 rows updated")
+	offcall("serializable", "could not serialize access due to read/write dependencies among transactions (SQLSTATE 40001, the transaction can be retried)")
+	run("", "select * from doctor order by id", `{"Hdr":["id","oncall"],"Vals":[["1","false"],["2","true"]]}`)
+
+	// the aborted transaction is retried
+	run("s2", "begin", "transaction started")
+	run("s2", "set transaction isolation level serializable", "isolation level set to serializable")
+	run("s2", "select count(*) from doctor where oncall = true", `{"Hdr":["count"],"Vals":[["1"]]}`)
+	run("s2", "commit", "transaction committed")
+
+	// the serializable transactions touching the different tables do not conflict
+	run("", "create table shift (id int, doctor int)", "table shift created")
+	run("s1", "begin", "transaction started")
+	run("s1", "set transaction isolation level serializable", "isolation level set to serializable")
+	run("s2", "begin", "transaction started")
+	run("s2", "set transaction isolation level serializable", "isolation level set to serializable")
+	run("s1", "select * from doctor", `{"Hdr":["id","oncall"]`)
+	run("s2", "insert into shift values (1, 1)", "inserted")
+	run("s1", "insert into shift values (2, 2)", "inserted")
+	run("s2", "commit", "transaction committed")
+	run("s1", "commit", "transaction committed")
+
+	// the serializable transactions reading and updating the different records through the index do not conflict
+	for i, s := range []string{"s1", "s2"} {
+		run(s, "begin", "transaction started")
+		run(s, "set transaction isolation level serializable", "isolation level set to serializable")
+		run(s, fmt.Sprintf("select * from doctor where id = %d", i+1), `{"Hdr":["id","oncall"]`)
+	}
+	run("s1", "update doctor set oncall = false where id = 1", "1 rows updated")
+	run("s2", "update doctor set oncall = true where id = 2", "1 rows updated")
+	run("s1", "commit", "transaction committed")
+	run("s2", "commit", "transaction committed")
+
+	// read committed sees the changes committed before each statement
+	run("s1", "begin", "transaction started")
+	run("s1", "set transaction isolation level read committed", "isolation level set to read committed")
+	run("s1", "select oncall from doctor where id = 1", `{"Hdr":["oncall"],"Vals":[["false"]]}`)
+	run("", "update doctor set oncall = true where id = 1", "1 rows updated")
+	run("s1", "select oncall from doctor where id = 1", `{"Hdr":["oncall"],"Vals":[["true"]]}`)
+
+	// the writer in read committed goes on with the change committed while waiting
+	run("s2", "begin", "transaction started")
+	run("s2", "update doctor set oncall = false where id = 1", "1 rows updated")
+	updated := make(chan string, 1)
+	go func() { updated <- query("s1", "update doctor set oncall = false where id = 1 and oncall = true") }()
+	time.Sleep(500 * time.Millisecond)
+	run("s2", "commit", "transaction committed")
+	if o := <-updated; !strings.Contains(o, "0 rows updated") {
+		t.Fatalf("update in read committed must see the change committed while waiting, got: '%s'", o)
+	}
+	run("s1", "commit", "transaction committed")
+
+	// the concurrent update is retryable in repeatable read
+	run("s1", "begin", "transaction started")
+	run("s1", "select * from doctor", `{"Hdr":["id","oncall"]`)
+	run("", "update doctor set oncall = true where id = 2", "1 rows updated")
+	run("s1", "update doctor set oncall = false where id = 2", "could not serialize access due to concurrent update (SQLSTATE 40001, the transaction can be retried)")
+	run("s1", "rollback", "transaction rolled back")
+
+	// errors on set transaction
+	run("", "set transaction isolation level serializable", "set transaction can only be used in transaction blocks")
+	run("s1", "begin", "transaction started")
+	run("s1", "select * from doctor", `{"Hdr":["id","oncall"]`)
+	run("s1", "set transaction isolation level serializable", "set transaction isolation level must be called before any query")
+	run("s1", "rollback", "transaction rolled back")
+	run("", "set transaction isolation level snapshot", "unknown isolation level 'snapshot'")
+	run("", "set transaction level serializable", "isolation is expected but got level")
+}
+
+func TestE2ESavepoint(t *testing.T) {
+	os.Setenv("INCDB_TEST", "1")
+	t.Cleanup(func() { os.Unsetenv("INCDB_TEST") })
+
+	cleanTestData()
+	stop := startIncdbd(t)
+
+	run := func(session, query, expected string) {
+		t.Helper()
+		cmd := exec.Command("./incdb", query)
+		cmd.Env = append(os.Environ(), "INCDB_SESSION="+session)
+		out, _ := cmd.CombinedOutput()
+		if o := strings.TrimSuffix(string(out), "\n"); !strings.Contains(o, expected) {
+			t.Fatalf("[%s: %s] expected: '%s', got: '%s'", session, query, expected, o)
+		}
+	}
+
+	run("", "create table item (id int primary key, name string)", "table item created")
+	run("", "savepoint sp1", "savepoint can only be used in transaction blocks")
+
+	run("s1", "begin", "transaction started")
+	run("s1", "insert into item values (1, 'a')", "inserted")
+	run("s1", "savepoint sp1", "savepoint sp1 defined")
+	run("s1", "insert into item values (2, 'b')", "inserted")
+	run("s1", "savepoint sp2", "savepoint sp2 defined")
+	run("s1", "insert into item values (3, 'c')", "inserted")
+	run("s1", "rollback to savepoint sp2", "rolled back to savepoint sp2")
+	run("s1", "select * from item order by id", `{"Hdr":["id","name"],"Vals":[["1","a"],["2","b"]]}`)
+	run("", "select * from item", "no results")
+
+	// the savepoint stays after rolling back to it
+	run("s1", "insert into item values (3, 'd')", "inserted")
+	run("s1", "rollback to sp1", "rolled back to savepoint sp1")
+	run("s1", "select * from item order by id", `{"Hdr":["id","name"],"Vals":[["1","a"]]}`)
+
+	// the failed block is recovered by rolling back to the savepoint
+	run("s1", "insert into item values (2, 'x')", "inserted")
+	run("s1", "insert into item values (2, 'y')", "duplicate key (id)=(2) violates unique constraint 'item_pkey'")
+	run("s1", "select * from item", "current transaction is aborted, commands ignored until end of transaction block")
+	run("s1", "savepoint sp3", "current transaction is aborted, commands ignored until end of transaction block")
+	run("s1", "rollback to savepoint sp1", "rolled back to savepoint sp1")
+	run("s1", "select * from item order by id", `{"Hdr":["id","name"],"Vals":[["1","a"]]}`)
+
+	// the released savepoint cannot be rolled back to
+	run("s1", "savepoint sp3", "savepoint sp3 defined")
+	run("s1", "delete from item where id = 1", "1 rows deleted")
+	run("s1", "release savepoint sp3", "savepoint sp3 released")
+	run("s1", "rollback to savepoint sp3", "savepoint 'sp3' does not exist")
+	run("s1", "rollback to savepoint sp1", "rolled back to savepoint sp1")
+
+	// the changes after the released savepoint are committed with the transaction
+	run("s1", "insert into item values (2, 'b')", "inserted")
+	run("s1", "savepoint sp4", "savepoint sp4 defined")
+	run("s1", "update item set name = 'z' where id = 1", "1 rows updated")
+	run("s1", "release sp4", "savepoint sp4 released")
+	run("s1", "commit", "transaction committed")
+	run("", "select * from item order by id", `{"Hdr":["id","name"],"Vals":[["1","z"],["2","b"]]}`)
+
+	// the subtransactions are committed after restart
+	stop()
+	startIncdbd(t)
+
+	run("", "select * from item order by id", `{"Hdr":["id","name"],"Vals":[["1","z"],["2","b"]]}`)
+}
+
+func TestE2EInsertMany(t *testing.T) {
+	os.Setenv("INCDB_TEST", "1")
+	t.Cleanup(func() { os.Unsetenv("INCDB_TEST") })
+
+	cleanTestData()
+	stop := startIncdbd(t)
+
+	run := func(session, query, expected string) {
+		t.Helper()
+		cmd := exec.Command("./incdb", query)
+		cmd.Env = append(os.Environ(), "INCDB_SESSION="+session)
+		out, _ := cmd.CombinedOutput()
+		if o := strings.TrimSuffix(string(out), "\n"); !strings.Contains(o, expected) {
+			t.Fatalf("[%s: %s] expected: '%s', got: '%s'", session, query, expected, o)
+		}
+	}
+
+	run("", "create table item (id int primary key, name string, price int default 0)", "table item created")
+	run("", "insert into item values (1, 'a', 10), (2, 'b', 20), (3, null, 30)", "3 rows inserted")
+	run("", "insert into item (id, name) values (4, 'd'), (5, 'e')", "2 rows inserted")
+	run("", "select * from item order by id", `{"Hdr":["id","name","price"],"Vals":[["1","a","10"],["2","b","20"],["3",null,"30"],["4","d","0"],["5","e","0"]]}`)
+
+	// nothing is inserted if any record is invalid
+	run("", "insert into item values (6, 'f', 60), (1, 'g', 70)", "duplicate key (id)=(1) violates unique constraint 'item_pkey'")
+	run("", "insert into item values (6, 'f', 60), (6, 'g', 70)", "duplicate key (id)=(6) violates unique constraint 'item_pkey'")
+	run("", "insert into item values (6, 'f', 60), (7, 'g')", "3 values must be passed according to the table definition")
+	run("", "insert into item (id, name) values (6, 'f'), (7, 'g', 70)", "2 values must be passed according to the columns")
+	run("", "insert into item values (6, 'f', 60), (7, 'g', 'x')", "invalid value for column 'price'")
+	run("", "select count(*) from item", `{"Hdr":["count"],"Vals":[["5"]]}`)
+
+	// insert select
+	run("", "create table cheap (code int primary key, label string)", "table cheap created")
+	run("", "insert into cheap select id, name from item where price < 20", "3 rows inserted")
+	run("", "insert into cheap (label, code) select name, id from item where id = 2", "inserted")
+	run("", "insert into cheap select id, name from item where id > 100", "0 rows inserted")
+	run("", "insert into cheap select id, name from item where id = 1", "duplicate key (code)=(1) violates unique constraint 'cheap_pkey'")
+	run("", "insert into cheap select * from item", "2 values must be passed according to the table definition")
+	run("", "select * from cheap order by code", `{"Hdr":["code","label"],"Vals":[["1","a"],["2","b"],["4","d"],["5","e"]]}`)
+
+	// the records spanning the pages are inserted in a batch, and recovered after restart
+	run("", "create table bulk (n int, pad string)", "table bulk created")
+	run("", fmt.Sprintf("insert into bulk values (1, '%s')", strings.Repeat("x", 200)), "inserted")
+	for n := 2; n <= 512; n *= 2 {
+		run("", "insert into bulk select * from bulk", "inserted")
+	}
+	run("", "select count(*), sum(n) from bulk", `{"Hdr":["count","sum"],"Vals":[["512","512"]]}`)
+
+	stop()
+	startIncdbd(t)
+	run("", "select count(*), sum(n) from bulk", `{"Hdr":["count","sum"],"Vals":[["512","512"]]}`)
+
+	// the inserted records are rolled back together
+	run("s1", "begin", "transaction started")
+	run("s1", "insert into cheap values (10, 'x'), (11, 'y')", "2 rows inserted")
+	run("s1", "rollback", "transaction rolled back")
+	run("", "select count(*) from cheap", `{"Hdr":["count"],"Vals":[["4"]]}`)
+
+	// the statement in autocommit syncs WAL once with the commit, and the one in a transaction block syncs it without
+	assertWalSyncs := func(session, query, expected string, syncs int64) {
+		t.Helper()
+		before := testStats(t).WalSyncs
+		run(session, query, expected)
+		if n := testStats(t).WalSyncs - before; n != syncs {
+			t.Fatalf("[%s: %s] WAL syncs: %d, expected: %d", session, query, n, syncs)
+		}
+	}
+	assertWalSyncs("", "insert into item values (10, 'x', 100), (11, 'y', 110)", "2 rows inserted", 1)
+	assertWalSyncs("", "insert into cheap select id, name from item where id >= 10", "2 rows inserted", 1)
+	assertWalSyncs("", "update cheap set label = 'z' where code >= 10", "2 rows updated", 1)
+	assertWalSyncs("", "delete from cheap where code = 11", "1 rows deleted", 1)
+	assertWalSyncs("s1", "begin", "transaction started", 0)
+	assertWalSyncs("s1", "insert into cheap values (30, 'x'), (31, 'y')", "2 rows inserted", 1)
+	assertWalSyncs("s1", "commit", "transaction committed", 1)
+
+	stop()
+	startIncdbd(t)
+	run("", "select * from cheap where code >= 10 order by code", `{"Hdr":["code","label"],"Vals":[["10","z"],["30","x"],["31","y"]]}`)
+}
+
+func TestE2EVacuum(t *testing.T) {
+	os.Setenv("INCDB_TEST", "1")
+	t.Cleanup(func() { os.Unsetenv("INCDB_TEST") })
+
+	cleanTestData()
+	stop := startIncdbd(t)
+
+	run := func(session, query, expected string) {
+		t.Helper()
+		cmd := exec.Command("./incdb", query)
+		cmd.Env = append(os.Environ(), "INCDB_SESSION="+session)
+		out, _ := cmd.CombinedOutput()
+		if o := strings.TrimSuffix(string(out), "\n"); !strings.Contains(o, expected) {
+			t.Fatalf("[%s: %s] expected: '%s', got: '%s'", session, query, expected, o)
+		}
+	}
+
+	// every tuple in the test has the same size
+	size := len(testTuple(t, 1, int64(1), "a"))
+	reclaimed := func(rows int) string {
+		return fmt.Sprintf("vacuum done, %d rows (%d bytes) reclaimed", rows, rows*size)
+	}
+
+	run("", "create table item (id int primary key, name string)", "table item created")
+	run("", "insert into item values (1, 'a')", "inserted")
+	run("", "insert into item values (2, 'b')", "inserted")
+	run("", "insert into item values (3, 'c')", "inserted")
+	run("", "update item set name = 'x' where id = 1", "1 rows updated")
+	run("", "delete from item where id = 2", "1 rows deleted")
+
+	// the inserted version is removed and the deleted one is restored
+	run("s1", "begin", "transaction started")
+	run("s1", "insert into item values (4, 'd')", "inserted")
+	run("s1", "delete from item where id = 1", "1 rows deleted")
+	run("s1", "rollback", "transaction rolled back")
+
+	// the old version is kept while the snapshot can see it
+	run("s2", "begin", "transaction started")
+	run("s2", "select name from item where id = 3", `{"Hdr":["name"],"Vals":[["c"]]}`)
+	run("", "update item set name = 'y' where id = 3", "1 rows updated")
+
+	run("", "vacuum", reclaimed(3))
+	run("", "vacuum item", reclaimed(0))
+	run("s2", "select name from item where id = 3", `{"Hdr":["name"],"Vals":[["c"]]}`)
+	run("s2", "vacuum", "vacuum cannot run inside a transaction block")
+	run("s2", "rollback", "transaction rolled back")
+	run("", "vacuum item", reclaimed(1))
+	run("", "vacuum nosuch", "table 'nosuch' not found")
+
+	run("", "select * from item order by id", `{"Hdr":["id","name"],"Vals":[["1","x"],["3","y"]]}`)
+	run("", "insert into item values (2, 'b')", "inserted")
+	run("", "select name from item where id = 2", `{"Hdr":["name"],"Vals":[["b"]]}`)
+
+	// vacuum is redone from WAL
+	stop()
+	startIncdbd(t)
+
+	run("", "select * from item order by id", `{"Hdr":["id","name"],"Vals":[["1","x"],["2","b"],["3","y"]]}`)
+	run("", "vacuum", reclaimed(0))
+}
+
+func TestE2EIndexOnlyScan(t *testing.T) {
+	os.Setenv("INCDB_TEST", "1")
+	t.Cleanup(func() { os.Unsetenv("INCDB_TEST") })
+
+	cleanTestData()
+	startIncdbd(t)
+
+	run := func(session, query, expected string) {
+		t.Helper()
+		cmd := exec.Command("./incdb", query)
+		cmd.Env = append(os.Environ(), "INCDB_SESSION="+session)
+		out, _ := cmd.CombinedOutput()
+		if o := strings.TrimSuffix(string(out), "\n"); !strings.Contains(o, expected) {
+			t.Fatalf("[%s: %s] expected: '%s', got: '%s'", session, query, expected, o)
+		}
+	}
+
+	// bufferReads returns the number of the records read through the buffer pool
+	bufferReads := func() int64 {
+		t.Helper()
+		stats := testStats(t)
+		return stats.BufferHits + stats.BufferMisses
+	}
+
+	// assertHeapRead checks whether the query reads the heap file or only the index
+	assertHeapRead := func(query, expected string, heap bool) {
+		t.Helper()
+		before := bufferReads()
+		run("", query, expected)
+		if read := bufferReads() != before; read != heap {
+			t.Fatalf("[%s] heap read: %v, expected: %v", query, read, heap)
+		}
+	}
+
+	run("", "create table item (id int primary key, name string)", "table item created")
+	run("", "insert into item values (1, 'a'), (2, 'b'), (3, 'c')", "3 rows inserted")
+	run("", "delete from item where id = 2", "1 rows deleted")
+
+	covering := "select id from item where id >= 1 order by id"
+	assertHeapRead(covering, `{"Hdr":["id"],"Vals":[["1"],["3"]]}`, true)
+
+	// every version left on the page is visible after vacuum
+	run("", "vacuum item", "vacuum done")
+	assertHeapRead(covering, `{"Hdr":["id"],"Vals":[["1"],["3"]]}`, false)
+	assertHeapRead("select name from item where id >= 1 order by id", `{"Hdr":["name"],"Vals":[["a"],["c"]]}`, true)
+
+	// the change clears the page, and vacuum does not set it while the old version is visible to the transaction in progress
+	run("s1", "begin", "transaction started")
+	run("", "update item set id = 4 where id = 3", "1 rows updated")
+	assertHeapRead(covering, `{"Hdr":["id"],"Vals":[["1"],["4"]]}`, true)
+	run("", "vacuum item", "vacuum done")
+	assertHeapRead(covering, `{"Hdr":["id"],"Vals":[["1"],["4"]]}`, true)
+	run("s1", covering, `{"Hdr":["id"],"Vals":[["1"],["3"]]}`)
+
+	run("s1", "rollback", "transaction rolled back")
+	run("", "vacuum item", "vacuum done")
+	assertHeapRead(covering, `{"Hdr":["id"],"Vals":[["1"],["4"]]}`, false)
+}
+
+func TestE2EMigrateTablespace(t *testing.T) {
+	os.Setenv("INCDB_TEST", "1")
+	t.Cleanup(func() { os.Unsetenv("INCDB_TEST") })
+
+	cleanTestData()
+
+	// The tablespace file and WAL written before the heap files are introduced.
+	files := map[string]string{
+		"./data/test.incdb.catalog": `{"Tables":[{"Name":"item","Cols":[{"Name":"id","Type":"string"},{"Name":"name","Type":"string"}]}]}`,
+		"./data/test.incdb.data":    `{"LSN":3,"Tables":{"item":[{"id":"1","name":"laptop"},{"id":"2"}]}}`,
+		"./data/test.incdb.wal.0000000000000001": `{"LSN":1,"Op":"create","Table":"item","Cols":["id","name"],"Types":["string","string"]}
+{"LSN":2,"Op":"insert","Table":"item","Row":{"id":"1","name":"laptop"}}
+{"LSN":3,"Op":"insert","Table":"item","Row":{"id":"2"}}
+{"LSN":4,"Op":"insert","Table":"item","Row":{"id":"3","name":"radio"}}
+`,
+	}
+	for path, content := range files {
+		if err := os.WriteFile(path, []byte(content), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	startIncdbd(t)
+
+	out, err := exec.Command("./incdb", `insert into item values ("4", "iPhone")`).CombinedOutput()
+	if err != nil {
+		t.Fatalf("insert: %s", string(out))
+	}
+
+	out, err = exec.Command("./incdb", "select * from item").CombinedOutput()
+	if err != nil {
+		t.Fatalf("select: %s", string(out))
+	}
+
+	expected := `{"Hdr":["id","name"],"Vals":[["1","laptop"],["2",""],["3","radio"],["4","iPhone"]]}`
+	if o := strings.TrimSuffix(string(out), "\n"); o != expected {
+		t.Fatalf("select: expected: '%s', got: '%s'", expected, o)
+	}
+
+	if _, err := os.Stat("./data/test.incdb.data"); !os.IsNotExist(err) {
+		t.Fatalf("legacy tablespace file must be renamed: %v", err)
+	}
+}
+
+func TestE2EMigrateBaselineTablespace(t *testing.T) {
+	os.Setenv("INCDB_TEST", "1")
+	t.Cleanup(func() { os.Unsetenv("INCDB_TEST") })
+
+	cleanTestData()
+
+	// The tablespace file written before WAL is introduced, which has the tables at the top level.
+	files := map[string]string{
+		"./data/test.incdb.catalog": `{"Tables":[{"Name":"person","Cols":[{"Name":"id","Type":"string"},{"Name":"name","Type":"string"}]},{"Name":"empty","Cols":[{"Name":"id","Type":"string"}]}]}`,
+		"./data/test.incdb.data":    `{"person":[{"id":"1","name":"alice"},{"id":"2"}],"empty":[]}`,
+	}
+	for path, content := range files {
+		if err := os.WriteFile(path, []byte(content), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stop := startIncdbd(t)
+
+	run := func(query, expected string) {
+		t.Helper()
+		out, _ := exec.Command("./incdb", query).CombinedOutput()
+		if o := strings.TrimSuffix(string(out), "\n"); o != expected {
+			t.Fatalf("[%s] expected: '%s', got: '%s'", query, expected, o)
 		}
 	}
+
+	run("select * from person", `{"Hdr":["id","name"],"Vals":[["1","alice"],["2",""]]}`)
+	run("select * from empty", "no results")
+	run(`insert into person values ("3", "bob")`, "inserted")
+
+	if _, err := os.Stat("./data/test.incdb.data"); !os.IsNotExist(err) {
+		t.Fatalf("legacy tablespace file must be renamed: %v", err)
+	}
+
+	stop()
+	startIncdbd(t)
+	run("select * from person", `{"Hdr":["id","name"],"Vals":[["1","alice"],["2",""],["3","bob"]]}`)
+}
+
+func TestE2EMigrateWalFile(t *testing.T) {
+	os.Setenv("INCDB_TEST", "1")
+	t.Cleanup(func() { os.Unsetenv("INCDB_TEST") })
+
+	cleanTestData()
+
+	// The single WAL file written before the segments are introduced, with the entries not yet applied.
+	files := map[string]string{
+		"./data/test.incdb.catalog": `{"Tables":[{"Name":"item","Cols":[{"Name":"id","Type":"string"},{"Name":"name","Type":"string"}]}]}`,
+		"./data/test.incdb.data":    `{"LSN":2,"Tables":{"item":[{"id":"1","name":"laptop"}]}}`,
+		"./data/test.incdb.wal": `{"LSN":1,"Op":"create","Table":"item","Cols":["id","name"],"Types":["string","string"]}
+{"LSN":2,"Op":"insert","Table":"item","Row":{"id":"1","name":"laptop"}}
+{"LSN":3,"Op":"insert","Table":"item","Row":{"id":"2","name":"radio"}}
+`,
+	}
+	for path, content := range files {
+		if err := os.WriteFile(path, []byte(content), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stop := startIncdbd(t)
+
+	run := func(query, expected string) {
+		t.Helper()
+		out, _ := exec.Command("./incdb", query).CombinedOutput()
+		if o := strings.TrimSuffix(string(out), "\n"); o != expected {
+			t.Fatalf("[%s] expected: '%s', got: '%s'", query, expected, o)
+		}
+	}
+
+	run("select * from item", `{"Hdr":["id","name"],"Vals":[["1","laptop"],["2","radio"]]}`)
+	run(`insert into item values ("3", "iPhone")`, "inserted")
+
+	if _, err := os.Stat("./data/test.incdb.wal"); !os.IsNotExist(err) {
+		t.Fatalf("legacy WAL file must be renamed: %v", err)
+	}
+
+	// the entries appended after the migration are redone
+	stop()
+	startIncdbd(t)
+	run("select * from item", `{"Hdr":["id","name"],"Vals":[["1","laptop"],["2","radio"],["3","iPhone"]]}`)
+}
+
+// testTuple returns the tuple of the values inserted by the transaction xid.
+func testTuple(t *testing.T, xid uint64, vals ...any) []byte {
+	t.Helper()
+	tuple, err := encodeTuple(vals)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return makeHeapTuple(Version{Xmin: xid}, tuple)
+}
+
+// testWal returns the WAL lines of the entries. Their LSNs are given in order from lsn.
+func testWal(t *testing.T, lsn uint64, entries []*WalEntry) string {
+	t.Helper()
+	wal := ""
+	for i, e := range entries {
+		e.LSN = lsn + uint64(i)
+		b, err := json.Marshal(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wal += string(b) + "\n"
+	}
+	return wal
+}
+
+// testStats returns the stats of the running incdbd.
+func testStats(t *testing.T) (stats struct{ BufferHits, BufferMisses, WalSyncs int64 }) {
+	t.Helper()
+	resp, err := http.Get("http://localhost:2134/stats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		t.Fatal(err)
+	}
+	return stats
+}
+
+func cleanTestData() {
+	exec.Command("rm", "-f", "./data/test.incdb.data").Run()
+	exec.Command("rm", "-f", "./data/test.incdb.data.migrated").Run()
+	exec.Command("rm", "-f", "./data/test.incdb.catalog").Run()
+	exec.Command("rm", "-f", "./data/test.incdb.control").Run()
+	exec.Command("rm", "-f", "./data/test.incdb.wal").Run()
+	for _, pattern := range []string{"./data/test.incdb.wal.*", "./data/test.incdb.heap.*"} {
+		paths, _ := filepath.Glob(pattern)
+		for _, path := range paths {
+			os.Remove(path)
+		}
+	}
+}
+
+// startIncdbd starts incdbd process. The returned function stops it,
+// which is also called on the test cleanup.
+func startIncdbd(t *testing.T) func() {
+	incdbd := exec.Command("./incdbd")
+	if err := incdbd.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	stopped := false
+	stop := func() {
+		if stopped {
+			return
+		}
+		stopped = true
+
+		process, err := os.FindProcess(incdbd.Process.Pid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = process.Kill(); err != nil {
+			t.Fatal(err)
+		}
+		incdbd.Wait()
+	}
+	t.Cleanup(stop)
+
+	time.Sleep(time.Second)
+	return stop
 }
@@ -0,0 +1,390 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// strLiteral is the value of a string literal in an expression.
+// It does not have a type until it is compared with a typed value,
+// then it is converted into the type (e.g. '2000-01-01' compared with a timestamp column).
+type strLiteral string
+
+// evalExpr evaluates the expression against the record.
+// Comparisons and logical operators return bool, or nil if the result is unknown (NULL).
+func evalExpr(tx *Tx, e *Expr, r *Record) (any, error) {
+	switch {
+	case e.Literal != nil:
+		return literalValue(e.Literal)
+
+	case e.Binary != nil:
+		return evalBinary(tx, e.Binary, r)
+
+	case e.Unary != nil:
+		return evalUnary(tx, e.Unary, r)
+
+	case e.Logical != nil:
+		return evalLogical(tx, e.Logical, r)
+
+	case e.In != nil:
+		return evalIn(tx, e.In, r)
+
+	case e.Between != nil:
+		return evalBetween(tx, e.Between, r)
+
+	case e.Subquery != nil:
+		return evalScalarSubquery(tx, e.Subquery, r)
+
+	case e.Exists != nil:
+		rs, err := runSubquery(tx, e.Exists, r)
+		if err != nil {
+			return nil, err
+		}
+		return len(rs) != 0, nil
+
+	case e.Aggregate != nil:
+		v, ok := r.Aggregates[e.Aggregate.String()]
+		if !ok {
+			return nil, fmt.Errorf("aggregate function %s is not allowed here", e.Aggregate)
+		}
+		return v, nil
+	}
+
+	// the column of the outer query can be referred in the subquery
+	for ; ; r = r.Outer {
+		index, err := r.ColIndex(e.Column)
+		if err == nil {
+			return r.Vals[index], nil
+		}
+
+		var nf *columnNotFoundError
+		if r.Outer == nil || !errors.As(err, &nf) {
+			return nil, err
+		}
+	}
+}
+
+// evalScalarSubquery returns the value the subquery returns. NULL is returned if it returns no row.
+func evalScalarSubquery(tx *Tx, slct *Select, r *Record) (any, error) {
+	rs, err := runSubquery(tx, slct, r)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case len(rs) == 0:
+		return nil, nil
+	case len(rs[0].Vals) != 1:
+		return nil, fmt.Errorf("subquery must return only one column")
+	case len(rs) > 1:
+		return nil, fmt.Errorf("more than one row returned by a subquery used as an expression")
+	}
+
+	return rs[0].Vals[0], nil
+}
+
+func literalValue(lit *Literal) (any, error) {
+	switch lit.Kind {
+	case LitNull:
+		return nil, nil
+	case LitString:
+		return strLiteral(lit.Value), nil
+	case LitInt:
+		return parseValue(TypeInt, lit.Value)
+	case LitFloat:
+		return parseValue(TypeFloat, lit.Value)
+	case LitBool:
+		return parseValue(TypeBool, lit.Value)
+	}
+
+	return nil, fmt.Errorf("unknown literal kind: %s", lit.Kind)
+}
+
+func evalBinary(tx *Tx, b *Binary, r *Record) (any, error) {
+	l, err := evalExpr(tx, b.Left, r)
+	if err != nil {
+		return nil, err
+	}
+
+	rv, err := evalExpr(tx, b.Right, r)
+	if err != nil {
+		return nil, err
+	}
+
+	// comparison with NULL is unknown
+	if l == nil || rv == nil {
+		return nil, nil
+	}
+
+	if b.Op == TkLike {
+		// pattern matching is done on the text representation regardless of the type
+		return matchLike(formatValue(l), formatValue(rv)), nil
+	}
+
+	c, err := compareExprs(b.Left, l, b.Right, rv)
+	if err != nil {
+		return nil, err
+	}
+
+	switch b.Op {
+	case TkEqual:
+		return c == 0, nil
+	case TkNotEqual:
+		return c != 0, nil
+	case TkLess:
+		return c < 0, nil
+	case TkLessEqual:
+		return c <= 0, nil
+	case TkGreater:
+		return c > 0, nil
+	case TkGreaterEq:
+		return c >= 0, nil
+	}
+
+	return nil, fmt.Errorf("unknown binary operator: %s", b.Op)
+}
+
+// compareExprs compares the evaluated non-NULL values of the expressions.
+// A string literal is converted into the type of the other side value before comparison.
+func compareExprs(le *Expr, l any, re *Expr, r any) (int, error) {
+	ls, lok := l.(strLiteral)
+	rs, rok := r.(strLiteral)
+
+	var err error
+	switch {
+	case lok && rok:
+		l, r = string(ls), string(rs)
+
+	case lok:
+		l, err = coerceLiteral(ls, r, re)
+
+	case rok:
+		r, err = coerceLiteral(rs, l, le)
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	return compareValues(l, r), nil
+}
+
+func coerceLiteral(lit strLiteral, other any, otherExpr *Expr) (any, error) {
+	v, err := parseValue(typeOf(other), string(lit))
+	if err != nil {
+		if otherExpr.Column != "" {
+			return nil, fmt.Errorf("invalid value for column '%s': %w", otherExpr.Column, err)
+		}
+		return nil, fmt.Errorf("invalid value: %w", err)
+	}
+
+	return v, nil
+}
+
+// matchLike returns true if s matches the pattern.
+// In the pattern, "%" matches any sequence of characters (including empty) and "_" matches any single character.
+func matchLike(s, pattern string) bool {
+	sr, pr := []rune(s), []rune(pattern)
+
+	// the positions to retry when a mismatch is found after "%"
+	si, pi := 0, 0
+	starS, starP := -1, -1
+	for si < len(sr) {
+		switch {
+		case pi < len(pr) && pr[pi] == '%':
+			starS, starP = si, pi
+			pi++
+
+		case pi < len(pr) && (pr[pi] == '_' || pr[pi] == sr[si]):
+			si++
+			pi++
+
+		case starP >= 0:
+			// let the last "%" consume one more character
+			starS++
+			si, pi = starS, starP+1
+
+		default:
+			return false
+		}
+	}
+
+	for pi < len(pr) && pr[pi] == '%' {
+		pi++
+	}
+
+	return pi == len(pr)
+}
+
+func evalUnary(tx *Tx, u *Unary, r *Record) (any, error) {
+	v, err := evalExpr(tx, u.Operand, r)
+	if err != nil {
+		return nil, err
+	}
+
+	switch u.Op {
+	case OpIsNull:
+		return v == nil, nil
+
+	case OpIsNotNull:
+		return v != nil, nil
+
+	case OpNot:
+		if v == nil {
+			return nil, nil
+		}
+
+		b, ok := v.(bool)
+		if !ok {
+			return nil, fmt.Errorf("operand of not must be bool but %v", v)
+		}
+		return !b, nil
+	}
+
+	return nil, fmt.Errorf("unknown unary operator: %s", u.Op)
+}
+
+// evalLogical evaluates "and"/"or" in three-valued logic.
+// The right side is not evaluated if the left side decides the result.
+func evalLogical(tx *Tx, l *Logical, r *Record) (any, error) {
+	lv, err := evalCondition(tx, l.Left, r)
+	if err != nil {
+		return nil, err
+	}
+
+	// false and x = false, true or x = true
+	if lv != nil && *lv == (l.Op == TkOr) {
+		return *lv, nil
+	}
+
+	rv, err := evalCondition(tx, l.Right, r)
+	if err != nil {
+		return nil, err
+	}
+
+	if rv != nil && *rv == (l.Op == TkOr) {
+		return *rv, nil
+	}
+
+	if lv == nil || rv == nil {
+		return nil, nil
+	}
+
+	return *rv, nil
+}
+
+// evalIn returns true if the operand is equal to any of the list.
+// If no item is equal and the list contains NULL, the result is unknown.
+func evalIn(tx *Tx, in *In, r *Record) (any, error) {
+	v, err := evalExpr(tx, in.Operand, r)
+	if err != nil {
+		return nil, err
+	}
+
+	if v == nil {
+		return nil, nil
+	}
+
+	items, vals, err := inValues(tx, in, r)
+	if err != nil {
+		return nil, err
+	}
+
+	null := false
+	for i, item := range items {
+		iv := vals[i]
+		if iv == nil {
+			null = true
+			continue
+		}
+
+		c, err := compareExprs(in.Operand, v, item, iv)
+		if err != nil {
+			return nil, err
+		}
+
+		if c == 0 {
+			return true, nil
+		}
+	}
+
+	if null {
+		return nil, nil
+	}
+
+	return false, nil
+}
+
+// inValues returns the expressions in the list of "in" and their values.
+// For the subquery, the values in the rows it returns are used with the subquery as their expression.
+func inValues(tx *Tx, in *In, r *Record) ([]*Expr, []any, error) {
+	items, vals := []*Expr{}, []any{}
+	if in.Subquery != nil {
+		rs, err := runSubquery(tx, in.Subquery, r)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		item := &Expr{Subquery: in.Subquery}
+		for _, sr := range rs {
+			if len(sr.Vals) != 1 {
+				return nil, nil, fmt.Errorf("subquery must return only one column")
+			}
+			items = append(items, item)
+			vals = append(vals, sr.Vals[0])
+		}
+		return items, vals, nil
+	}
+
+	for _, item := range in.List {
+		v, err := evalExpr(tx, item, r)
+		if err != nil {
+			return nil, nil, err
+		}
+		items = append(items, item)
+		vals = append(vals, v)
+	}
+	return items, vals, nil
+}
+
+// evalBetween evaluates "x between low and high" as "x >= low and x <= high".
+func evalBetween(tx *Tx, b *Between, r *Record) (any, error) {
+	return evalLogical(tx, &Logical{
+		Op:    TkAnd,
+		Left:  &Expr{Binary: &Binary{Op: TkGreaterEq, Left: b.Operand, Right: b.Low}},
+		Right: &Expr{Binary: &Binary{Op: TkLessEqual, Left: b.Operand, Right: b.High}},
+	}, r)
+}
+
+// evalCondition evaluates the expression as a condition. nil is returned if the result is unknown.
+func evalCondition(tx *Tx, e *Expr, r *Record) (*bool, error) {
+	v, err := evalExpr(tx, e, r)
+	if err != nil {
+		return nil, err
+	}
+
+	switch v := v.(type) {
+	case nil:
+		return nil, nil
+	case bool:
+		return &v, nil
+	}
+
+	return nil, fmt.Errorf("condition must be bool but %v", v)
+}
+
+// typeOf returns the column type of the value.
+func typeOf(v any) string {
+	switch v.(type) {
+	case int64:
+		return TypeInt
+	case float64:
+		return TypeFloat
+	case bool:
+		return TypeBool
+	case time.Time:
+		return TypeTimestamp
+	}
+	return TypeString
+}
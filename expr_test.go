@@ -0,0 +1,33 @@
+package main
+
+import "testing"
+
+func TestMatchLike(t *testing.T) {
+	tests := []struct {
+		s       string
+		pattern string
+		want    bool
+	}{
+		{"laptop", "laptop", true},
+		{"laptop", "lap", false},
+		{"laptop", "lap%", true},
+		{"laptop", "%top", true},
+		{"laptop", "%p%", true},
+		{"laptop", "l_ptop", true},
+		{"laptop", "l_top", false},
+		{"laptop", "%", true},
+		{"", "%", true},
+		{"", "_", false},
+		{"aab", "%ab", true},
+		{"abcbd", "a%b%d", true},
+		{"abcbe", "a%b%d", false},
+		{"Laptop", "laptop", false},
+		{"日本語", "_本%", true},
+	}
+
+	for _, tc := range tests {
+		if got := matchLike(tc.s, tc.pattern); got != tc.want {
+			t.Fatalf("matchLike(%q, %q): got: %v, expected: %v", tc.s, tc.pattern, got, tc.want)
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
 )
 
 func readJsonFile(f *os.File, dst any) error {
@@ -23,18 +24,42 @@ func readJsonFile(f *os.File, dst any) error {
 	return nil
 }
 
-func updateJsonFile(f *os.File, src any) error {
-	// Drop the file content before write. Seek(0, 0) is needed to modify the IO offset.
-	if err := f.Truncate(0); err != nil {
-		return fmt.Errorf("clear file: %w", err)
+// replaceJsonFile atomically replaces the file content with src encoded as JSON.
+// The data is written into a temporary file first, then the temporary file is renamed to the path,
+// so the file is never left half-written even if the process dies in the middle.
+func replaceJsonFile(path string, src any) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0755)
+	if err != nil {
+		return fmt.Errorf("open temporary file: %w", err)
 	}
+	defer f.Close()
 
-	if _, err := f.Seek(0, 0); err != nil {
-		return fmt.Errorf("change file IO offset: %w", err)
+	if err := json.NewEncoder(f).Encode(src); err != nil {
+		return fmt.Errorf("encode JSON data into temporary file: %w", err)
 	}
 
-	if err := json.NewEncoder(f).Encode(src); err != nil {
-		return fmt.Errorf("encode JSON data into file: %w", err)
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("sync temporary file: %w", err)
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("rename temporary file: %w", err)
+	}
+
+	return syncDir(filepath.Dir(path))
+}
+
+// syncDir fsyncs the directory so that the file creation/rename/removal in it gets durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("open directory: %w", err)
+	}
+	defer d.Close()
+
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("sync directory: %w", err)
 	}
 
 	return nil
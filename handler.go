@@ -2,13 +2,17 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"slices"
+	"time"
 )
 
 func postQuery(w http.ResponseWriter, r *http.Request) {
 	type Req struct {
-		Query string
+		Query   string
+		Session string // empty means autocommit mode
 	}
 
 	var req Req
@@ -20,48 +24,247 @@ func postQuery(w http.ResponseWriter, r *http.Request) {
 
 	fmt.Printf("query: %s\n", req.Query)
 
-	result, err := runQuery(req.Query)
+	sess := getSession(req.Session)
+	defer sess.release()
+
+	result, err := runQuery(sess, req.Query)
 	if err != nil {
 		fmt.Printf("run query: %s\n", err)
-		json.NewEncoder(w).Encode(&Result{ErrorMsg: err.Error()})
+		code, retryable := errorCode(err)
+		json.NewEncoder(w).Encode(&Result{ErrorMsg: err.Error(), ErrorCode: code, Retryable: retryable})
 		return
 	}
 
 	json.NewEncoder(w).Encode(result)
 }
 
+func getStats(w http.ResponseWriter, r *http.Request) {
+	type Stats struct {
+		BufferHits   int64
+		BufferMisses int64
+		WalSyncs     int64
+	}
+
+	hits, misses := bufpool.Stats()
+
+	tsMu.Lock()
+	syncs := walSyncs
+	tsMu.Unlock()
+
+	json.NewEncoder(w).Encode(&Stats{BufferHits: hits, BufferMisses: misses, WalSyncs: syncs})
+}
+
 type Result struct {
 	Msg      string
 	Hdr      []string
-	Vals     [][]string
+	Types    []string
+	Vals     [][]*string // nil means NULL
 	ErrorMsg string
+
+	// ErrorCode is the SQLSTATE of the error if known. Retryable is true if the transaction can succeed on retry.
+	ErrorCode string
+	Retryable bool
+}
+
+// errorCode returns the SQLSTATE of the error and whether it is retryable.
+// The transaction aborted by the conflict with another (class 40) is retryable.
+func errorCode(err error) (string, bool) {
+	switch {
+	case errors.Is(err, errSerialization), errors.Is(err, errSerializationFailure):
+		return "40001", true // serialization_failure
+	case errors.Is(err, errDeadlock):
+		return "40P01", true // deadlock_detected
+	case errors.Is(err, errLockTimeout):
+		return "55P03", false // lock_not_available
+	case errors.Is(err, errTxAborted):
+		return "25P02", false // in_failed_sql_transaction
+	}
+	return "", false
 }
 
-func runQuery(query string) (*Result, error) {
+func runQuery(sess *Session, query string) (*Result, error) {
 	stmt, err := parse(query)
 	if err != nil {
+		sess.Fail()
 		return nil, fmt.Errorf("gramatically invalid: %w", err)
 	}
 
 	Debug("statement: ", stmt)
 
+	switch {
+	case stmt.Begin != nil:
+		if err := sess.Begin(); err != nil {
+			return nil, fmt.Errorf("begin transaction: %w", err)
+		}
+
+		return &Result{Msg: "transaction started"}, nil
+
+	case stmt.Commit != nil:
+		committed, err := sess.Commit()
+		if err != nil {
+			return nil, fmt.Errorf("commit transaction: %w", err)
+		}
+
+		if !committed {
+			return &Result{Msg: "transaction rolled back"}, nil
+		}
+
+		return &Result{Msg: "transaction committed"}, nil
+
+	case stmt.Rollback != nil && stmt.Rollback.Savepoint != "":
+		if err := sess.RollbackTo(stmt.Rollback.Savepoint); err != nil {
+			return nil, fmt.Errorf("rollback to savepoint: %w", err)
+		}
+
+		return &Result{Msg: fmt.Sprintf("rolled back to savepoint %s", stmt.Rollback.Savepoint)}, nil
+
+	case stmt.Rollback != nil:
+		if err := sess.Rollback(); err != nil {
+			return nil, fmt.Errorf("rollback transaction: %w", err)
+		}
+
+		return &Result{Msg: "transaction rolled back"}, nil
+
+	case stmt.Savepoint != nil:
+		if err := sess.Savepoint(stmt.Savepoint.Name); err != nil {
+			return nil, fmt.Errorf("define savepoint: %w", err)
+		}
+
+		return &Result{Msg: fmt.Sprintf("savepoint %s defined", stmt.Savepoint.Name)}, nil
+
+	case stmt.Release != nil:
+		if err := sess.Release(stmt.Release.Savepoint); err != nil {
+			return nil, fmt.Errorf("release savepoint: %w", err)
+		}
+
+		return &Result{Msg: fmt.Sprintf("savepoint %s released", stmt.Release.Savepoint)}, nil
+
+	case stmt.Set != nil && stmt.Set.LockTimeout != nil:
+		ms := *stmt.Set.LockTimeout
+		if err := sess.SetLockTimeout(time.Duration(ms) * time.Millisecond); err != nil {
+			return nil, fmt.Errorf("set lock timeout: %w", err)
+		}
+
+		return &Result{Msg: fmt.Sprintf("lock timeout set to %d ms", ms)}, nil
+
+	case stmt.Set != nil && stmt.Set.Isolation != "":
+		if err := sess.SetIsolation(isolationLevels[stmt.Set.Isolation]); err != nil {
+			return nil, fmt.Errorf("set transaction: %w", err)
+		}
+
+		return &Result{Msg: fmt.Sprintf("isolation level set to %s", stmt.Set.Isolation)}, nil
+	}
+
+	return sess.Run(nonTransactional(stmt), func(tx *Tx) (*Result, error) { return execStmt(tx, stmt) })
+}
+
+// nonTransactional returns the name of the statement if it cannot run in a transaction block.
+// The table definitions are not versioned, so the changes on them are never rolled back.
+func nonTransactional(stmt *QueryStmt) string {
+	switch {
+	case stmt.Create != nil:
+		return "create table"
+	case stmt.CreateIndex != nil:
+		return "create index"
+	case stmt.Drop != nil:
+		return "drop table"
+	case stmt.Alter != nil:
+		return "alter table"
+	case stmt.Checkpoint != nil:
+		return "checkpoint"
+	case stmt.Vacuum != nil:
+		return "vacuum"
+	}
+	return ""
+}
+
+// execStmt executes the statement in the transaction.
+func execStmt(tx *Tx, stmt *QueryStmt) (*Result, error) {
 	switch {
 	case stmt.Create != nil:
-		if err := execCreate(stmt.Create); err != nil {
+		created, err := execCreate(stmt.Create)
+		if err != nil {
 			return nil, fmt.Errorf("execute create statement: %w", err)
 		}
 
+		if !created {
+			return &Result{Msg: fmt.Sprintf("table %s already exists, skipped", stmt.Create.Table)}, nil
+		}
+
 		return &Result{Msg: fmt.Sprintf("table %s created", stmt.Create.Table)}, nil
 
+	case stmt.CreateIndex != nil:
+		if err := execCreateIndex(stmt.CreateIndex); err != nil {
+			return nil, fmt.Errorf("execute create index statement: %w", err)
+		}
+
+		return &Result{Msg: fmt.Sprintf("index %s created", stmt.CreateIndex.Name)}, nil
+
 	case stmt.Insert != nil:
-		if err := execInsert(stmt.Insert); err != nil {
+		n, err := execInsert(tx, stmt.Insert)
+		if err != nil {
 			return nil, fmt.Errorf("execute insert statement: %w", err)
 		}
 
-		return &Result{Msg: "inserted"}, nil
+		if n == 1 {
+			return &Result{Msg: "inserted"}, nil
+		}
+
+		return &Result{Msg: fmt.Sprintf("%d rows inserted", n)}, nil
+
+	case stmt.Update != nil:
+		n, err := execUpdate(tx, stmt.Update)
+		if err != nil {
+			return nil, fmt.Errorf("execute update statement: %w", err)
+		}
+
+		return &Result{Msg: fmt.Sprintf("%d rows updated", n)}, nil
+
+	case stmt.Delete != nil:
+		n, err := execDelete(tx, stmt.Delete)
+		if err != nil {
+			return nil, fmt.Errorf("execute delete statement: %w", err)
+		}
+
+		return &Result{Msg: fmt.Sprintf("%d rows deleted", n)}, nil
+
+	case stmt.Drop != nil:
+		dropped, err := dropTable(stmt.Drop.Table, stmt.Drop.IfExists)
+		if err != nil {
+			return nil, fmt.Errorf("execute drop statement: %w", err)
+		}
+
+		if !dropped {
+			return &Result{Msg: fmt.Sprintf("table %s not found, skipped", stmt.Drop.Table)}, nil
+		}
+
+		return &Result{Msg: fmt.Sprintf("table %s dropped", stmt.Drop.Table)}, nil
+
+	case stmt.Alter != nil:
+		if err := execAlter(tx, stmt.Alter); err != nil {
+			return nil, fmt.Errorf("execute alter statement: %w", err)
+		}
+
+		return &Result{Msg: fmt.Sprintf("table %s altered", stmt.Alter.Table)}, nil
+
+	case stmt.Checkpoint != nil:
+		lsn, err := checkpoint()
+		if err != nil {
+			return nil, fmt.Errorf("execute checkpoint: %w", err)
+		}
+
+		return &Result{Msg: fmt.Sprintf("checkpoint done at LSN %d", lsn)}, nil
+
+	case stmt.Vacuum != nil:
+		stats, err := vacuum(stmt.Vacuum.Table)
+		if err != nil {
+			return nil, fmt.Errorf("execute vacuum: %w", err)
+		}
+
+		return &Result{Msg: fmt.Sprintf("vacuum done, %d rows (%d bytes) reclaimed", stats.Rows, stats.Bytes)}, nil
 
 	case stmt.Select != nil:
-		results, err := execSelect(stmt.Select)
+		results, err := execSelect(tx, stmt.Select)
 		if err != nil {
 			return nil, fmt.Errorf("execute select statement: %w", err)
 		}
@@ -70,11 +273,18 @@ func runQuery(query string) (*Result, error) {
 			return &Result{Msg: "no results"}, nil
 		}
 
-		res := Result{Hdr: results[0].Cols}
+		res := Result{Hdr: results[0].Cols, Types: results[0].Types}
 
-		vals := [][]string{}
+		vals := [][]*string{}
 		for _, r := range results {
-			vals = append(vals, r.Vals)
+			row := make([]*string, len(r.Vals))
+			for i, v := range r.Vals {
+				if v != nil {
+					s := formatValue(v)
+					row[i] = &s
+				}
+			}
+			vals = append(vals, row)
 		}
 		res.Vals = vals
 
@@ -84,37 +294,229 @@ func runQuery(query string) (*Result, error) {
 	panic("never come")
 }
 
-func execCreate(c *Create) error {
-	if err := addTable(c.Table, c.Cols, c.Types); err != nil {
-		return fmt.Errorf("add table %s in catalog: %w", c.Table, err)
+func execCreate(c *Create) (bool, error) {
+	cols := make([]*CtCol, len(c.Cols))
+	for i := range c.Cols {
+		cols[i] = &CtCol{Name: c.Cols[i], Type: c.Types[i], NotNull: c.NotNulls[i], Default: c.Defaults[i]}
+	}
+
+	tDef := &CtTable{Name: c.Table, Cols: cols}
+
+	if c.PrimaryKey != nil {
+		// primary key columns are implicitly not null
+		for _, col := range c.PrimaryKey {
+			if i := tDef.ColIndex(col); i >= 0 {
+				cols[i].NotNull = true
+			}
+		}
+		tDef.Indexes = append(tDef.Indexes, newConstraintIndex(c.Table, c.PrimaryKey, true))
+	}
+
+	for _, u := range c.Uniques {
+		tDef.Indexes = append(tDef.Indexes, newConstraintIndex(c.Table, u, false))
+	}
+
+	created, err := createTable(tDef, c.IfNotExists)
+	if err != nil {
+		return false, fmt.Errorf("create table %s: %w", c.Table, err)
 	}
 
-	if err := createTable(c.Table); err != nil {
-		return fmt.Errorf("create table %s: %w", c.Table, err)
+	return created, nil
+}
+
+func execCreateIndex(c *CreateIndex) error {
+	err := alterTable(c.Table, func(tDef *CtTable) (*CtTable, *int, error) {
+		idx := &CtIndex{Name: c.Name, Cols: c.Cols, Unique: c.Unique}
+		if err := validateUnique(tDef, idx); err != nil {
+			return nil, nil, err
+		}
+
+		newDef := &CtTable{Name: tDef.Name, Cols: tDef.Cols, Indexes: append(slices.Clone(tDef.Indexes), idx)}
+		return newDef, nil, nil
+	})
+	if err != nil {
+		return fmt.Errorf("create index %s on %s: %w", c.Name, c.Table, err)
 	}
 
 	return nil
 }
 
-func execInsert(i *Insert) error {
-	if err := save(i.Table, i.Cols, i.Vals); err != nil {
-		return fmt.Errorf("save data into %s: %w", i.Table, err)
+func execAlter(tx *Tx, a *Alter) error {
+	err := alterTable(a.Table, func(tDef *CtTable) (*CtTable, *int, error) {
+		newDef := &CtTable{Name: tDef.Name, Cols: slices.Clone(tDef.Cols), Indexes: slices.Clone(tDef.Indexes)}
+		colIndex := func(col string) int {
+			return slices.IndexFunc(newDef.Cols, func(c *CtCol) bool { return c.Name == col })
+		}
+
+		switch {
+		case a.AddCol != nil:
+			c := a.AddCol
+			if colIndex(c.Name) >= 0 {
+				return nil, nil, fmt.Errorf("column '%s' already exists in table '%s'", c.Name, a.Table)
+			}
+
+			// the existing records get the default value on the new column
+			notNull := c.NotNull || c.PrimaryKey
+			if notNull && c.Default == nil {
+				rs, err := readData(tx, a.Table)
+				if err != nil {
+					return nil, nil, err
+				}
+
+				if len(rs) != 0 {
+					return nil, nil, fmt.Errorf("column '%s' must have default value to be not null because table '%s' is not empty", c.Name, a.Table)
+				}
+			}
+
+			newDef.Cols = append(newDef.Cols, &CtCol{Name: c.Name, Type: c.Type, NotNull: notNull, Default: c.Default})
+
+			added := []*CtIndex{}
+			if c.PrimaryKey {
+				added = append(added, newConstraintIndex(a.Table, []string{c.Name}, true))
+			}
+			if c.Unique {
+				added = append(added, newConstraintIndex(a.Table, []string{c.Name}, false))
+			}
+
+			for _, idx := range added {
+				if err := validateUnique(newDef, idx); err != nil {
+					return nil, nil, err
+				}
+			}
+			newDef.Indexes = append(newDef.Indexes, added...)
+
+			return newDef, nil, nil
+
+		case a.DropCol != "":
+			i := colIndex(a.DropCol)
+			if i < 0 {
+				return nil, nil, fmt.Errorf("column '%s' is not found in table '%s'", a.DropCol, a.Table)
+			}
+
+			newDef.Cols = slices.Delete(newDef.Cols, i, i+1)
+
+			// the indexes on the column are dropped together
+			newDef.Indexes = slices.DeleteFunc(newDef.Indexes, func(idx *CtIndex) bool {
+				return slices.Contains(idx.Cols, a.DropCol)
+			})
+
+			return newDef, &i, nil
+
+		case a.RenameCol != nil:
+			i := colIndex(a.RenameCol.From)
+			if i < 0 {
+				return nil, nil, fmt.Errorf("column '%s' is not found in table '%s'", a.RenameCol.From, a.Table)
+			}
+
+			if colIndex(a.RenameCol.To) >= 0 {
+				return nil, nil, fmt.Errorf("column '%s' already exists in table '%s'", a.RenameCol.To, a.Table)
+			}
+
+			c := *newDef.Cols[i]
+			c.Name = a.RenameCol.To
+			newDef.Cols[i] = &c
+
+			for j, idx := range newDef.Indexes {
+				if k := slices.Index(idx.Cols, a.RenameCol.From); k >= 0 {
+					renamed := *idx
+					renamed.Cols = slices.Clone(idx.Cols)
+					renamed.Cols[k] = a.RenameCol.To
+					newDef.Indexes[j] = &renamed
+				}
+			}
+
+			return newDef, nil, nil
+
+		default:
+			newDef.Name = a.RenameTo
+			return newDef, nil, nil
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("alter table %s: %w", a.Table, err)
 	}
 
 	return nil
 }
 
-func execSelect(s *Select) ([]*Record, error) {
-	pln := planSelect(s)
+func execInsert(tx *Tx, i *Insert) (int, error) {
+	rows := i.Rows
+	if i.Select != nil {
+		rs, err := planSelect(tx, i.Select, nil).Run()
+		if err != nil {
+			return 0, fmt.Errorf("compute select result: %w", err)
+		}
+
+		// the values are parsed again in the types of the columns they are inserted into
+		rows = make([][]*string, len(rs))
+		for j, r := range rs {
+			vals, err := selectListValues(i.Select, r)
+			if err != nil {
+				return 0, err
+			}
+
+			rows[j] = make([]*string, len(vals))
+			for k, v := range vals {
+				if v != nil {
+					lit := formatValue(v)
+					rows[j][k] = &lit
+				}
+			}
+		}
+	}
+
+	if err := save(tx, i.Table, i.Cols, rows); err != nil {
+		return 0, fmt.Errorf("save data into %s: %w", i.Table, err)
+	}
+
+	return len(rows), nil
+}
 
-	var result []*Record
-	for _, ops := range pln.Ops {
-		r, err := ops(result)
+func execUpdate(tx *Tx, u *Update) (int, error) {
+	n, err := update(tx, u.Table, u.Cols, u.Vals, u.Where)
+	if err != nil {
+		return 0, fmt.Errorf("update data in %s: %w", u.Table, err)
+	}
+
+	return n, nil
+}
+
+func execDelete(tx *Tx, d *Delete) (int, error) {
+	n, err := remove(tx, d.Table, d.Where)
+	if err != nil {
+		return 0, fmt.Errorf("delete data from %s: %w", d.Table, err)
+	}
+
+	return n, nil
+}
+
+// selectListValues returns the values of the record in the order of the select list.
+// The projection keeps the columns in the order of the table, but the aggregated records are in the order of the list.
+func selectListValues(slct *Select, r *Record) ([]any, error) {
+	if grouped(slct) || slct.Items[0].Column == "*" {
+		return r.Vals, nil
+	}
+
+	vals := make([]any, len(slct.Items))
+	for n, item := range slct.Items {
+		col := item.Column
+		if item.Alias != "" {
+			col = item.Alias
+		}
+
+		i, err := r.ColIndex(col)
 		if err != nil {
-			return nil, fmt.Errorf("compute select result: %w", err)
+			return nil, err
 		}
+		vals[n] = r.Vals[i]
+	}
+	return vals, nil
+}
 
-		result = r
+func execSelect(tx *Tx, s *Select) ([]*Record, error) {
+	result, err := planSelect(tx, s, nil).Run()
+	if err != nil {
+		return nil, fmt.Errorf("compute select result: %w", err)
 	}
 
 	return result, nil
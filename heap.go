@@ -0,0 +1,369 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Each table is stored in its own heap file (e.g. "data/incdb.heap.tbl1") which is a sequence of pages.
+// Pages are not fsynced on write. Instead, every heap file is fsynced on checkpoint
+// and the changes after the checkpoint are redone from WAL on the recovery.
+var heapfilePrefix = "data/incdb.heap"
+
+func init() {
+	// test mode
+	if os.Getenv("INCDB_TEST") == "1" {
+		heapfilePrefix = "data/test.incdb.heap"
+	}
+}
+
+// RID (record ID) is the location of a tuple in a heap file.
+type RID struct {
+	Page uint32
+	Slot uint16
+}
+
+func (rid RID) Less(other RID) bool {
+	if rid.Page != other.Page {
+		return rid.Page < other.Page
+	}
+	return rid.Slot < other.Slot
+}
+
+func heapPath(tbl string) string {
+	return heapfilePrefix + "." + tbl
+}
+
+// listHeaps returns the name of the tables which have a heap file.
+func listHeaps() ([]string, error) {
+	paths, err := filepath.Glob(heapfilePrefix + ".*")
+	if err != nil {
+		return nil, fmt.Errorf("list heap files: %w", err)
+	}
+
+	tbls := make([]string, len(paths))
+	for i, p := range paths {
+		tbls[i] = strings.TrimPrefix(p, heapfilePrefix+".")
+	}
+	return tbls, nil
+}
+
+func heapExists(tbl string) (bool, error) {
+	_, err := os.Stat(heapPath(tbl))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, fmt.Errorf("stat heap file: %w", err)
+	}
+
+	return true, nil
+}
+
+// createHeap creates the empty heap file. Nothing is done if it already exists.
+func createHeap(tbl string) error {
+	f, err := os.OpenFile(heapPath(tbl), os.O_RDWR|os.O_CREATE, 0755)
+	if err != nil {
+		return fmt.Errorf("create heap file: %w", err)
+	}
+	defer f.Close()
+
+	return syncDir(filepath.Dir(heapfilePrefix))
+}
+
+// removeHeap removes the heap file. Nothing is done if it does not exist.
+func removeHeap(tbl string) error {
+	if err := os.Remove(heapPath(tbl)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("remove heap file: %w", err)
+	}
+
+	return syncDir(filepath.Dir(heapfilePrefix))
+}
+
+// renameHeap renames the heap file. If it is already renamed, the file of the old name is removed
+// because it can exist only when it is re-created by redoing WAL, and the later entries re-create it again if needed.
+func renameHeap(from, to string) error {
+	if ok, err := heapExists(from); err != nil {
+		return err
+	} else if !ok {
+		return nil
+	}
+
+	if ok, err := heapExists(to); err != nil {
+		return err
+	} else if ok {
+		return removeHeap(from)
+	}
+
+	if err := os.Rename(heapPath(from), heapPath(to)); err != nil {
+		return fmt.Errorf("rename heap file: %w", err)
+	}
+
+	return syncDir(filepath.Dir(heapfilePrefix))
+}
+
+// readPage reads the n-th page in the heap file. An empty page is returned if the page does not exist yet.
+func readPage(tbl string, n uint32) (Page, error) {
+	f, err := os.OpenFile(heapPath(tbl), os.O_RDONLY, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("open heap file: %w", err)
+	}
+	defer f.Close()
+
+	p := NewPage()
+	if _, err := f.ReadAt(p, int64(n)*pageSize); err != nil {
+		if errors.Is(err, io.EOF) {
+			return NewPage(), nil
+		}
+		return nil, fmt.Errorf("read page %d: %w", n, err)
+	}
+
+	return p, nil
+}
+
+func writePage(tbl string, n uint32, p Page) error {
+	f, err := os.OpenFile(heapPath(tbl), os.O_WRONLY, 0755)
+	if err != nil {
+		return fmt.Errorf("open heap file: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := f.WriteAt(p, int64(n)*pageSize); err != nil {
+		return fmt.Errorf("write page %d: %w", n, err)
+	}
+
+	return nil
+}
+
+func syncHeap(tbl string) error {
+	f, err := os.OpenFile(heapPath(tbl), os.O_RDWR, 0755)
+	if err != nil {
+		return fmt.Errorf("open heap file: %w", err)
+	}
+	defer f.Close()
+
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("sync heap file: %w", err)
+	}
+
+	return nil
+}
+
+// scanHeap calls fn with every page in the heap file at the path.
+func scanHeap(path string, fn func(n uint32, p Page) error) error {
+	f, err := os.OpenFile(path, os.O_RDONLY, 0755)
+	if err != nil {
+		return fmt.Errorf("open heap file: %w", err)
+	}
+	defer f.Close()
+
+	for n := uint32(0); ; n++ {
+		p := NewPage()
+		if _, err := io.ReadFull(f, p); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("read page %d: %w", n, err)
+		}
+
+		if err := fn(n, p); err != nil {
+			return err
+		}
+	}
+}
+
+// scanTuples calls fn with every tuple in the heap file at the path.
+// Every version of the records is passed regardless of its visibility.
+func scanTuples(path string, fn func(rid RID, ver Version, vals []any) error) error {
+	return scanHeap(path, func(n uint32, p Page) error {
+		for i := 0; i < p.Slots(); i++ {
+			t := p.Tuple(i)
+			if t == nil {
+				continue
+			}
+
+			ver, vals, err := decodeHeapTuple(t)
+			if err != nil {
+				return fmt.Errorf("decode tuple at page %d slot %d: %w", n, i, err)
+			}
+
+			if err := fn(RID{Page: n, Slot: uint16(i)}, ver, vals); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Version is the header of a tuple in the heap file, which tells the transactions the version of the record is visible to.
+// Xmin is the transaction which created the version, and Xmax is the one which deleted it (0 if not deleted).
+// Updating a record deletes the old version and creates the new one. See tx.go for the visibility.
+//
+//	| xmin (8B) | xmax (8B) | values (see encodeTuple) |
+//
+// Xmin is 0 if the version is created outside of transactions (e.g. migration), which is visible to every transaction.
+type Version struct {
+	Xmin uint64
+	Xmax uint64
+}
+
+const tupleHeaderSize = 16
+
+// makeHeapTuple prepends the header of the version to the encoded values.
+func makeHeapTuple(ver Version, vals []byte) []byte {
+	b := make([]byte, tupleHeaderSize, tupleHeaderSize+len(vals))
+	binary.LittleEndian.PutUint64(b[0:8], ver.Xmin)
+	binary.LittleEndian.PutUint64(b[8:16], ver.Xmax)
+	return append(b, vals...)
+}
+
+// setTupleXmax sets xmax in the header of the tuple in place.
+func setTupleXmax(t []byte, xmax uint64) {
+	binary.LittleEndian.PutUint64(t[8:16], xmax)
+}
+
+// decodeHeapTuple decodes the tuple in the heap file into its header and values.
+func decodeHeapTuple(t []byte) (Version, []any, error) {
+	if len(t) < tupleHeaderSize {
+		return Version{}, nil, fmt.Errorf("tuple is too short")
+	}
+
+	vals, err := decodeTuple(t[tupleHeaderSize:])
+	return tupleVersion(t), vals, err
+}
+
+// tupleVersion returns the header of the tuple in the heap file. The tuple must have the header.
+func tupleVersion(t []byte) Version {
+	return Version{Xmin: binary.LittleEndian.Uint64(t[0:8]), Xmax: binary.LittleEndian.Uint64(t[8:16])}
+}
+
+// Value tags in a tuple.
+const (
+	tagNull      = byte(0)
+	tagString    = byte(1)
+	tagInt       = byte(2)
+	tagFloat     = byte(3)
+	tagBool      = byte(4)
+	tagTimestamp = byte(5)
+)
+
+// encodeTuple encodes the values into a tuple. Each value is stored in its native binary format after its tag:
+//
+//	| number of values (2B) | tag (1B) | value 1 | tag (1B) | value 2 | ...
+//
+// string value is its length (2B) and bytes, int/float/timestamp (unix nano) value is 8 bytes and bool value is 1 byte.
+// NULL has only the tag.
+func encodeTuple(vals []any) ([]byte, error) {
+	b := binary.LittleEndian.AppendUint16(nil, uint16(len(vals)))
+	for _, v := range vals {
+		switch v := v.(type) {
+		case nil:
+			b = append(b, tagNull)
+
+		case string:
+			if len(v) > math.MaxUint16 {
+				return nil, fmt.Errorf("string value is too long: %d bytes", len(v))
+			}
+			b = append(b, tagString)
+			b = binary.LittleEndian.AppendUint16(b, uint16(len(v)))
+			b = append(b, v...)
+
+		case int64:
+			b = append(b, tagInt)
+			b = binary.LittleEndian.AppendUint64(b, uint64(v))
+
+		case float64:
+			b = append(b, tagFloat)
+			b = binary.LittleEndian.AppendUint64(b, math.Float64bits(v))
+
+		case bool:
+			b = append(b, tagBool)
+			if v {
+				b = append(b, 1)
+			} else {
+				b = append(b, 0)
+			}
+
+		case time.Time:
+			b = append(b, tagTimestamp)
+			b = binary.LittleEndian.AppendUint64(b, uint64(v.UnixNano()))
+
+		default:
+			return nil, fmt.Errorf("value of unsupported type: %T", v)
+		}
+	}
+	return b, nil
+}
+
+func decodeTuple(b []byte) ([]any, error) {
+	errShort := fmt.Errorf("tuple is too short")
+
+	if len(b) < 2 {
+		return nil, errShort
+	}
+
+	n := int(binary.LittleEndian.Uint16(b))
+	b = b[2:]
+
+	vals := make([]any, n)
+	for i := range vals {
+		if len(b) < 1 {
+			return nil, errShort
+		}
+
+		tag := b[0]
+		b = b[1:]
+
+		switch tag {
+		case tagNull:
+			vals[i] = nil
+
+		case tagString:
+			if len(b) < 2 {
+				return nil, errShort
+			}
+			l := int(binary.LittleEndian.Uint16(b))
+			b = b[2:]
+			if len(b) < l {
+				return nil, errShort
+			}
+			vals[i] = string(b[:l])
+			b = b[l:]
+
+		case tagInt, tagFloat, tagTimestamp:
+			if len(b) < 8 {
+				return nil, errShort
+			}
+			u := binary.LittleEndian.Uint64(b)
+			b = b[8:]
+			switch tag {
+			case tagInt:
+				vals[i] = int64(u)
+			case tagFloat:
+				vals[i] = math.Float64frombits(u)
+			case tagTimestamp:
+				vals[i] = time.Unix(0, int64(u)).UTC()
+			}
+
+		case tagBool:
+			if len(b) < 1 {
+				return nil, errShort
+			}
+			vals[i] = b[0] == 1
+			b = b[1:]
+
+		default:
+			return nil, fmt.Errorf("unknown value tag: %d", tag)
+		}
+	}
+
+	return vals, nil
+}
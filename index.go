@@ -0,0 +1,317 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Indexes are kept on memory as B+trees (see btree.go) and their definitions are stored in the catalog.
+// The trees are built from the heap file on the first access after the launch or the table definition change,
+// then they are maintained on applying every WAL entry on the table.
+// Every version of the records is in the indexes, so the visibility must be checked on the record.
+
+// Index is the B+tree of an index. The key is the values of the indexed columns.
+type Index struct {
+	Def  *CtIndex
+	cols []int // position of the indexed columns in the table
+	tree *BTree
+}
+
+type tableIndexes struct {
+	def  *CtTable
+	list []*Index
+}
+
+// indexes is the built indexes of each table. The table is missing if they have not been built.
+// indexes and their trees are protected by idxMu, which is separated from tsMu so that the index scans
+// can be done while the changes are being made (e.g. subqueries in the condition of update).
+// If both are needed, tsMu must be locked first.
+var (
+	indexes = map[string]*tableIndexes{}
+	idxMu   sync.Mutex
+)
+
+func newIndex(tDef *CtTable, def *CtIndex) (*Index, error) {
+	idx := &Index{Def: def, tree: NewBTree()}
+	for _, col := range def.Cols {
+		i := tDef.ColIndex(col)
+		if i < 0 {
+			return nil, fmt.Errorf("column '%s' of index '%s' is not found in table '%s'", col, def.Name, tDef.Name)
+		}
+		idx.cols = append(idx.cols, i)
+	}
+	return idx, nil
+}
+
+// loadIndexes returns the indexes of the table. They are built from the heap file if not yet.
+// Callers must hold idxMu.
+func loadIndexes(tDef *CtTable) ([]*Index, error) {
+	if ti, ok := indexes[tDef.Name]; ok {
+		return ti.list, nil
+	}
+
+	ti := &tableIndexes{def: tDef}
+	for _, def := range tDef.Indexes {
+		idx, err := newIndex(tDef, def)
+		if err != nil {
+			return nil, err
+		}
+		ti.list = append(ti.list, idx)
+	}
+
+	if len(ti.list) != 0 {
+		if err := scanTuples(heapPath(tDef.Name), func(rid RID, ver Version, vals []any) error {
+			r := newRecord(tDef, rid, ver, vals)
+			for _, idx := range ti.list {
+				idx.tree.Insert(idx.Key(r.Vals), rid)
+			}
+			return nil
+		}); err != nil {
+			return nil, fmt.Errorf("build indexes of table '%s': %w", tDef.Name, err)
+		}
+	}
+
+	indexes[tDef.Name] = ti
+	return ti.list, nil
+}
+
+// dropIndexes discards the built indexes of the table. They are rebuilt on the next access.
+func dropIndexes(tbl string) {
+	idxMu.Lock()
+	defer idxMu.Unlock()
+
+	delete(indexes, tbl)
+}
+
+// indexTuple inserts or deletes the key of the tuple at the rid on the built indexes of the table.
+func indexTuple(tbl string, rid RID, tuple []byte, insert bool) error {
+	idxMu.Lock()
+	defer idxMu.Unlock()
+
+	ti, ok := indexes[tbl]
+	if !ok || len(ti.list) == 0 {
+		return nil // built on the next loadIndexes
+	}
+
+	ver, vals, err := decodeHeapTuple(tuple)
+	if err != nil {
+		return fmt.Errorf("decode tuple: %w", err)
+	}
+
+	r := newRecord(ti.def, rid, ver, vals)
+	for _, idx := range ti.list {
+		if insert {
+			idx.tree.Insert(idx.Key(r.Vals), rid)
+		} else {
+			idx.tree.Delete(idx.Key(r.Vals), rid)
+		}
+	}
+	return nil
+}
+
+// Key returns the key of the record values in the index.
+func (idx *Index) Key(vals []any) []any {
+	key := make([]any, len(idx.cols))
+	for i, c := range idx.cols {
+		key[i] = vals[c]
+	}
+	return key
+}
+
+// keyRange is the range of index keys. The keys begin with the values in Eq, and their next value is in
+// the range between Low and High. nil Low/High means the bound is not given.
+type keyRange struct {
+	Eq                []any
+	Low, High         any
+	LowIncl, HighIncl bool
+}
+
+// Contains returns true if the key is in the range. NULL is never in a range.
+func (r *keyRange) Contains(key []any) bool {
+	n := len(r.Eq)
+	if compareKeys(key[:n], r.Eq) != 0 {
+		return false
+	}
+
+	if r.Low == nil && r.High == nil {
+		return true
+	}
+
+	v := key[n]
+	if v == nil {
+		return false
+	}
+
+	if r.Low != nil {
+		if c := compareValues(v, r.Low); c < 0 || c == 0 && !r.LowIncl {
+			return false
+		}
+	}
+
+	if r.High != nil {
+		if c := compareValues(v, r.High); c > 0 || c == 0 && !r.HighIncl {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Scan calls fn with the keys in the range and the rids of their records, in the key order.
+// NULL is never in a range. The scan stops when fn returns false.
+func (idx *Index) Scan(r *keyRange, fn func(key []any, rid RID) bool) {
+	from := slices.Clone(r.Eq)
+	if r.Low != nil {
+		from = append(from, r.Low)
+	}
+
+	n := len(r.Eq)
+	idx.tree.Ascend(from, func(key []any, rid RID) bool {
+		if compareKeys(key[:n], r.Eq) != 0 {
+			return false
+		}
+
+		if r.Low == nil && r.High == nil {
+			return fn(key, rid)
+		}
+
+		v := key[n]
+		if v == nil {
+			return false // NULLs are at the end
+		}
+
+		if r.Low != nil && !r.LowIncl && compareValues(v, r.Low) == 0 {
+			return true
+		}
+
+		if r.High != nil {
+			if c := compareValues(v, r.High); c > 0 || c == 0 && !r.HighIncl {
+				return false
+			}
+		}
+
+		return fn(key, rid)
+	})
+}
+
+// Record makes the record from the key, which has only the indexed columns of the table.
+func (idx *Index) Record(tDef *CtTable, rid RID, key []any) *Record {
+	r := &Record{RID: rid}
+	for i, col := range tDef.Cols {
+		if j := slices.Index(idx.cols, i); j >= 0 {
+			r.Cols = append(r.Cols, col.Name)
+			r.Types = append(r.Types, col.Type)
+			r.Vals = append(r.Vals, key[j])
+		}
+	}
+	return r
+}
+
+// uniqueKey returns the key as a string to find the duplicates.
+// false is returned if the key contains NULL, because NULL is never equal to another NULL.
+func uniqueKey(key []any) (string, bool) {
+	for _, v := range key {
+		if v == nil {
+			return "", false
+		}
+	}
+
+	t, _ := encodeTuple(key) // the values are already encoded in the tuple
+	return string(t), true
+}
+
+func duplicateKeyError(idx *Index, key []any) error {
+	vals := make([]string, len(key))
+	for i, v := range key {
+		vals[i] = formatValue(v)
+	}
+	return fmt.Errorf("duplicate key (%s)=(%s) violates unique constraint '%s'",
+		strings.Join(idx.Def.Cols, ", "), strings.Join(vals, ", "), idx.Def.Name)
+}
+
+// checkUnique returns an error if the records violate the unique indexes of the table.
+// The records in the table at the rids in exclude are ignored because they are going to be replaced,
+// and so are the dead versions. The versions which are not visible to the transaction but can be committed
+// are regarded as conflicts, even if they are in progress.
+// Callers must hold tsMu so that no other change is made until the records are written.
+func checkUnique(tx *Tx, tDef *CtTable, records [][]any, exclude map[RID]bool) error {
+	idxMu.Lock()
+	defer idxMu.Unlock()
+
+	idxs, err := loadIndexes(tDef)
+	if err != nil {
+		return err
+	}
+
+	for _, idx := range idxs {
+		if !idx.Def.Unique {
+			continue
+		}
+
+		seen := map[string]bool{}
+		for _, vals := range records {
+			key := idx.Key(vals)
+			k, ok := uniqueKey(key)
+			if !ok {
+				continue
+			}
+
+			if seen[k] {
+				return duplicateKeyError(idx, key)
+			}
+			seen[k] = true
+
+			for _, rid := range idx.tree.Lookup(key) {
+				if exclude[rid] {
+					continue
+				}
+
+				r, err := readRecord(tDef, rid)
+				if err != nil {
+					return err
+				}
+
+				if r != nil && !dead(tx, r.Version) {
+					return duplicateKeyError(idx, key)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// validateUnique returns an error if the records in the heap file violate the new unique index.
+// The records are read in the new table definition, so the added columns have the default values.
+// The dead versions are ignored.
+func validateUnique(tDef *CtTable, def *CtIndex) error {
+	if !def.Unique {
+		return nil
+	}
+
+	idx, err := newIndex(tDef, def)
+	if err != nil {
+		return err
+	}
+
+	seen := map[string]bool{}
+	return scanTuples(heapPath(tDef.Name), func(rid RID, ver Version, vals []any) error {
+		if dead(nil, ver) {
+			return nil
+		}
+
+		key := idx.Key(newRecord(tDef, rid, ver, vals).Vals)
+		k, ok := uniqueKey(key)
+		if !ok {
+			return nil
+		}
+
+		if seen[k] {
+			return duplicateKeyError(idx, key)
+		}
+		seen[k] = true
+		return nil
+	})
+}
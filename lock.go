@@ -0,0 +1,166 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Row locks are taken by "select ... for update/share" and by the records to be updated or deleted,
+// so that a read-modify-write in a transaction block is not interleaved by the others.
+// They are kept on memory by the table and the rid of the record, and held until the transaction ends.
+// Readers without the locking clause never take them.
+//
+// A transaction waits for the lock held by another in a conflicting mode. Each waiting transaction is in
+// the wait-for graph, and the one whose wait would close a cycle in the graph gets errDeadlock instead of waiting.
+// The wait gives up with errLockTimeout after the lock timeout of the session, if set.
+
+type lockMode int
+
+const (
+	lockShare     lockMode = iota + 1 // "for share"
+	lockExclusive                     // "for update", update and delete
+)
+
+func (m lockMode) String() string {
+	if m == lockExclusive {
+		return "update"
+	}
+	return "share"
+}
+
+type lockKey struct {
+	tbl string
+	rid RID
+}
+
+// rowLock is the lock of a record. released is closed when any holder releases it, to wake up the waiters.
+type rowLock struct {
+	holders  map[*Tx]lockMode
+	released chan struct{}
+}
+
+// conflicts returns the holders other than tx whose mode conflicts with the mode.
+func (l *rowLock) conflicts(tx *Tx, mode lockMode) []*Tx {
+	txs := []*Tx{}
+	for h, m := range l.holders {
+		if h != tx && (m == lockExclusive || mode == lockExclusive) {
+			txs = append(txs, h)
+		}
+	}
+	return txs
+}
+
+// lockWait is the lock a transaction is waiting for.
+type lockWait struct {
+	key  lockKey
+	mode lockMode
+}
+
+// rowLocks is the locks held by the transactions in progress, and waitsFor is the locks they are waiting for,
+// which makes the wait-for graph. They are protected by lockMu.
+var (
+	rowLocks = map[lockKey]*rowLock{}
+	waitsFor = map[*Tx]*lockWait{}
+	lockMu   sync.Mutex
+)
+
+var (
+	errDeadlock    = errors.New("deadlock detected")
+	errLockTimeout = errors.New("canceling statement due to lock timeout")
+)
+
+// lockRow takes the lock of the record in the mode, waiting until the conflicting holders end.
+// The lock already held in the same or stronger mode is kept as is.
+func (tx *Tx) lockRow(tbl string, rid RID, mode lockMode) error {
+	key := lockKey{tbl: tbl, rid: rid}
+
+	var timeout <-chan time.Time
+	if tx.lockTimeout > 0 {
+		timer := time.NewTimer(tx.lockTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	lockMu.Lock()
+	for {
+		l, ok := rowLocks[key]
+		if !ok {
+			l = &rowLock{holders: map[*Tx]lockMode{}, released: make(chan struct{})}
+			rowLocks[key] = l
+		}
+
+		if len(l.conflicts(tx, mode)) == 0 {
+			delete(waitsFor, tx)
+			if _, held := l.holders[tx]; !held {
+				tx.locks = append(tx.locks, key)
+			}
+			l.holders[tx] = max(l.holders[tx], mode)
+			lockMu.Unlock()
+			return nil
+		}
+
+		waitsFor[tx] = &lockWait{key: key, mode: mode}
+		if waitsOn(tx, tx, map[*Tx]bool{}) {
+			delete(waitsFor, tx)
+			lockMu.Unlock()
+			return fmt.Errorf("%w: transaction %d waits for %s lock on page %d slot %d of table '%s'", errDeadlock, tx.ID, mode, rid.Page, rid.Slot, tbl)
+		}
+
+		released := l.released
+		lockMu.Unlock()
+
+		select {
+		case <-released:
+		case <-timeout:
+			lockMu.Lock()
+			delete(waitsFor, tx)
+			lockMu.Unlock()
+			return errLockTimeout
+		}
+
+		lockMu.Lock()
+	}
+}
+
+// waitsOn returns true if tx waits for target directly or through the other waiting transactions.
+// Callers must hold lockMu.
+func waitsOn(tx, target *Tx, visited map[*Tx]bool) bool {
+	w, ok := waitsFor[tx]
+	if !ok || visited[tx] {
+		return false
+	}
+	visited[tx] = true
+
+	l, ok := rowLocks[w.key]
+	if !ok {
+		return false // released, and tx is being woken up
+	}
+
+	for _, h := range l.conflicts(tx, w.mode) {
+		if h == target || waitsOn(h, target, visited) {
+			return true
+		}
+	}
+	return false
+}
+
+// releaseLocks releases the locks held by the transaction and wakes up their waiters.
+// This must be called after the end of the transaction is visible, so that the waiters see it.
+func releaseLocks(tx *Tx) {
+	lockMu.Lock()
+	defer lockMu.Unlock()
+
+	for _, key := range tx.locks {
+		l := rowLocks[key]
+		delete(l.holders, tx)
+		close(l.released)
+		l.released = make(chan struct{})
+		if len(l.holders) == 0 {
+			delete(rowLocks, key)
+		}
+	}
+	tx.locks = nil
+	delete(waitsFor, tx)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLockRow(t *testing.T) {
+	tx1, tx2, tx3 := beginTx(), beginTx(), beginTx()
+	t.Cleanup(func() {
+		tx1.Rollback()
+		tx2.Rollback()
+		tx3.Rollback()
+	})
+
+	r1, r2 := RID{Page: 0, Slot: 1}, RID{Page: 0, Slot: 2}
+
+	// share locks are compatible
+	if err := tx1.lockRow("t", r1, lockShare); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx2.lockRow("t", r1, lockShare); err != nil {
+		t.Fatal(err)
+	}
+
+	tx3.lockTimeout = 50 * time.Millisecond
+	if err := tx3.lockRow("t", r1, lockExclusive); err != errLockTimeout {
+		t.Fatalf("exclusive lock on the shared row must wait: %v", err)
+	}
+
+	// tx1 waits for tx2 to upgrade its lock, and tx2 waiting for tx1 closes the cycle
+	if err := tx2.lockRow("t", r2, lockExclusive); err != nil {
+		t.Fatal(err)
+	}
+
+	locked := make(chan error)
+	go func() { locked <- tx1.lockRow("t", r2, lockShare) }()
+	time.Sleep(50 * time.Millisecond)
+
+	if err := tx2.lockRow("t", r1, lockExclusive); !errors.Is(err, errDeadlock) {
+		t.Fatalf("deadlock must be detected: %v", err)
+	}
+
+	tx2.Rollback()
+	if err := <-locked; err != nil {
+		t.Fatalf("lock must be taken after the holder ends: %v", err)
+	}
+
+	// the lock released by the holder can be taken
+	tx1.Rollback()
+	tx3.lockTimeout = 0
+	if err := tx3.lockRow("t", r1, lockExclusive); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -42,6 +42,12 @@ func (l *LRU) Put(key string, r *Record) {
 	l.m.Lock()
 	defer l.m.Unlock()
 
+	if e, ok := l.elem[key]; ok {
+		e.Value.(*element).value = r
+		l.list.MoveToFront(e)
+		return
+	}
+
 	e := &element{key: key, value: r}
 	l.list.PushFront(e)
 	l.elem[key] = l.list.Front()
@@ -52,6 +58,16 @@ func (l *LRU) Put(key string, r *Record) {
 	}
 }
 
+func (l *LRU) Remove(key string) {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	if e, ok := l.elem[key]; ok {
+		delete(l.elem, key)
+		l.list.Remove(e)
+	}
+}
+
 func (l *LRU) Keys() []string {
 	l.m.Lock()
 	defer l.m.Unlock()
@@ -13,27 +13,36 @@ func TestLRU(t *testing.T) {
 		}
 	}
 
-	lru.Put("1", &Record{Vals: []string{"1"}})
+	lru.Put("1", &Record{Vals: []any{"1"}})
 	assert(t, []string{"1"})
 
-	lru.Put("2", &Record{Vals: []string{"2"}})
+	lru.Put("2", &Record{Vals: []any{"2"}})
 	assert(t, []string{"2", "1"})
 
-	lru.Put("3", &Record{Vals: []string{"3"}})
+	lru.Put("3", &Record{Vals: []any{"3"}})
 	assert(t, []string{"3", "2", "1"})
 
-	lru.Put("4", &Record{Vals: []string{"4"}})
+	lru.Put("4", &Record{Vals: []any{"4"}})
 	assert(t, []string{"4", "3", "2"})
 
 	lru.Get("2")
 	assert(t, []string{"2", "4", "3"})
 
-	lru.Put("5", &Record{Vals: []string{"5"}})
+	lru.Put("5", &Record{Vals: []any{"5"}})
 	assert(t, []string{"5", "2", "4"})
 
 	got, _ := lru.Get("2")
 	assert(t, []string{"2", "5", "4"})
-	if !reflect.DeepEqual(got, &Record{Vals: []string{"2"}}) {
+	if !reflect.DeepEqual(got, &Record{Vals: []any{"2"}}) {
+		t.Fatalf("unexpected lru: got: %v", got)
+	}
+
+	lru.Put("4", &Record{Vals: []any{"44"}})
+	assert(t, []string{"4", "2", "5"})
+
+	got, _ = lru.Get("4")
+	assert(t, []string{"4", "2", "5"})
+	if !reflect.DeepEqual(got, &Record{Vals: []any{"44"}}) {
 		t.Fatalf("unexpected lru: got: %v", got)
 	}
 }
@@ -1,12 +1,33 @@
 package main
 
 import (
+	"fmt"
 	"net/http"
+	"os"
 )
 
 func main() {
+	if err := migrateWalFile(); err != nil {
+		fmt.Fprintf(os.Stderr, "could not migrate legacy WAL file: %s\n", err)
+		os.Exit(1)
+	}
+
+	if err := migrateTablespace(); err != nil {
+		fmt.Fprintf(os.Stderr, "could not migrate legacy tablespace file: %s\n", err)
+		os.Exit(1)
+	}
+
+	if err := recoverFromWal(); err != nil {
+		fmt.Fprintf(os.Stderr, "could not recover from WAL: %s\n", err)
+		os.Exit(1)
+	}
+
+	go runCheckpointer(checkpointInterval)
+	go runVacuumer(vacuumInterval)
+
 	mux := http.NewServeMux()
 	mux.HandleFunc("/query", postQuery)
+	mux.HandleFunc("/stats", getStats)
 
 	s := http.Server{
 		Addr:    ":2134",
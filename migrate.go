@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Before the heap files are introduced, every table is stored in a single JSON file:
+// {
+//   "LSN": 3,
+//   "Tables": {
+//     "tbl1": [
+//       {"col1": "val1", "col2": "val2", "col3": "val3"},
+//       {"col1": "val4", "col2": "val5", "col3": "val6"},
+//     ],
+//     "tbl2": [
+//       {"col4": "val1", "col5": "val2", "col6": "val3"},
+//     ]
+//   }
+// }
+//
+// The file written before WAL is introduced has the tables at the top level without LSN (see UnmarshalJSON).
+// The file is migrated into the heap files on launch, then renamed to "<datafile>.migrated".
+
+var datafile = "data/incdb.data"
+
+func init() {
+	// test mode
+	if os.Getenv("INCDB_TEST") == "1" {
+		datafile = "data/test.incdb.data"
+	}
+}
+
+type legacyTablespace struct {
+	LSN    uint64
+	Tables map[string][]map[string]string
+}
+
+// UnmarshalJSON decodes the tablespace file in either format. Before WAL is introduced, the file is the tables
+// at the top level without LSN (e.g. {"tbl1": [{"col1": "val1"}]}), which is taken as LSN 0.
+// The formats are told apart by the value of "Tables", which is an object only in the newer one.
+func (ts *legacyTablespace) UnmarshalJSON(b []byte) error {
+	top := map[string]json.RawMessage{}
+	if err := json.Unmarshal(b, &top); err != nil {
+		return err
+	}
+
+	if v, ok := top["Tables"]; ok && strings.HasPrefix(strings.TrimSpace(string(v)), "{") {
+		type tablespace legacyTablespace // without UnmarshalJSON
+		return json.Unmarshal(b, (*tablespace)(ts))
+	}
+
+	ts.LSN = 0
+	ts.Tables = map[string][]map[string]string{}
+	return json.Unmarshal(b, &ts.Tables)
+}
+
+// migrateTablespace moves the records in the JSON tablespace file into the heap files.
+// Nothing is done if the JSON file does not exist.
+// If the process dies in the middle, the migration is done again from scratch on the next launch,
+// because the JSON file and WAL are removed only after every heap file and the control file are written.
+func migrateTablespace() error {
+	f, err := os.Open(datafile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("open legacy tablespace file: %w", err)
+	}
+	defer f.Close()
+
+	ts := legacyTablespace{}
+	if err := readJsonFile(f, &ts); err != nil {
+		return fmt.Errorf("read legacy tablespace file: %w", err)
+	}
+
+	if ts.Tables == nil {
+		ts.Tables = map[string][]map[string]string{}
+	}
+
+	ctl, err := readControl()
+	if err != nil {
+		return fmt.Errorf("read control file: %w", err)
+	}
+
+	// redo the WAL entries not yet applied to the JSON file
+	entries, err := readWal(max(ts.LSN, ctl.CheckpointLSN))
+	if err != nil {
+		return fmt.Errorf("read WAL: %w", err)
+	}
+
+	lsn := max(ts.LSN, ctl.CheckpointLSN)
+	for _, e := range entries {
+		lsn = e.LSN
+		switch e.Op {
+		case WalCreate:
+			// catalog was updated before the create entry is appended
+			ts.Tables[e.Table] = []map[string]string{}
+
+		case WalInsert:
+			ts.Tables[e.Table] = append(ts.Tables[e.Table], e.Row)
+		}
+	}
+
+	for tbl, rows := range ts.Tables {
+		if err := migrateTable(tbl, rows); err != nil {
+			return fmt.Errorf("migrate table %s: %w", tbl, err)
+		}
+	}
+
+	if err := replaceJsonFile(ctlfile, &Control{CheckpointLSN: lsn}); err != nil {
+		return fmt.Errorf("record checkpoint LSN: %w", err)
+	}
+
+	if err := os.Rename(datafile, datafile+".migrated"); err != nil {
+		return fmt.Errorf("rename legacy tablespace file: %w", err)
+	}
+
+	// WAL entries are no longer needed because the checkpoint LSN covers them.
+	lastLSN = lsn
+	if _, err := removeWalSegments(lsn); err != nil {
+		return fmt.Errorf("remove WAL segments: %w", err)
+	}
+
+	Debug("migrated legacy tablespace file into heap files, tables: ", len(ts.Tables))
+	return nil
+}
+
+func migrateTable(tbl string, rows []map[string]string) error {
+	tDef, err := readCatalog(tbl)
+	if err != nil {
+		return fmt.Errorf("read catalog: %w", err)
+	}
+
+	// discard the heap file written by the previous migration which did not finish
+	if err := os.Remove(heapPath(tbl)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("remove heap file: %w", err)
+	}
+
+	if err := createHeap(tbl); err != nil {
+		return err
+	}
+
+	n := uint32(0)
+	p := NewPage()
+	for _, row := range rows {
+		// every column was string in the legacy tablespace
+		vals := make([]any, len(tDef.Cols))
+		for i, col := range tDef.Cols {
+			vals[i] = row[col.Name]
+		}
+
+		t, err := encodeTuple(vals)
+		if err != nil {
+			return fmt.Errorf("encode record: %w", err)
+		}
+		t = makeHeapTuple(Version{}, t) // visible to every transaction
+
+		if len(t) > maxTupleSize {
+			return fmt.Errorf("record is too large: %d bytes", len(t))
+		}
+
+		if len(t) > p.FreeSpace() {
+			if err := writePage(tbl, n, p); err != nil {
+				return err
+			}
+			n++
+			p = NewPage()
+		}
+
+		if err := p.Put(p.NextSlot(), t); err != nil {
+			return err
+		}
+	}
+
+	if p.Slots() > 0 {
+		if err := writePage(tbl, n, p); err != nil {
+			return err
+		}
+	}
+
+	return syncHeap(tbl)
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Page is a fixed-size block in a heap file. Records are stored as tuples in slotted page format:
+//
+//	+----------+------------+----------+--------+--------+-----+
+//	| LSN (8B) | slots (2B) | end (2B) | slot 0 | slot 1 | ... |
+//	+----------+------------+----------+--------+--------+-----+
+//	|                 free space ->     <- tuple 1 | tuple 0    |
+//	+-----------------------------------------------------------+
+//
+// LSN is the LSN of the last WAL entry applied on the page. slots is the number of slots and
+// end is the offset where the tuple area begins. Each slot holds the offset (2B) and the length (2B)
+// of its tuple, and the length 0 means the slot is free.
+type Page []byte
+
+const (
+	pageSize       = 4096
+	pageHeaderSize = 12
+	slotSize       = 4
+
+	// maxTupleSize is the size of the largest tuple which can be stored in an empty page.
+	maxTupleSize = pageSize - pageHeaderSize - slotSize
+)
+
+func NewPage() Page {
+	p := make(Page, pageSize)
+	p.setEnd(pageSize)
+	return p
+}
+
+func (p Page) LSN() uint64 {
+	return binary.LittleEndian.Uint64(p[0:8])
+}
+
+func (p Page) SetLSN(lsn uint64) {
+	binary.LittleEndian.PutUint64(p[0:8], lsn)
+}
+
+func (p Page) Slots() int {
+	return int(binary.LittleEndian.Uint16(p[8:10]))
+}
+
+func (p Page) setSlots(n int) {
+	binary.LittleEndian.PutUint16(p[8:10], uint16(n))
+}
+
+func (p Page) end() int {
+	end := int(binary.LittleEndian.Uint16(p[10:12]))
+	if end == 0 {
+		return pageSize // pageSize does not fit in 2 bytes
+	}
+	return end
+}
+
+func (p Page) setEnd(end int) {
+	binary.LittleEndian.PutUint16(p[10:12], uint16(end%pageSize))
+}
+
+func (p Page) slot(i int) (offset, length int) {
+	s := pageHeaderSize + i*slotSize
+	return int(binary.LittleEndian.Uint16(p[s : s+2])), int(binary.LittleEndian.Uint16(p[s+2 : s+4]))
+}
+
+func (p Page) setSlot(i, offset, length int) {
+	s := pageHeaderSize + i*slotSize
+	binary.LittleEndian.PutUint16(p[s:s+2], uint16(offset))
+	binary.LittleEndian.PutUint16(p[s+2:s+4], uint16(length))
+}
+
+// Tuple returns the tuple in the slot. nil is returned if the slot is free.
+func (p Page) Tuple(i int) []byte {
+	if i >= p.Slots() {
+		return nil
+	}
+
+	offset, length := p.slot(i)
+	if length == 0 {
+		return nil
+	}
+
+	return p[offset : offset+length]
+}
+
+// NextSlot returns the slot where the next tuple is put in.
+func (p Page) NextSlot() int {
+	for i := 0; i < p.Slots(); i++ {
+		if _, length := p.slot(i); length == 0 {
+			return i
+		}
+	}
+	return p.Slots()
+}
+
+// FreeSpace returns the size of the largest tuple which can be put in the page.
+func (p Page) FreeSpace() int {
+	free := p.end() - pageHeaderSize - p.Slots()*slotSize
+	if p.NextSlot() == p.Slots() {
+		free -= slotSize // a new slot is needed
+	}
+	return max(free, 0)
+}
+
+// Put puts the tuple in the free slot.
+func (p Page) Put(i int, tuple []byte) error {
+	if len(tuple) == 0 {
+		return fmt.Errorf("empty tuple")
+	}
+
+	if i > p.Slots() {
+		return fmt.Errorf("slot %d is out of range", i)
+	}
+
+	if p.Tuple(i) != nil {
+		return fmt.Errorf("slot %d is in use", i)
+	}
+
+	need := len(tuple)
+	if i == p.Slots() {
+		need += slotSize
+	}
+
+	if need > p.end()-pageHeaderSize-p.Slots()*slotSize {
+		return fmt.Errorf("no space for the tuple in the page")
+	}
+
+	offset := p.end() - len(tuple)
+	copy(p[offset:], tuple)
+	p.setEnd(offset)
+	p.setSlot(i, offset, len(tuple))
+	if i == p.Slots() {
+		p.setSlots(i + 1)
+	}
+
+	return nil
+}
+
+// Delete frees the slot and compacts the tuple area so that the space can be reused.
+// The other tuples are moved but their slots stay the same.
+func (p Page) Delete(i int) error {
+	if p.Tuple(i) == nil {
+		return fmt.Errorf("slot %d is not in use", i)
+	}
+
+	offset, length := p.slot(i)
+	end := p.end()
+
+	// shift the tuples placed before the deleted one toward the end of the page
+	copy(p[end+length:offset+length], p[end:offset])
+	for j := 0; j < p.Slots(); j++ {
+		if o, l := p.slot(j); l != 0 && o < offset {
+			p.setSlot(j, o+length, l)
+		}
+	}
+	p.setEnd(end + length)
+	p.setSlot(i, 0, 0)
+
+	return nil
+}
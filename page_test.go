@@ -0,0 +1,111 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPage(t *testing.T) {
+	p := NewPage()
+	if p.FreeSpace() != maxTupleSize {
+		t.Fatalf("unexpected free space of empty page: %d", p.FreeSpace())
+	}
+
+	t1, err := encodeTuple([]any{int64(1), "laptop"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t2, err := encodeTuple([]any{int64(2), ""})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.Put(p.NextSlot(), t1); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Put(p.NextSlot(), t2); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.Put(0, t2); err == nil {
+		t.Fatalf("slot in use must not be overwritten")
+	}
+
+	if p.Slots() != 2 || p.NextSlot() != 2 {
+		t.Fatalf("unexpected slots: %d, next: %d", p.Slots(), p.NextSlot())
+	}
+
+	if free := maxTupleSize - len(t1) - len(t2) - 2*slotSize; p.FreeSpace() != free {
+		t.Fatalf("unexpected free space: got: %d, expected: %d", p.FreeSpace(), free)
+	}
+
+	for i, expected := range [][]any{{int64(1), "laptop"}, {int64(2), ""}} {
+		vals, err := decodeTuple(p.Tuple(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(vals, expected) {
+			t.Fatalf("unexpected tuple in slot %d: got: %v, expected: %v", i, vals, expected)
+		}
+	}
+
+	if p.Tuple(2) != nil {
+		t.Fatalf("tuple must not be in the slot out of range")
+	}
+
+	if err := p.Put(p.NextSlot(), make([]byte, p.FreeSpace()+1)); err == nil {
+		t.Fatalf("tuple larger than free space must not be put")
+	}
+
+	if err := p.Put(p.NextSlot(), make([]byte, p.FreeSpace())); err != nil {
+		t.Fatalf("tuple fits in the free space must be put: %v", err)
+	}
+
+	if p.FreeSpace() != 0 {
+		t.Fatalf("page must be full: %d", p.FreeSpace())
+	}
+}
+
+func TestPageDelete(t *testing.T) {
+	p := NewPage()
+	tuples := [][]byte{}
+	for _, vals := range [][]any{{int64(1), "laptop"}, {int64(2), "iPhone"}, {int64(3), "radio"}} {
+		tuple, err := encodeTuple(vals)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := p.Put(p.NextSlot(), tuple); err != nil {
+			t.Fatal(err)
+		}
+		tuples = append(tuples, tuple)
+	}
+
+	free := p.FreeSpace()
+	if err := p.Delete(1); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.Delete(1); err == nil {
+		t.Fatalf("free slot must not be deleted")
+	}
+
+	// the slot is reused, so no new slot is needed
+	if expected := free + len(tuples[1]) + slotSize; p.FreeSpace() != expected {
+		t.Fatalf("unexpected free space: got: %d, expected: %d", p.FreeSpace(), expected)
+	}
+
+	if p.NextSlot() != 1 || p.Tuple(1) != nil {
+		t.Fatalf("deleted slot must be free: next: %d", p.NextSlot())
+	}
+
+	// the other tuples are kept after the compaction
+	for _, i := range []int{0, 2} {
+		if !reflect.DeepEqual(p.Tuple(i), tuples[i]) {
+			t.Fatalf("unexpected tuple in slot %d: got: %v, expected: %v", i, p.Tuple(i), tuples[i])
+		}
+	}
+
+	if err := p.Put(p.NextSlot(), make([]byte, p.FreeSpace())); err != nil {
+		t.Fatalf("tuple fits in the free space must be put: %v", err)
+	}
+}
@@ -2,11 +2,17 @@ package main
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
+	"sync"
 )
 
 // tk is a global token which is "currently" focused on.
-// This is globally declared for better parser readability.
-var tk *Token
+// This is globally declared for better parser readability, so the queries are parsed one by one under parseMu.
+var (
+	tk      *Token
+	parseMu sync.Mutex
+)
 
 func parse(query string) (queryStmt *QueryStmt, err error) {
 	// For better readability, use panic/recover to get back here from deep-nested parser on error.
@@ -17,8 +23,14 @@ func parse(query string) (queryStmt *QueryStmt, err error) {
 		}
 	}()
 
-	tk = tokenize(query)
-	Debug("tokens: ", tk)
+	// the tokens are logged before locking, so that a long query does not block the others while being formatted
+	tokens := tokenize(query)
+	Debug("tokens: ", tokens)
+
+	parseMu.Lock()
+	defer parseMu.Unlock()
+
+	tk = tokens
 
 	if _, ok := consume(TkSelect); ok {
 		return parseSelect(), nil
@@ -28,97 +40,494 @@ func parse(query string) (queryStmt *QueryStmt, err error) {
 		return parseInsert(), nil
 	}
 
+	if _, ok := consume(TkUpdate); ok {
+		return parseUpdate(), nil
+	}
+
+	if _, ok := consume(TkDelete); ok {
+		return parseDelete(), nil
+	}
+
 	if _, ok := consume(TkCreate); ok {
+		if _, ok := consume(TkUnique); ok {
+			mustConsume(TkIndex)
+			return parseCreateIndex(true), nil
+		}
+
+		if _, ok := consume(TkIndex); ok {
+			return parseCreateIndex(false), nil
+		}
+
 		return parseCreate(), nil
 	}
 
+	if _, ok := consume(TkDrop); ok {
+		return parseDrop(), nil
+	}
+
+	if _, ok := consume(TkAlter); ok {
+		return parseAlter(), nil
+	}
+
+	if _, ok := consume(TkCheckpoint); ok {
+		return &QueryStmt{Checkpoint: &Checkpoint{}}, nil
+	}
+
+	if _, ok := consume(TkVacuum); ok {
+		return parseVacuum(), nil
+	}
+
+	if _, ok := consume(TkBegin); ok {
+		return &QueryStmt{Begin: &Begin{}}, nil
+	}
+
+	if _, ok := consume(TkCommit); ok {
+		return &QueryStmt{Commit: &Commit{}}, nil
+	}
+
+	if _, ok := consume(TkRollback); ok {
+		return parseRollback(), nil
+	}
+
+	if _, ok := consume(TkSavepoint); ok {
+		return &QueryStmt{Savepoint: &Savepoint{Name: parseName()}}, nil
+	}
+
+	if _, ok := consume(TkRelease); ok {
+		consume(TkSavepoint)
+		return &QueryStmt{Release: &Release{Savepoint: parseName()}}, nil
+	}
+
+	if _, ok := consume(TkSet); ok {
+		return parseSet(), nil
+	}
+
 	return nil, fmt.Errorf("unknown token type: %v", tk.Type)
 }
 
-// select = "select" ("*" | columns) "from" table_name where_clause order_clause limit_clause
+// select = "select" "distinct"? ("*" | select_item ("," select_item)*) "from" (table_name | "(" select ")") alias_clause
+//
+//	join_clause* where_clause group_clause having_clause order_clause limit_clause locking_clause
+//
+// select_item = (aggregate | column_name) alias_clause
 func parseSelect() *QueryStmt {
 	q := &QueryStmt{Select: &Select{}}
 
+	if _, ok := consume(TkDistinct); ok {
+		q.Select.Distinct = true
+	}
+
 	if _, ok := consume(TkStar); ok {
-		q.Select.Columns = []string{"*"}
+		q.Select.Items = []*SelectItem{{Column: "*"}}
 	} else {
 		i := 1
-		cols := []string{}
+		items := []*SelectItem{}
 		for {
 			if i > 100 {
 				panic("number of columns must be less than 100")
 			}
 
 			s := mustConsume(TkSymbol)
-			cols = append(cols, s)
+			item := &SelectItem{Column: s}
+			if agg := parseAggregate(s); agg != nil {
+				item = &SelectItem{Aggregate: agg}
+			}
+			item.Alias = parseAliasClause()
+			items = append(items, item)
 
 			if _, ok := consume(TkComma); !ok {
 				break
 			}
 			i++
 		}
-		q.Select.Columns = cols
+		q.Select.Items = items
 	}
 
 	mustConsume(TkFrom)
-	q.Select.Table = parseTableNameClause()
+	if _, ok := consume(TkLParen); ok {
+		q.Select.Subquery = parseSubquery()
+		if q.Select.Alias = parseAliasClause(); q.Select.Alias == "" {
+			panic("subquery in from must have an alias")
+		}
+	} else {
+		q.Select.Table = parseTableNameClause()
+		q.Select.Alias = parseAliasClause()
+	}
+	q.Select.Joins = parseJoinClauses()
+
+	// the tables must be distinguishable to qualify the columns
+	aliases := map[string]bool{tableAlias(q.Select.Table, q.Select.Alias): true}
+	for _, j := range q.Select.Joins {
+		alias := tableAlias(j.Table, j.Alias)
+		if aliases[alias] {
+			panic(fmt.Sprintf("table name '%s' is specified more than once", alias))
+		}
+		aliases[alias] = true
+	}
 	q.Select.Where = parseWhereClause()
+	q.Select.GroupBy = parseGroupClause()
+	q.Select.Having = parseHavingClause()
 	q.Select.Order = parseOrderClause()
 	q.Select.Limit, q.Select.Offset = parseLimitOffsetClause()
+	q.Select.Lock = parseLockingClause()
+
+	// the locked records must be the ones in the table
+	if q.Select.Lock != "" {
+		switch {
+		case grouped(q.Select):
+			panic(fmt.Sprintf("for %s is not allowed with group by clause or aggregate functions", q.Select.Lock))
+		case q.Select.Distinct:
+			panic(fmt.Sprintf("for %s is not allowed with distinct clause", q.Select.Lock))
+		case q.Select.Subquery != nil:
+			panic(fmt.Sprintf("for %s is not allowed with subquery in from", q.Select.Lock))
+		case len(q.Select.Joins) != 0:
+			panic(fmt.Sprintf("for %s is not allowed with joins", q.Select.Lock))
+		}
+	}
 
 	return q
 }
 
+// aggFuncs is the aggregate functions. They are not keywords, so a column can be named after them.
+var aggFuncs = map[string]AggFunc{
+	"count": AggCount,
+	"sum":   AggSum,
+	"avg":   AggAvg,
+	"min":   AggMin,
+	"max":   AggMax,
+}
+
+// aggregate = agg_func "(" (column_name | "*") ")"
+// The function name is consumed as the symbol s. nil is returned if s is not followed by "(" or not an aggregate function.
+func parseAggregate(s string) *Aggregate {
+	fn, ok := aggFuncs[strings.ToLower(s)]
+	if !ok || tk.Type != TkLParen {
+		return nil
+	}
+
+	mustConsume(TkLParen)
+	agg := &Aggregate{Func: fn}
+	if _, ok := consume(TkStar); ok {
+		if fn != AggCount {
+			panic(fmt.Sprintf("%s(*) is not allowed", fn))
+		}
+		agg.Column = "*"
+	} else {
+		agg.Column = mustConsume(TkSymbol)
+	}
+	mustConsume(TkRParen)
+
+	return agg
+}
+
+// group_clause = ("group" "by" column_name ("," column_name)*)?
+func parseGroupClause() []string {
+	if _, ok := consume(TkGroup); !ok {
+		return nil
+	}
+
+	mustConsume(TkBy)
+	cols := []string{}
+	for {
+		cols = append(cols, mustConsume(TkSymbol))
+		if _, ok := consume(TkComma); !ok {
+			return cols
+		}
+	}
+}
+
+// having_clause = ("having" expr)?
+func parseHavingClause() *Expr {
+	if _, ok := consume(TkHaving); !ok {
+		return nil
+	}
+
+	return parseExpr(0)
+}
+
+// subquery = select ")"
+// The opening parenthesis must be consumed by the caller to tell the subquery from other expressions.
+func parseSubquery() *Select {
+	mustConsume(TkSelect)
+	q := parseSelect()
+	mustConsume(TkRParen)
+
+	// the locks are never waited for while the outer statement is changing the records
+	if q.Select.Lock != "" {
+		panic(fmt.Sprintf("for %s is not allowed in subqueries", q.Select.Lock))
+	}
+	return q.Select
+}
+
 // table_name = symbol
 func parseTableNameClause() string {
-	return mustConsume(TkSymbol)
+	return parseName()
 }
 
-// where_clause = "where" column_name ("=" | "!=") str
-func parseWhereClause() *Where {
+// alias_clause = ("as"? symbol)?
+func parseAliasClause() string {
+	if _, ok := consume(TkAs); ok {
+		return parseName()
+	}
+
+	if tk.Type == TkSymbol {
+		return parseName()
+	}
+
+	return ""
+}
+
+// join_clause = ("inner"? "join" | "left" "outer"? "join") table_name alias_clause "on" expr
+//
+//	| "cross" "join" table_name alias_clause
+func parseJoinClauses() []*Join {
+	joins := []*Join{}
+	for {
+		j := &Join{}
+		if _, ok := consume(TkCross); ok {
+			j.Type = JoinCross
+		} else if _, ok := consume(TkLeft); ok {
+			consume(TkOuter)
+			j.Type = JoinLeft
+		} else if _, ok := consume(TkInner); ok {
+			j.Type = JoinInner
+		} else if tk.Type == TkJoin {
+			j.Type = JoinInner
+		} else {
+			return joins
+		}
+
+		mustConsume(TkJoin)
+		j.Table = parseTableNameClause()
+		j.Alias = parseAliasClause()
+
+		if j.Type != JoinCross {
+			mustConsume(TkOn)
+			j.On = parseExpr(0)
+		}
+
+		joins = append(joins, j)
+	}
+}
+
+// parseName consumes the name of a table or a column, which cannot be qualified.
+func parseName() string {
+	s := mustConsume(TkSymbol)
+	if strings.Contains(s, ".") {
+		panic(fmt.Sprintf("invalid name '%s'", s))
+	}
+	return s
+}
+
+// where_clause = ("where" expr)?
+func parseWhereClause() *Expr {
 	if _, ok := consume(TkWhere); !ok {
 		return nil
 	}
 
-	w := &Where{}
-	col := mustConsume(TkSymbol)
+	return parseExpr(0)
+}
 
-	eq := true
-	if _, ok := consume(TkEqual); ok {
-		w.Equal = &Binary{Column: col}
-	} else if _, ok := consume(TkNotEqual); ok {
-		w.NotEqual = &Binary{Column: col}
-		eq = false
-	} else {
-		panic("= or != must be spceified in where clause")
+// binaryPrecedence is the precedence of the binary operators. Larger one binds tighter.
+var binaryPrecedence = map[TkType]int{
+	TkOr:        1,
+	TkAnd:       2,
+	TkEqual:     comparePrecedence,
+	TkNotEqual:  comparePrecedence,
+	TkLess:      comparePrecedence,
+	TkLessEqual: comparePrecedence,
+	TkGreater:   comparePrecedence,
+	TkGreaterEq: comparePrecedence,
+}
+
+const (
+	// notPrecedence is the precedence of "not" which binds tighter than "and" but looser than comparisons.
+	notPrecedence = 3
+	// comparePrecedence is the precedence of comparisons and the predicates
+	// ("is [not] null", "[not] like", "[not] in", "[not] between").
+	comparePrecedence = 4
+)
+
+// expr = unary_expr ((binary_op expr) | predicate)*
+// binary_op = "or" | "and" | "=" | "!=" | "<" | "<=" | ">" | ">="
+// This is parsed by precedence climbing. Only the operators whose precedence is not less than minPrec are consumed.
+func parseExpr(minPrec int) *Expr {
+	lhs := parseUnaryExpr()
+
+	for {
+		if comparePrecedence >= minPrec {
+			if e := parsePredicate(lhs); e != nil {
+				lhs = e
+				continue
+			}
+		}
+
+		prec, ok := binaryPrecedence[tk.Type]
+		if !ok || prec < minPrec {
+			return lhs
+		}
+
+		op := tk.Type
+		mustConsume(op)
+
+		// operators are left associative, so the right side must bind tighter
+		rhs := parseExpr(prec + 1)
+
+		if op == TkAnd || op == TkOr {
+			lhs = &Expr{Logical: &Logical{Op: op, Left: lhs, Right: rhs}}
+		} else {
+			lhs = &Expr{Binary: &Binary{Op: op, Left: lhs, Right: rhs}}
+		}
 	}
+}
 
-	if eq {
-		w.Equal.Value = mustConsume(TkStr)
-	} else {
-		w.NotEqual.Value = mustConsume(TkStr)
+// predicate = "is" "not"? "null"
+//
+//	| "not"? "like" expr
+//	| "not"? "in" ("(" expr ("," expr)* ")" | "(" subquery)
+//	| "not"? "between" expr "and" expr
+//
+// nil is returned if the current token does not begin a predicate.
+func parsePredicate(lhs *Expr) *Expr {
+	if _, ok := consume(TkIs); ok {
+		op := OpIsNull
+		if _, ok := consume(TkNot); ok {
+			op = OpIsNotNull
+		}
+		mustConsume(TkNull)
+		return &Expr{Unary: &Unary{Op: op, Operand: lhs}}
+	}
+
+	not := false
+	if tk.Type == TkNot && tk.Next != nil && (tk.Next.Type == TkLike || tk.Next.Type == TkIn || tk.Next.Type == TkBetween) {
+		mustConsume(TkNot)
+		not = true
+	}
+
+	var e *Expr
+	switch {
+	case tk.Type == TkLike:
+		mustConsume(TkLike)
+		e = &Expr{Binary: &Binary{Op: TkLike, Left: lhs, Right: parseExpr(comparePrecedence + 1)}}
+
+	case tk.Type == TkIn:
+		mustConsume(TkIn)
+		mustConsume(TkLParen)
+		in := &In{Operand: lhs}
+		if tk.Type == TkSelect {
+			in.Subquery = parseSubquery()
+		} else {
+			for {
+				in.List = append(in.List, parseExpr(0))
+				if _, ok := consume(TkComma); !ok {
+					break
+				}
+			}
+			mustConsume(TkRParen)
+		}
+		e = &Expr{In: in}
+
+	case tk.Type == TkBetween:
+		mustConsume(TkBetween)
+		// the bounds must bind tighter than "and" so that it is not taken as logical "and"
+		low := parseExpr(comparePrecedence + 1)
+		mustConsume(TkAnd)
+		high := parseExpr(comparePrecedence + 1)
+		e = &Expr{Between: &Between{Operand: lhs, Low: low, High: high}}
+
+	default:
+		return nil
+	}
+
+	if not {
+		return &Expr{Unary: &Unary{Op: OpNot, Operand: e}}
+	}
+	return e
+}
+
+// unary_expr = "not" expr | primary_expr
+func parseUnaryExpr() *Expr {
+	if _, ok := consume(TkNot); ok {
+		return &Expr{Unary: &Unary{Op: OpNot, Operand: parseExpr(notPrecedence)}}
+	}
+
+	return parsePrimaryExpr()
+}
+
+// primary_expr = "(" expr ")" | "(" subquery | "exists" "(" subquery | aggregate | column_name | nullable_literal
+func parsePrimaryExpr() *Expr {
+	if _, ok := consume(TkExists); ok {
+		mustConsume(TkLParen)
+		return &Expr{Exists: parseSubquery()}
+	}
+
+	if _, ok := consume(TkLParen); ok {
+		if tk.Type == TkSelect {
+			return &Expr{Subquery: parseSubquery()}
+		}
+
+		e := parseExpr(0)
+		mustConsume(TkRParen)
+		return e
+	}
+
+	if s, ok := consume(TkSymbol); ok {
+		if agg := parseAggregate(s); agg != nil {
+			return &Expr{Aggregate: agg}
+		}
+		return &Expr{Column: s}
+	}
+
+	if _, ok := consume(TkNull); ok {
+		return &Expr{Literal: &Literal{Kind: LitNull}}
 	}
 
-	return w
+	return &Expr{Literal: parseLiteral()}
 }
 
-// order_clause = ("order" "by" column_name ("asc" | "desc")?)?
-func parseOrderClause() *Order {
+// order_clause = ("order" "by" order_key ("," order_key)*)?
+// order_key = (column_name | num) ("asc" | "desc")? ("nulls" ("first" | "last"))?
+func parseOrderClause() []*Order {
 	if _, ok := consume(TkOrder); !ok {
 		return nil
 	}
 
 	mustConsume(TkBy)
-	col := mustConsume(TkSymbol)
-	o := &Order{Column: col, Dir: "asc"} // default asc
+	odrs := []*Order{}
+	for {
+		o := &Order{Dir: "asc"} // default asc
+		if tk.Type == TkInt {
+			if o.Position = mustConsumeInt(); o.Position < 1 {
+				panic(fmt.Sprintf("order by position %d is not in select list", o.Position))
+			}
+		} else {
+			o.Column = mustConsume(TkSymbol)
+		}
 
-	if _, ok := consume(TkAsc); ok {
-		return o
-	} else if _, ok := consume(TkDesc); ok {
-		return &Order{Column: col, Dir: "desc"}
-	}
+		if _, ok := consume(TkDesc); ok {
+			o.Dir = "desc"
+		} else {
+			consume(TkAsc)
+		}
+
+		// NULL is larger than any other value by default
+		o.NullsFirst = o.Dir == "desc"
+		if _, ok := consume(TkNulls); ok {
+			switch s := mustConsume(TkSymbol); strings.ToLower(s) {
+			case "first":
+				o.NullsFirst = true
+			case "last":
+				o.NullsFirst = false
+			default:
+				panic(fmt.Sprintf("unexpected '%s' after nulls, expected first or last", s))
+			}
+		}
 
-	return o
+		odrs = append(odrs, o)
+		if _, ok := consume(TkComma); !ok {
+			return odrs
+		}
+	}
 }
 
 // limit_clause = ("limit" num | "offset" num | "limit" num "offset" num | "offset" num "limit" num)?
@@ -167,7 +576,23 @@ func parseLimitOffsetClause() (*Limit, *Offset) {
 	return nil, nil
 }
 
-// "insert" "into" table_name_clause cols? "values" values
+// locking_clause = ("for" ("update" | "share"))?
+func parseLockingClause() string {
+	if _, ok := consume(TkFor); !ok {
+		return ""
+	}
+
+	if _, ok := consume(TkUpdate); ok {
+		return "update"
+	}
+
+	if s, ok := consume(TkSymbol); ok && strings.ToLower(s) == "share" {
+		return "share"
+	}
+	panic("update or share is expected after for")
+}
+
+// "insert" "into" table_name_clause cols? ("values" values ("," values)* | select)
 func parseInsert() *QueryStmt {
 	q := &QueryStmt{Insert: &Insert{}}
 	mustConsume(TkInto)
@@ -176,9 +601,20 @@ func parseInsert() *QueryStmt {
 
 	q.Insert.Cols = parseCols()
 
+	if _, ok := consume(TkSelect); ok {
+		q.Insert.Select = parseSelect().Select
+		return q
+	}
+
 	mustConsume(TkValues)
 
-	q.Insert.Vals = parseValues()
+	for {
+		q.Insert.Rows = append(q.Insert.Rows, parseValues())
+
+		if _, ok := consume(TkComma); !ok {
+			break
+		}
+	}
 
 	return q
 }
@@ -197,7 +633,7 @@ func parseCols() []string {
 			panic("cols must be less than 100")
 		}
 
-		s := mustConsume(TkSymbol)
+		s := parseName()
 		ret = append(ret, s)
 
 		if _, ok := consume(TkRParen); ok {
@@ -211,10 +647,10 @@ func parseCols() []string {
 	return ret
 }
 
-// values = "(" '"' val1 '"' "," '"' val2 '"' "," ... ")"
-func parseValues() []string {
+// values = "(" (literal | "null") "," (literal | "null") "," ... ")"
+func parseValues() []*string {
 	i := 1
-	ret := []string{}
+	ret := []*string{}
 
 	mustConsume(TkLParen)
 	for {
@@ -222,8 +658,7 @@ func parseValues() []string {
 			panic("cols must be less than 100")
 		}
 
-		s := mustConsume(TkStr)
-		ret = append(ret, s)
+		ret = append(ret, parseNullableLiteral())
 
 		if _, ok := consume(TkRParen); ok {
 			break
@@ -236,12 +671,96 @@ func parseValues() []string {
 	return ret
 }
 
-// "create" "table" table_name_clause "(" column1 "string" "," column2 "string" "," ... ")"
+// nullable_literal = literal | "null"
+// nil is returned if "null" is given.
+func parseNullableLiteral() *string {
+	if _, ok := consume(TkNull); ok {
+		return nil
+	}
+
+	return &parseLiteral().Value
+}
+
+// literal = str | int | float | "true" | "false"
+// The literal value is kept as string, then converted into the value of the column type on execution.
+func parseLiteral() *Literal {
+	if s, ok := consume(TkStr); ok {
+		return &Literal{Kind: LitString, Value: s}
+	}
+
+	if tk.Type == TkInt {
+		return &Literal{Kind: LitInt, Value: strconv.Itoa(mustConsumeInt())}
+	}
+
+	if s, ok := consume(TkFloat); ok {
+		return &Literal{Kind: LitFloat, Value: s}
+	}
+
+	if _, ok := consume(TkTrue); ok {
+		return &Literal{Kind: LitBool, Value: "true"}
+	}
+
+	if _, ok := consume(TkFalse); ok {
+		return &Literal{Kind: LitBool, Value: "false"}
+	}
+
+	panic(fmt.Sprintf("literal is expected but got %s", string(tk.Type)))
+}
+
+// "update" table_name_clause "set" column1 "=" nullable_literal "," column2 "=" nullable_literal "," ... where_clause
+func parseUpdate() *QueryStmt {
+	q := &QueryStmt{Update: &Update{}}
+
+	q.Update.Table = parseTableNameClause()
+
+	mustConsume(TkSet)
+
+	i := 1
+	for {
+		if i > 100 {
+			panic("cols must be less than 100")
+		}
+
+		q.Update.Cols = append(q.Update.Cols, parseName())
+		mustConsume(TkEqual)
+		q.Update.Vals = append(q.Update.Vals, parseNullableLiteral())
+
+		if _, ok := consume(TkComma); !ok {
+			break
+		}
+		i++
+	}
+
+	q.Update.Where = parseWhereClause()
+
+	return q
+}
+
+// "delete" "from" table_name_clause where_clause
+func parseDelete() *QueryStmt {
+	q := &QueryStmt{Delete: &Delete{}}
+
+	mustConsume(TkFrom)
+
+	q.Delete.Table = parseTableNameClause()
+	q.Delete.Where = parseWhereClause()
+
+	return q
+}
+
+// "create" "table" ("if" "not" "exists")? table_name_clause "(" create_def "," create_def "," ... ")"
+// create_def = column_def | "primary" "key" "(" columns ")" | "unique" "(" columns ")"
 func parseCreate() *QueryStmt {
 	q := &QueryStmt{Create: &Create{}}
 
 	mustConsume(TkTable)
 
+	if _, ok := consume(TkIf); ok {
+		mustConsume(TkNot)
+		mustConsume(TkExists)
+		q.Create.IfNotExists = true
+	}
+
 	q.Create.Table = parseTableNameClause()
 
 	mustConsume(TkLParen)
@@ -252,11 +771,32 @@ func parseCreate() *QueryStmt {
 			panic("a table can contain 100 columns at most")
 		}
 
-		s := mustConsume(TkSymbol)
-		q.Create.Cols = append(q.Create.Cols, s)
+		if _, ok := consume(TkPrimary); ok {
+			mustConsume(TkKey)
+			if q.Create.PrimaryKey != nil {
+				panic("multiple primary keys are not allowed")
+			}
+			q.Create.PrimaryKey = parseIndexCols()
+		} else if _, ok := consume(TkUnique); ok {
+			q.Create.Uniques = append(q.Create.Uniques, parseIndexCols())
+		} else {
+			def := parseColumnDef()
+			q.Create.Cols = append(q.Create.Cols, def.Name)
+			q.Create.Types = append(q.Create.Types, def.Type)
+			q.Create.NotNulls = append(q.Create.NotNulls, def.NotNull)
+			q.Create.Defaults = append(q.Create.Defaults, def.Default)
+
+			if def.PrimaryKey {
+				if q.Create.PrimaryKey != nil {
+					panic("multiple primary keys are not allowed")
+				}
+				q.Create.PrimaryKey = []string{def.Name}
+			}
 
-		mustConsume(TkString)
-		q.Create.Types = append(q.Create.Types, "string")
+			if def.Unique {
+				q.Create.Uniques = append(q.Create.Uniques, []string{def.Name})
+			}
+		}
 
 		if _, ok := consume(TkRParen); ok {
 			break
@@ -269,6 +809,186 @@ func parseCreate() *QueryStmt {
 	return q
 }
 
+// index_cols = "(" column_name ("," column_name)* ")"
+func parseIndexCols() []string {
+	mustConsume(TkLParen)
+
+	cols := []string{}
+	for {
+		cols = append(cols, parseName())
+
+		if _, ok := consume(TkRParen); ok {
+			return cols
+		}
+
+		mustConsume(TkComma)
+	}
+}
+
+// column_def = column_name type constraint*
+// constraint = "not" "null" | "null" | "default" nullable_literal | "primary" "key" | "unique"
+func parseColumnDef() *ColumnDef {
+	def := &ColumnDef{Name: parseName(), Type: parseType()}
+
+	for {
+		if _, ok := consume(TkNot); ok {
+			mustConsume(TkNull)
+			def.NotNull = true
+		} else if _, ok := consume(TkNull); ok {
+			def.NotNull = false
+		} else if _, ok := consume(TkDefault); ok {
+			def.Default = parseNullableLiteral()
+		} else if _, ok := consume(TkPrimary); ok {
+			mustConsume(TkKey)
+			def.PrimaryKey = true
+		} else if _, ok := consume(TkUnique); ok {
+			def.Unique = true
+		} else {
+			break
+		}
+	}
+
+	return def
+}
+
+// "create" "unique"? "index" index_name "on" table_name_clause index_cols
+func parseCreateIndex(unique bool) *QueryStmt {
+	q := &QueryStmt{CreateIndex: &CreateIndex{Unique: unique}}
+
+	q.CreateIndex.Name = parseName()
+	mustConsume(TkOn)
+	q.CreateIndex.Table = parseTableNameClause()
+
+	q.CreateIndex.Cols = parseIndexCols()
+
+	return q
+}
+
+// "drop" "table" ("if" "exists")? table_name_clause
+func parseDrop() *QueryStmt {
+	q := &QueryStmt{Drop: &Drop{}}
+
+	mustConsume(TkTable)
+
+	if _, ok := consume(TkIf); ok {
+		mustConsume(TkExists)
+		q.Drop.IfExists = true
+	}
+
+	q.Drop.Table = parseTableNameClause()
+
+	return q
+}
+
+// "alter" "table" table_name_clause alter_action
+// alter_action = "add" "column"? column_def
+//
+//	| "drop" "column"? column_name
+//	| "rename" "column"? column_name "to" column_name
+//	| "rename" "to" table_name
+func parseAlter() *QueryStmt {
+	q := &QueryStmt{Alter: &Alter{}}
+
+	mustConsume(TkTable)
+
+	q.Alter.Table = parseTableNameClause()
+
+	if _, ok := consume(TkAdd); ok {
+		consume(TkColumn)
+		q.Alter.AddCol = parseColumnDef()
+		return q
+	}
+
+	if _, ok := consume(TkDrop); ok {
+		consume(TkColumn)
+		q.Alter.DropCol = parseName()
+		return q
+	}
+
+	mustConsume(TkRename)
+
+	if _, ok := consume(TkTo); ok {
+		q.Alter.RenameTo = parseTableNameClause()
+		return q
+	}
+
+	consume(TkColumn)
+	from := parseName()
+	mustConsume(TkTo)
+	q.Alter.RenameCol = &RenameCol{From: from, To: parseName()}
+
+	return q
+}
+
+// "vacuum" table_name_clause?
+func parseVacuum() *QueryStmt {
+	q := &QueryStmt{Vacuum: &Vacuum{}}
+
+	if tk.Type == TkSymbol {
+		q.Vacuum.Table = parseTableNameClause()
+	}
+
+	return q
+}
+
+// "rollback" ("to" "savepoint"? savepoint_name)?
+func parseRollback() *QueryStmt {
+	q := &QueryStmt{Rollback: &Rollback{}}
+
+	if _, ok := consume(TkTo); ok {
+		consume(TkSavepoint)
+		q.Rollback.Savepoint = parseName()
+	}
+
+	return q
+}
+
+// "set" ("lock" "timeout" num | "transaction" "isolation" "level" isolation_level)
+// isolation_level = "read" "committed" | "repeatable" "read" | "serializable"
+func parseSet() *QueryStmt {
+	q := &QueryStmt{Set: &Set{}}
+
+	switch s := mustConsume(TkSymbol); strings.ToLower(s) {
+	case "lock":
+		mustConsumeWord("timeout")
+		ms := mustConsumeInt()
+		if ms < 0 {
+			panic("lock timeout must not be negative")
+		}
+		q.Set.LockTimeout = &ms
+	case "transaction":
+		mustConsumeWord("isolation")
+		mustConsumeWord("level")
+		switch s := mustConsume(TkSymbol); strings.ToLower(s) {
+		case "read":
+			mustConsumeWord("committed")
+			q.Set.Isolation = "read committed"
+		case "repeatable":
+			mustConsumeWord("read")
+			q.Set.Isolation = "repeatable read"
+		case "serializable":
+			q.Set.Isolation = "serializable"
+		default:
+			panic(fmt.Sprintf("unknown isolation level '%s'", s))
+		}
+	default:
+		panic(fmt.Sprintf("unknown parameter '%s'", s))
+	}
+
+	return q
+}
+
+// type = "string" | "int" | "float" | "bool" | "timestamp"
+func parseType() string {
+	for _, typ := range []TkType{TkString, TkIntType, TkFloatType, TkBool, TkTimestamp} {
+		if _, ok := consume(typ); ok {
+			return string(typ)
+		}
+	}
+
+	panic(fmt.Sprintf("column type is expected but got %s", string(tk.Type)))
+}
+
 func consume(typ TkType) (string, bool) {
 	if tk.Type != typ {
 		return "", false
@@ -279,6 +999,13 @@ func consume(typ TkType) (string, bool) {
 	return s, true
 }
 
+// mustConsumeWord consumes the symbol of the word, which is not a keyword but has the meaning in the place.
+func mustConsumeWord(word string) {
+	if s := mustConsume(TkSymbol); strings.ToLower(s) != word {
+		panic(fmt.Sprintf("%s is expected but got %s", word, s))
+	}
+}
+
 func mustConsume(typ TkType) string {
 	s, ok := consume(typ)
 	if !ok {
@@ -1,32 +1,65 @@
 package main
 
 import (
+	"errors"
+	"fmt"
+	"slices"
 	"sort"
+	"strings"
 )
 
-func planSelect(slct *Select) *QueryPlan {
-	cols, whr, odr, lim, ofs := slct.Columns, slct.Where, slct.Order, slct.Limit, slct.Offset
-	ops := []Operation{
-		func(rs []*Record) ([]*Record, error) {
-			rs, err := readData(slct.Table)
-			if err != nil {
-				return nil, err
+// planSelect makes the plan of the select. If the select is a correlated subquery,
+// outer is the record of the outer query which can be referred in the select.
+func planSelect(tx *Tx, slct *Select, outer *Record) *QueryPlan {
+	whr, odr, lim, ofs := slct.Where, slct.Order, slct.Limit, slct.Offset
+	alias := tableAlias(slct.Table, slct.Alias)
+
+	var scan *IndexScan
+	ops := []Operation{}
+	if slct.Subquery != nil {
+		ops = append(ops, OpSubquery(tx, slct.Subquery, alias))
+	} else {
+		ops = append(ops, OpScan(tx, slct.Table, alias))
+
+		// the index is used only to narrow the records to read, so the whole condition is still evaluated on them
+		if tDef, err := readCatalog(slct.Table); err == nil {
+			if scan = chooseIndex(tDef, slct); scan != nil {
+				Debug("index scan: ", scan)
+				ops[0] = OpIndexScan(tx, slct.Table, alias, scan)
 			}
+		}
+	}
 
-			return rs, nil
-		},
+	if outer != nil {
+		ops = append(ops, OpCorrelate(outer))
+	}
+
+	for _, j := range slct.Joins {
+		ops = append(ops, OpJoin(tx, j.Type, j.Table, tableAlias(j.Table, j.Alias), j.On))
 	}
 
 	if whr != nil {
-		if whr.Equal != nil {
-			ops = append(ops, OpWhereEq(whr.Equal.Column, whr.Equal.Value))
-		} else {
-			ops = append(ops, OpWhereNotEq(whr.NotEqual.Column, whr.NotEqual.Value))
-		}
+		ops = append(ops, OpFilter(tx, whr))
+	}
+
+	// the aggregated records have the columns in the select list, so they are not projected
+	agg := grouped(slct)
+	if agg {
+		ops = append(ops, OpAggregate(tx, slct, outer))
+	}
+
+	// the records are already in the order if they are read in the index order
+	if len(odr) != 0 && (scan == nil || !scan.Ordered) {
+		ops = append(ops, OpOrder(slct))
 	}
 
-	if odr != nil {
-		ops = append(ops, OpOrder(odr.Column, odr.Dir))
+	if !agg {
+		ops = append(ops, OpProjection(slct.Items))
+	}
+
+	// the duplicates are removed before limit and offset are applied
+	if slct.Distinct {
+		ops = append(ops, OpDistinct())
 	}
 
 	if lim != nil && ofs != nil {
@@ -37,36 +70,686 @@ func planSelect(slct *Select) *QueryPlan {
 		ops = append(ops, OpLimitOffset(-1, ofs.Count))
 	}
 
-	ops = append(ops, OpProjection(cols))
+	// only the records in the result are locked
+	if slct.Lock != "" {
+		mode := lockShare
+		if slct.Lock == "update" {
+			mode = lockExclusive
+		}
+		ops = append(ops, OpLock(tx, slct.Table, mode))
+	}
+
 	return &QueryPlan{Ops: ops}
 }
 
+// grouped returns true if the records are aggregated in the select.
+func grouped(slct *Select) bool {
+	return len(slct.GroupBy) != 0 || slct.Having != nil ||
+		slices.ContainsFunc(slct.Items, func(item *SelectItem) bool { return item.Aggregate != nil })
+}
+
+// tableSchema returns the record of NULLs which has the columns of the table.
+func tableSchema(tbl, alias string) (*Record, error) {
+	tDef, err := readCatalog(tbl)
+	if err != nil {
+		return nil, fmt.Errorf("read table '%s' definition from catalog: %w", tbl, err)
+	}
+
+	r := &Record{Vals: make([]any, len(tDef.Cols))}
+	for _, c := range tDef.Cols {
+		r.Cols = append(r.Cols, c.Name)
+		r.Types = append(r.Types, c.Type)
+	}
+	qualify([]*Record{r}, alias)
+	return r, nil
+}
+
+// fromSchema returns the record of NULLs which has the columns of the tables in the from clause and the joins.
+// This is used to know the columns even if no record is read.
+func fromSchema(slct *Select) (*Record, error) {
+	alias := tableAlias(slct.Table, slct.Alias)
+
+	var r *Record
+	var err error
+	if slct.Subquery != nil {
+		if r, err = selectSchema(slct.Subquery); err != nil {
+			return nil, err
+		}
+		qualify([]*Record{r}, alias)
+	} else if r, err = tableSchema(slct.Table, alias); err != nil {
+		return nil, err
+	}
+
+	for _, j := range slct.Joins {
+		jr, err := tableSchema(j.Table, tableAlias(j.Table, j.Alias))
+		if err != nil {
+			return nil, err
+		}
+		r = r.Join(jr)
+	}
+
+	return r, nil
+}
+
+// selectSchema returns the record of NULLs which has the columns the select returns.
+func selectSchema(slct *Select) (*Record, error) {
+	r, err := fromSchema(slct)
+	if err != nil {
+		return nil, err
+	}
+
+	if grouped(slct) {
+		return aggregateSchema(slct, r)
+	}
+
+	rs, err := OpProjection(slct.Items)([]*Record{r})
+	if err != nil {
+		return nil, err
+	}
+	return rs[0], nil
+}
+
+// tableAlias returns the name to qualify the columns of the table in the query.
+func tableAlias(tbl, alias string) string {
+	if alias != "" {
+		return alias
+	}
+	return tbl
+}
+
+// bareColumn returns the column name without the qualifier if the column reference is unqualified
+// or qualified by the alias. Otherwise the reference is returned as is.
+func bareColumn(ref, alias string) string {
+	if tbl, col, ok := strings.Cut(ref, "."); ok && tbl == alias {
+		return col
+	}
+	return ref
+}
+
 type QueryPlan struct {
 	Ops []Operation
 }
 
+// Run runs the operations in order and returns the result of the last one.
+func (p *QueryPlan) Run() ([]*Record, error) {
+	var rs []*Record
+	for _, op := range p.Ops {
+		var err error
+		if rs, err = op(rs); err != nil {
+			return nil, err
+		}
+	}
+	return rs, nil
+}
+
+// runSubquery runs the subquery in the expression evaluated on the record of the outer query.
+func runSubquery(tx *Tx, slct *Select, outer *Record) ([]*Record, error) {
+	rs, err := planSelect(tx, slct, outer).Run()
+	if err != nil {
+		return nil, fmt.Errorf("subquery: %w", err)
+	}
+	return rs, nil
+}
+
 // Operation represents a relational algebra operator.
 type Operation func(rs []*Record) ([]*Record, error)
 
-func OpWhereEq(col, key string) func(rs []*Record) ([]*Record, error) {
+// qualify sets the alias of the table on the columns of the records.
+func qualify(rs []*Record, alias string) {
+	for _, r := range rs {
+		r.Tables = make([]string, len(r.Cols))
+		for i := range r.Tables {
+			r.Tables[i] = alias
+		}
+	}
+}
+
+// OpScan reads all the records in the table.
+func OpScan(tx *Tx, tbl, alias string) func(rs []*Record) ([]*Record, error) {
+	return func(rs []*Record) ([]*Record, error) {
+		rs, err := readData(tx, tbl)
+		if err != nil {
+			return nil, err
+		}
+
+		qualify(rs, alias)
+		return rs, nil
+	}
+}
+
+// OpIndexScan reads the records in the table found by the index scan.
+func OpIndexScan(tx *Tx, tbl, alias string, scan *IndexScan) func(rs []*Record) ([]*Record, error) {
+	return func(rs []*Record) ([]*Record, error) {
+		rs, err := readDataByIndex(tx, tbl, scan)
+		if err != nil {
+			return nil, err
+		}
+
+		qualify(rs, alias)
+		return rs, nil
+	}
+}
+
+// OpSubquery reads the records the subquery returns as the records of the table with the alias.
+func OpSubquery(tx *Tx, slct *Select, alias string) func(rs []*Record) ([]*Record, error) {
+	return func(rs []*Record) ([]*Record, error) {
+		rs, err := planSelect(tx, slct, nil).Run()
+		if err != nil {
+			return nil, fmt.Errorf("subquery: %w", err)
+		}
+
+		qualify(rs, alias)
+		return rs, nil
+	}
+}
+
+// OpCorrelate makes the columns of the outer record referable from the records.
+func OpCorrelate(outer *Record) func(rs []*Record) ([]*Record, error) {
 	return func(rs []*Record) ([]*Record, error) {
-		i := 0
 		for _, r := range rs {
-			if r.Find(col, key) {
-				rs[i] = r
-				i++
-			}
+			r.Outer = outer
 		}
-		rs = rs[:i]
 		return rs, nil
 	}
 }
 
-func OpWhereNotEq(col, key string) func(rs []*Record) ([]*Record, error) {
+// OpJoin combines the records with the records in the table on which the condition is true.
+// In cross join, every combination is returned. In left join, the records without any combination
+// are combined with NULLs.
+//
+// If the condition has equalities between the columns of both sides, the records in the table are
+// looked up in the hash table built on the columns (hash join). Otherwise every combination is
+// evaluated (nested loop join).
+func OpJoin(tx *Tx, typ JoinType, tbl, alias string, on *Expr) func(rs []*Record) ([]*Record, error) {
+	return func(rs []*Record) ([]*Record, error) {
+		right, err := readData(tx, tbl)
+		if err != nil {
+			return nil, err
+		}
+		qualify(right, alias)
+
+		// the record of NULLs to be combined in left join
+		nulls, err := tableSchema(tbl, alias)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(rs) == 0 {
+			return rs, nil
+		}
+
+		// the invalid column references are reported even if no combination is evaluated
+		if on != nil {
+			if _, err := evalCondition(tx, on, rs[0].Join(nulls)); err != nil {
+				return nil, err
+			}
+		}
+
+		candidates := func(l *Record) []*Record { return right }
+		if lcols, rcols := equiJoinColumns(on, rs[0], nulls); lcols != nil {
+			Debug("hash join on: ", lcols, rcols)
+			hashed := map[string][]*Record{}
+			for _, r := range right {
+				if k, ok := joinKey(r, rcols); ok {
+					hashed[k] = append(hashed[k], r)
+				}
+			}
+
+			candidates = func(l *Record) []*Record {
+				k, ok := joinKey(l, lcols)
+				if !ok {
+					return nil // NULL never equals to anything
+				}
+				return hashed[k]
+			}
+		}
+
+		joined := []*Record{}
+		for _, l := range rs {
+			matched := false
+			for _, r := range candidates(l) {
+				j := l.Join(r)
+				if on != nil {
+					ok, err := evalCondition(tx, on, j)
+					if err != nil {
+						return nil, err
+					}
+
+					if ok == nil || !*ok {
+						continue
+					}
+				}
+
+				joined = append(joined, j)
+				matched = true
+			}
+
+			if !matched && typ == JoinLeft {
+				joined = append(joined, l.Join(nulls))
+			}
+		}
+
+		return joined, nil
+	}
+}
+
+// equiJoinColumns returns the positions of the columns in the left and right records which are compared
+// by the equalities in the top-level conjunctions of the condition. nil is returned if there is no such equality.
+// The columns must have the same type so that the equal values have the same hash key.
+func equiJoinColumns(on *Expr, left, right *Record) (lcols, rcols []int) {
+	if on == nil {
+		return nil, nil
+	}
+
+	// the position of the column which is found only in the record
+	position := func(r, other *Record, col string) (int, bool) {
+		i, err := r.ColIndex(col)
+		if err != nil {
+			return 0, false
+		}
+
+		if _, err := other.ColIndex(col); err == nil {
+			return 0, false
+		}
+
+		return i, true
+	}
+
+	for _, cond := range conjunctions(on) {
+		b := cond.Binary
+		if b == nil || b.Op != TkEqual || b.Left.Column == "" || b.Right.Column == "" {
+			continue
+		}
+
+		for _, pair := range [][2]string{{b.Left.Column, b.Right.Column}, {b.Right.Column, b.Left.Column}} {
+			l, lok := position(left, right, pair[0])
+			r, rok := position(right, left, pair[1])
+			if lok && rok && left.Types[l] == right.Types[r] {
+				lcols = append(lcols, l)
+				rcols = append(rcols, r)
+				break
+			}
+		}
+	}
+
+	return lcols, rcols
+}
+
+// joinKey returns the hash key of the values at the positions. false is returned if any of them is NULL.
+func joinKey(r *Record, cols []int) (string, bool) {
+	key := make([]any, len(cols))
+	for i, c := range cols {
+		key[i] = r.Vals[c]
+	}
+	return uniqueKey(key)
+}
+
+// IndexScan is the access path to read the records through an index.
+type IndexScan struct {
+	Index string
+	Range keyRange
+
+	// Ordered is true if the records are returned in the index order, which satisfies the order clause.
+	// Otherwise they are returned in the order of the heap file.
+	Ordered bool
+	Desc    bool
+
+	// Covering is true if every column in the query is in the index, so that the records are made from the index keys
+	// without reading the heap file on the pages all-visible in the visibility map (index-only scan).
+	Covering bool
+}
+
+// chooseIndex returns the index scan to read the records for the select, or nil if every record must be read.
+//
+// The comparisons between an indexed column and a literal ("=", "<", "<=", ">", ">=" and "between")
+// in the top-level conjunctions of the condition narrow the range of the index scan.
+// The equalities on the leading columns of the index and the range on the next column can be used,
+// so an index on (a, b) is usable for "a = 1", "a > 1" and "a = 1 and b > 2", but not for "b = 2".
+// An index is also usable without the condition if its order satisfies the order clause.
+//
+// The index with the longest usable prefix is chosen. If there is a tie, the one satisfying the order clause,
+// then the covering one is preferred. No index is used if other tables are joined.
+func chooseIndex(tDef *CtTable, slct *Select) *IndexScan {
+	if len(slct.Joins) != 0 {
+		return nil
+	}
+
+	conds := []*Expr{}
+	if slct.Where != nil {
+		conds = conjunctions(slct.Where)
+	}
+	alias := tableAlias(slct.Table, slct.Alias)
+	cols := queryColumns(tDef, slct)
+
+	var chosen *IndexScan
+	var chosenScore []int
+	for _, idx := range tDef.Indexes {
+		scan := &IndexScan{Index: idx.Name}
+
+		// the equalities on the leading columns, then the range on the next one
+		ranged := false
+		for _, col := range idx.Cols {
+			i := tDef.ColIndex(col)
+			if i < 0 {
+				break
+			}
+
+			r := keyRange{}
+			matched := false
+			for _, cond := range conds {
+				if narrowRange(&r, cond, alias, col, tDef.Cols[i].Type) {
+					matched = true
+				}
+			}
+			if !matched {
+				break
+			}
+
+			if r.Low != nil && r.LowIncl && r.HighIncl && compareValues(r.Low, r.High) == 0 {
+				scan.Range.Eq = append(scan.Range.Eq, r.Low)
+				continue
+			}
+
+			scan.Range.Low, scan.Range.High, scan.Range.LowIncl, scan.Range.HighIncl = r.Low, r.High, r.LowIncl, r.HighIncl
+			ranged = true
+			break
+		}
+
+		// the aggregated records are not in the order of the scan
+		if !grouped(slct) {
+			scan.Ordered, scan.Desc = indexOrder(idx, slct.Order, slct.Items, alias, len(scan.Range.Eq))
+		}
+
+		if len(scan.Range.Eq) == 0 && !ranged && !scan.Ordered {
+			continue
+		}
+
+		// the columns referred in the correlated subqueries are not known
+		scan.Covering = !hasSubquery(slct.Where) && !hasSubquery(slct.Having) &&
+			!slices.ContainsFunc(cols, func(col string) bool { return !slices.Contains(idx.Cols, col) })
+
+		score := []int{len(scan.Range.Eq), boolScore(ranged), boolScore(scan.Ordered), boolScore(scan.Covering)}
+		if chosen == nil || slices.Compare(score, chosenScore) > 0 {
+			chosen, chosenScore = scan, score
+		}
+	}
+
+	return chosen
+}
+
+// indexOrder returns true if the records read in the index order are sorted in the order clause,
+// and whether the index is read backward. The records having the same values on the leading eq columns
+// are ordered by the next columns, so the sort keys must be the columns following them in the same direction.
+// NULLs must be placed as the index does, which is at the end in asc.
+func indexOrder(idx *CtIndex, odrs []*Order, items []*SelectItem, alias string, eq int) (ordered, desc bool) {
+	if len(odrs) == 0 {
+		return false, false
+	}
+
+	desc = odrs[0].Dir == "desc"
+	start := 0
+	for i, odr := range odrs {
+		if odr.Position > 0 || (odr.Dir == "desc") != desc || odr.NullsFirst != desc || isItemAlias(items, odr.Column) {
+			return false, false
+		}
+
+		j := slices.Index(idx.Cols, bareColumn(odr.Column, alias))
+		if i == 0 {
+			if j < 0 || j > eq {
+				return false, false
+			}
+			start = j
+		} else if j != start+i {
+			return false, false
+		}
+	}
+
+	return true, desc
+}
+
+// isItemAlias returns true if the name is an alias of the select item.
+func isItemAlias(items []*SelectItem, name string) bool {
+	return slices.ContainsFunc(items, func(item *SelectItem) bool { return item.Alias != "" && item.Alias == name })
+}
+
+func boolScore(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// queryColumns returns the columns referred in the select. The qualifiers of the table are removed.
+func queryColumns(tDef *CtTable, slct *Select) []string {
+	cols := []string{}
+	for _, item := range slct.Items {
+		switch {
+		case item.Aggregate != nil:
+			if item.Aggregate.Column != "*" {
+				cols = append(cols, item.Aggregate.Column)
+			}
+		case item.Column == "*":
+			for _, c := range tDef.Cols {
+				cols = append(cols, c.Name)
+			}
+		default:
+			cols = append(cols, item.Column)
+		}
+	}
+
+	if slct.Where != nil {
+		cols = append(cols, exprColumns(slct.Where)...)
+	}
+
+	cols = append(cols, slct.GroupBy...)
+	if slct.Having != nil {
+		cols = append(cols, exprColumns(slct.Having)...)
+	}
+
+	// the positions and the aliases refer to the select items which are already added
+	for _, odr := range slct.Order {
+		if odr.Position == 0 && !isItemAlias(slct.Items, odr.Column) {
+			cols = append(cols, odr.Column)
+		}
+	}
+
+	alias := tableAlias(slct.Table, slct.Alias)
+	for i := range cols {
+		cols[i] = bareColumn(cols[i], alias)
+	}
+
+	return cols
+}
+
+// hasSubquery returns true if the expression contains any subquery.
+func hasSubquery(e *Expr) bool {
+	switch {
+	case e == nil:
+		return false
+	case e.Subquery != nil, e.Exists != nil:
+		return true
+	case e.Binary != nil:
+		return hasSubquery(e.Binary.Left) || hasSubquery(e.Binary.Right)
+	case e.Unary != nil:
+		return hasSubquery(e.Unary.Operand)
+	case e.Logical != nil:
+		return hasSubquery(e.Logical.Left) || hasSubquery(e.Logical.Right)
+	case e.In != nil:
+		return e.In.Subquery != nil || hasSubquery(e.In.Operand) || slices.ContainsFunc(e.In.List, hasSubquery)
+	case e.Between != nil:
+		return hasSubquery(e.Between.Operand) || hasSubquery(e.Between.Low) || hasSubquery(e.Between.High)
+	}
+	return false
+}
+
+// exprColumns returns the columns referred in the expression. The columns in the subqueries are not included.
+func exprColumns(e *Expr) []string {
+	switch {
+	case e.Column != "":
+		return []string{e.Column}
+	case e.Aggregate != nil:
+		if e.Aggregate.Column == "*" {
+			return nil
+		}
+		return []string{e.Aggregate.Column}
+	case e.Binary != nil:
+		return append(exprColumns(e.Binary.Left), exprColumns(e.Binary.Right)...)
+	case e.Unary != nil:
+		return exprColumns(e.Unary.Operand)
+	case e.Logical != nil:
+		return append(exprColumns(e.Logical.Left), exprColumns(e.Logical.Right)...)
+	case e.In != nil:
+		cols := exprColumns(e.In.Operand)
+		for _, item := range e.In.List {
+			cols = append(cols, exprColumns(item)...)
+		}
+		return cols
+	case e.Between != nil:
+		cols := exprColumns(e.Between.Operand)
+		cols = append(cols, exprColumns(e.Between.Low)...)
+		return append(cols, exprColumns(e.Between.High)...)
+	}
+	return nil
+}
+
+// conjunctions returns the operands of the top-level "and"s in the expression.
+func conjunctions(e *Expr) []*Expr {
+	if e.Logical != nil && e.Logical.Op == TkAnd {
+		return append(conjunctions(e.Logical.Left), conjunctions(e.Logical.Right)...)
+	}
+	return []*Expr{e}
+}
+
+// narrowRange narrows the range by the condition if it compares the column of the table with a literal.
+// false is returned if the condition has nothing to do with the range.
+func narrowRange(r *keyRange, cond *Expr, alias, col, typ string) bool {
+	if b := cond.Between; b != nil {
+		if bareColumn(b.Operand.Column, alias) != col {
+			return false
+		}
+
+		low, ok := indexValue(b.Low, typ)
+		if !ok {
+			return false
+		}
+
+		high, ok := indexValue(b.High, typ)
+		if !ok {
+			return false
+		}
+
+		r.narrowLow(low, true)
+		r.narrowHigh(high, true)
+		return true
+	}
+
+	b := cond.Binary
+	if b == nil {
+		return false
+	}
+
+	op, lit := b.Op, b.Right
+	if bareColumn(b.Left.Column, alias) != col {
+		if bareColumn(b.Right.Column, alias) != col {
+			return false
+		}
+
+		// "literal op column" is the same as "column op' literal"
+		op, lit = flipComparison[op], b.Left
+	}
+
+	v, ok := indexValue(lit, typ)
+	if !ok {
+		return false
+	}
+
+	switch op {
+	case TkEqual:
+		r.narrowLow(v, true)
+		r.narrowHigh(v, true)
+	case TkGreater:
+		r.narrowLow(v, false)
+	case TkGreaterEq:
+		r.narrowLow(v, true)
+	case TkLess:
+		r.narrowHigh(v, false)
+	case TkLessEqual:
+		r.narrowHigh(v, true)
+	default:
+		return false
+	}
+
+	return true
+}
+
+var flipComparison = map[TkType]TkType{
+	TkEqual:     TkEqual,
+	TkLess:      TkGreater,
+	TkLessEqual: TkGreaterEq,
+	TkGreater:   TkLess,
+	TkGreaterEq: TkLessEqual,
+}
+
+// indexValue returns the value of the literal to be compared with the indexed column of the type.
+// false is returned if the expression is not a literal, or the value is NULL or not comparable with the column.
+func indexValue(e *Expr, typ string) (any, bool) {
+	if e.Literal == nil {
+		return nil, false
+	}
+
+	v, err := literalValue(e.Literal)
+	if err != nil || v == nil {
+		return nil, false
+	}
+
+	if s, ok := v.(strLiteral); ok {
+		v, err = parseValue(typ, string(s))
+		if err != nil {
+			return nil, false // reported on evaluating the condition
+		}
+		return v, true
+	}
+
+	if t := typeOf(v); t != typ && !(isNumericType(t) && isNumericType(typ)) {
+		return nil, false
+	}
+
+	return v, true
+}
+
+func isNumericType(typ string) bool {
+	return typ == TypeInt || typ == TypeFloat
+}
+
+func (r *keyRange) narrowLow(v any, incl bool) {
+	if r.Low != nil {
+		if c := compareValues(v, r.Low); c < 0 || c == 0 && (incl || !r.LowIncl) {
+			return
+		}
+	}
+	r.Low, r.LowIncl = v, incl
+}
+
+func (r *keyRange) narrowHigh(v any, incl bool) {
+	if r.High != nil {
+		if c := compareValues(v, r.High); c > 0 || c == 0 && (incl || !r.HighIncl) {
+			return
+		}
+	}
+	r.High, r.HighIncl = v, incl
+}
+
+// OpFilter keeps the records on which the expression is true. The records on which it is false or NULL are removed.
+func OpFilter(tx *Tx, e *Expr) func(rs []*Record) ([]*Record, error) {
 	return func(rs []*Record) ([]*Record, error) {
 		i := 0
 		for _, r := range rs {
-			if !r.Find(col, key) {
+			ok, err := evalCondition(tx, e, r)
+			if err != nil {
+				return nil, err
+			}
+
+			if ok != nil && *ok {
 				rs[i] = r
 				i++
 			}
@@ -76,21 +759,437 @@ func OpWhereNotEq(col, key string) func(rs []*Record) ([]*Record, error) {
 	}
 }
 
-func OpOrder(col, dir string) func(rs []*Record) ([]*Record, error) {
+// OpAggregate groups the records by the columns in the group clause, and returns a record for each group
+// which has the columns in the select list. The groups on which the having condition is not true are removed.
+// Without the group clause, all the records are aggregated into one record even if there is no record.
+//
+// The records are grouped in a hash table, and the groups are returned in the order of their first record.
+func OpAggregate(tx *Tx, slct *Select, outer *Record) func(rs []*Record) ([]*Record, error) {
+	return func(rs []*Record) ([]*Record, error) {
+		schema, err := fromSchema(slct)
+		if err != nil {
+			return nil, err
+		}
+
+		out, err := aggregateSchema(slct, schema)
+		if err != nil {
+			return nil, err
+		}
+
+		// the columns are resolved on the records because they can be different from the schema (e.g. index-only scan)
+		layout := schema
+		if len(rs) != 0 {
+			layout = rs[0]
+		}
+
+		keys := make([]int, len(slct.GroupBy))
+		for i, col := range slct.GroupBy {
+			if keys[i], err = layout.ColIndex(col); err != nil {
+				return nil, err
+			}
+		}
+
+		type group struct {
+			key  *Record // the record which has only the columns in the group clause
+			rows []*Record
+		}
+		groups := []*group{}
+		hashed := map[string]*group{}
+		for _, r := range rs {
+			key := &Record{Outer: r.Outer}
+			for _, k := range keys {
+				key.Cols = append(key.Cols, r.Cols[k])
+				key.Types = append(key.Types, r.Types[k])
+				key.Vals = append(key.Vals, r.Vals[k])
+				key.Tables = append(key.Tables, r.Tables[k])
+			}
+
+			// NULLs are grouped together unlike the unique key
+			t, err := encodeTuple(key.Vals)
+			if err != nil {
+				return nil, err
+			}
+
+			g, ok := hashed[string(t)]
+			if !ok {
+				g = &group{key: key}
+				hashed[string(t)] = g
+				groups = append(groups, g)
+			}
+			g.rows = append(g.rows, r)
+		}
+
+		if len(slct.GroupBy) == 0 && len(groups) == 0 {
+			groups = append(groups, &group{key: &Record{Outer: outer}})
+		}
+
+		aggs := aggregates(slct)
+		aggregated := []*Record{}
+		for _, g := range groups {
+			g.key.Aggregates = map[string]any{}
+			for _, agg := range aggs {
+				v, err := aggregate(agg, g.rows, layout)
+				if err != nil {
+					return nil, err
+				}
+				g.key.Aggregates[agg.String()] = v
+			}
+
+			if slct.Having != nil {
+				ok, err := evalCondition(tx, slct.Having, g.key)
+				if err != nil {
+					return nil, groupError(err, schema)
+				}
+
+				if ok == nil || !*ok {
+					continue
+				}
+			}
+
+			r := out.Clone()
+			for i, item := range slct.Items {
+				if item.Aggregate != nil {
+					r.Vals[i] = g.key.Aggregates[item.Aggregate.String()]
+					continue
+				}
+
+				v, err := evalExpr(tx, &Expr{Column: item.Column}, g.key)
+				if err != nil {
+					return nil, groupError(err, schema)
+				}
+				r.Vals[i] = v
+			}
+			aggregated = append(aggregated, r)
+		}
+
+		return aggregated, nil
+	}
+}
+
+// aggregateSchema returns the record of NULLs which has the columns of the aggregated records.
+// The columns are the items in the select list, and the aggregate function is named after the function (e.g. "count")
+// unless the item has the alias.
+// The schema is the record which has the columns of the records to be aggregated.
+func aggregateSchema(slct *Select, schema *Record) (*Record, error) {
+	r := &Record{Vals: make([]any, len(slct.Items))}
+	for _, item := range slct.Items {
+		if agg := item.Aggregate; agg != nil {
+			typ, err := aggregateType(agg, schema)
+			if err != nil {
+				return nil, err
+			}
+
+			r.Cols = append(r.Cols, string(agg.Func))
+			r.Types = append(r.Types, typ)
+			r.Tables = append(r.Tables, "")
+			continue
+		}
+
+		if item.Column == "*" {
+			return nil, fmt.Errorf("'*' cannot be selected with aggregate functions or group by")
+		}
+
+		i, err := schema.ColIndex(item.Column)
+		if err != nil {
+			return nil, err
+		}
+
+		if !slices.ContainsFunc(slct.GroupBy, func(col string) bool {
+			j, err := schema.ColIndex(col)
+			return err == nil && i == j
+		}) {
+			return nil, fmt.Errorf("column '%s' must appear in the group by clause or be used in an aggregate function", item.Column)
+		}
+
+		r.Cols = append(r.Cols, schema.Cols[i])
+		r.Types = append(r.Types, schema.Types[i])
+		r.Tables = append(r.Tables, schema.Tables[i])
+	}
+
+	for i, item := range slct.Items {
+		if item.Alias != "" {
+			r.Cols[i] = item.Alias
+		}
+	}
+
+	return r, nil
+}
+
+// aggregateType returns the type of the value of the aggregate function on the records of the schema.
+func aggregateType(agg *Aggregate, schema *Record) (string, error) {
+	if agg.Column == "*" {
+		return TypeInt, nil
+	}
+
+	i, err := schema.ColIndex(agg.Column)
+	if err != nil {
+		return "", err
+	}
+	typ := schema.Types[i]
+
+	switch agg.Func {
+	case AggCount:
+		return TypeInt, nil
+	case AggSum, AggAvg:
+		if typ != TypeInt && typ != TypeFloat {
+			return "", fmt.Errorf("%s is not defined for %s column '%s'", agg.Func, typ, agg.Column)
+		}
+		if agg.Func == AggAvg {
+			return TypeFloat, nil
+		}
+	}
+
+	return typ, nil
+}
+
+// aggregates returns the aggregate functions in the select list and the having clause without duplicates.
+func aggregates(slct *Select) []*Aggregate {
+	aggs := []*Aggregate{}
+	add := func(agg *Aggregate) {
+		if !slices.ContainsFunc(aggs, func(a *Aggregate) bool { return *a == *agg }) {
+			aggs = append(aggs, agg)
+		}
+	}
+
+	for _, item := range slct.Items {
+		if item.Aggregate != nil {
+			add(item.Aggregate)
+		}
+	}
+
+	var walk func(e *Expr)
+	walk = func(e *Expr) {
+		switch {
+		case e == nil:
+		case e.Aggregate != nil:
+			add(e.Aggregate)
+		case e.Binary != nil:
+			walk(e.Binary.Left)
+			walk(e.Binary.Right)
+		case e.Unary != nil:
+			walk(e.Unary.Operand)
+		case e.Logical != nil:
+			walk(e.Logical.Left)
+			walk(e.Logical.Right)
+		case e.In != nil:
+			walk(e.In.Operand)
+			for _, item := range e.In.List {
+				walk(item)
+			}
+		case e.Between != nil:
+			walk(e.Between.Operand)
+			walk(e.Between.Low)
+			walk(e.Between.High)
+		}
+	}
+	walk(slct.Having)
+
+	return aggs
+}
+
+// aggregate computes the aggregate function on the records. The columns are resolved on the layout record.
+// NULLs are ignored except in "count(*)", and the result is NULL if there is no value except in count.
+func aggregate(agg *Aggregate, rs []*Record, layout *Record) (any, error) {
+	if agg.Column == "*" {
+		return int64(len(rs)), nil
+	}
+
+	c, err := layout.ColIndex(agg.Column)
+	if err != nil {
+		return nil, err
+	}
+
+	vals := []any{}
+	for _, r := range rs {
+		if r.Vals[c] != nil {
+			vals = append(vals, r.Vals[c])
+		}
+	}
+
+	if agg.Func == AggCount {
+		return int64(len(vals)), nil
+	}
+
+	if len(vals) == 0 {
+		return nil, nil
+	}
+
+	switch agg.Func {
+	case AggSum, AggAvg:
+		var isum int64
+		var fsum float64
+		float := false
+		for _, v := range vals {
+			switch v := v.(type) {
+			case int64:
+				isum += v
+			case float64:
+				fsum += v
+				float = true
+			default:
+				return nil, fmt.Errorf("%s is not defined for %s column '%s'", agg.Func, typeOf(v), agg.Column)
+			}
+		}
+
+		if agg.Func == AggAvg {
+			return (float64(isum) + fsum) / float64(len(vals)), nil
+		}
+
+		if float {
+			return float64(isum) + fsum, nil
+		}
+		return isum, nil
+
+	case AggMin, AggMax:
+		m := vals[0]
+		for _, v := range vals[1:] {
+			c := compareValues(v, m)
+			if agg.Func == AggMin && c < 0 || agg.Func == AggMax && c > 0 {
+				m = v
+			}
+		}
+		return m, nil
+	}
+
+	return nil, fmt.Errorf("unknown aggregate function: %s", agg.Func)
+}
+
+// groupError returns the error on evaluating an expression on the group.
+// The column which is not in the group clause is reported as it must be grouped.
+func groupError(err error, schema *Record) error {
+	var nf *columnNotFoundError
+	if errors.As(err, &nf) {
+		if _, serr := schema.ColIndex(nf.col); serr == nil {
+			return fmt.Errorf("column '%s' must appear in the group by clause or be used in an aggregate function", nf.col)
+		}
+	}
+	return err
+}
+
+// OpOrder sorts the records by the keys in the order clause. The sort is stable, so the records
+// having the same keys stay in the order they are read.
+func OpOrder(slct *Select) func(rs []*Record) ([]*Record, error) {
 	return func(rs []*Record) ([]*Record, error) {
 		if len(rs) == 0 {
 			return rs, nil
 		}
 
-		sort.Slice(rs, func(i, j int) bool {
-			if dir == "asc" {
-				return rs[i].Value(col) < rs[j].Value(col)
+		keys, err := orderKeys(slct, rs[0])
+		if err != nil {
+			return nil, err
+		}
+
+		sort.SliceStable(rs, func(i, j int) bool {
+			for k, c := range keys {
+				if c := compareOrder(rs[i].Vals[c], rs[j].Vals[c], slct.Order[k]); c != 0 {
+					return c < 0
+				}
 			}
-			return rs[j].Value(col) < rs[i].Value(col)
+			return false
 		})
 		return rs, nil
 	}
+}
+
+// orderKeys returns the positions of the sort keys in the record.
+// The position and the alias of the select item refers to the item, otherwise the key is the column of the record.
+func orderKeys(slct *Select, r *Record) ([]int, error) {
+	agg := grouped(slct)
+	all := slct.Items[0].Column == "*"
+
+	keys := make([]int, len(slct.Order))
+	for i, odr := range slct.Order {
+		// the select item the key refers to
+		item := -1
+		switch {
+		case odr.Position > 0 && all:
+			if odr.Position > len(r.Cols) {
+				return nil, fmt.Errorf("order by position %d is not in select list", odr.Position)
+			}
+			keys[i] = odr.Position - 1
+			continue
+
+		case odr.Position > 0:
+			if odr.Position > len(slct.Items) {
+				return nil, fmt.Errorf("order by position %d is not in select list", odr.Position)
+			}
+			item = odr.Position - 1
+
+		default:
+			item = slices.IndexFunc(slct.Items, func(it *SelectItem) bool { return it.Alias != "" && it.Alias == odr.Column })
+			if item < 0 && agg {
+				// the aggregated record is named after the alias
+				item = slices.IndexFunc(slct.Items, func(it *SelectItem) bool { return it.Column == odr.Column })
+			}
+		}
+
+		// the aggregated record has the columns in the select list
+		if item >= 0 && agg {
+			keys[i] = item
+			continue
+		}
+
+		col := odr.Column
+		if item >= 0 {
+			col = slct.Items[item].Column
+		}
+
+		c, err := r.ColIndex(col)
+		if err != nil {
+			return nil, err
+		}
+
+		// the records are sorted before the projection, so the column not selected must not decide the order of the distinct ones
+		if slct.Distinct && !agg && !all && item < 0 && !slices.ContainsFunc(slct.Items, func(it *SelectItem) bool {
+			j, err := r.ColIndex(it.Column)
+			return err == nil && j == c
+		}) {
+			return nil, fmt.Errorf("for select distinct, order by column '%s' must appear in select list", col)
+		}
+
+		keys[i] = c
+	}
 
+	return keys, nil
+}
+
+// compareOrder compares the values in the direction of the sort key. NULLs are placed as the key specifies.
+func compareOrder(a, b any, odr *Order) int {
+	if (a == nil) != (b == nil) {
+		if (a == nil) == odr.NullsFirst {
+			return -1
+		}
+		return 1
+	}
+
+	c := compareValues(a, b)
+	if odr.Dir == "desc" {
+		return -c
+	}
+	return c
+}
+
+// OpDistinct removes the duplicate records. The first one of the duplicates is kept, so the order is not changed.
+// NULLs are equal to each other unlike in the comparison.
+func OpDistinct() func(rs []*Record) ([]*Record, error) {
+	return func(rs []*Record) ([]*Record, error) {
+		seen := map[string]bool{}
+		n := 0
+		for _, r := range rs {
+			t, err := encodeTuple(r.Vals)
+			if err != nil {
+				return nil, err
+			}
+
+			if seen[string(t)] {
+				continue
+			}
+			seen[string(t)] = true
+			rs[n] = r
+			n++
+		}
+		return rs[:n], nil
+	}
 }
 
 func OpLimitOffset(limit, offset int) func(rs []*Record) ([]*Record, error) {
@@ -120,21 +1219,54 @@ func OpLimitOffset(limit, offset int) func(rs []*Record) ([]*Record, error) {
 	}
 }
 
-func OpProjection(cols []string) func(rs []*Record) ([]*Record, error) {
+// OpLock takes the row locks of the records in the table, waiting for the transactions holding the conflicting ones.
+// The record changed by the transaction which has ended meanwhile cannot be locked, because the change is not visible.
+func OpLock(tx *Tx, tbl string, mode lockMode) func(rs []*Record) ([]*Record, error) {
+	return func(rs []*Record) ([]*Record, error) {
+		tDef, err := readCatalog(tbl)
+		if err != nil {
+			return nil, fmt.Errorf("read table '%s' definition from catalog: %w", tbl, err)
+		}
+
+		for _, r := range rs {
+			if err := tx.lockRow(tbl, r.RID, mode); err != nil {
+				return nil, err
+			}
+
+			latest, err := readRecord(tDef, r.RID)
+			if err != nil {
+				return nil, err
+			}
+
+			if latest != nil {
+				if err := tx.checkWrite(latest.Version); err != nil {
+					return nil, err
+				}
+			}
+		}
+		return rs, nil
+	}
+}
+
+// OpProjection picks up the columns in the select list. The columns are renamed to the aliases of the items.
+func OpProjection(items []*SelectItem) func(rs []*Record) ([]*Record, error) {
 	return func(rs []*Record) ([]*Record, error) {
-		if cols[0] == "*" {
+		if items[0].Column == "*" || len(rs) == 0 {
 			return rs, nil
 		}
 
 		// First, find which column (index) must be picked up in result
 		r := rs[0]
 		indices := []int{}
-		for i, col := range r.Cols {
-			for _, targetCol := range cols {
-				if col == targetCol {
-					indices = append(indices, i)
-					break
-				}
+		aliases := map[int]string{}
+		for _, item := range items {
+			i, err := r.ColIndex(item.Column)
+			if err != nil {
+				return nil, err
+			}
+			indices = append(indices, i)
+			if item.Alias != "" {
+				aliases[i] = item.Alias
 			}
 		}
 
@@ -144,14 +1276,23 @@ func OpProjection(cols []string) func(rs []*Record) ([]*Record, error) {
 			for i := range r.Cols {
 				if Contains(indices, i) {
 					r.Cols[n] = r.Cols[i]
+					if alias, ok := aliases[i]; ok {
+						r.Cols[n] = alias
+					}
 					r.Types[n] = r.Types[i]
 					r.Vals[n] = r.Vals[i]
+					if r.Tables != nil {
+						r.Tables[n] = r.Tables[i]
+					}
 					n++
 				}
 			}
 			r.Cols = r.Cols[:n]
 			r.Types = r.Types[:n]
 			r.Vals = r.Vals[:n]
+			if r.Tables != nil {
+				r.Tables = r.Tables[:n]
+			}
 		}
 
 		return rs, nil
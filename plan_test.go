@@ -0,0 +1,171 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestChooseIndex(t *testing.T) {
+	tDef := &CtTable{
+		Name: "tbl",
+		Cols: []*CtCol{
+			{Name: "id", Type: TypeInt},
+			{Name: "name", Type: TypeString},
+			{Name: "price", Type: TypeFloat},
+			{Name: "tenant", Type: TypeInt},
+		},
+		Indexes: []*CtIndex{
+			{Name: "tblprice", Cols: []string{"price"}},
+			{Name: "tbl_pkey", Cols: []string{"id"}, Primary: true, Unique: true},
+			{Name: "tbltenant", Cols: []string{"tenant", "id", "name"}},
+		},
+	}
+
+	eq := func(vals ...any) keyRange { return keyRange{Eq: vals} }
+
+	tests := []struct {
+		query    string
+		expected *IndexScan
+	}{
+		{
+			query:    "select * from tbl where id = 1",
+			expected: &IndexScan{Index: "tbl_pkey", Range: eq(int64(1))},
+		},
+		{
+			query:    "select * from tbl where '3' >= id and name = 'a'",
+			expected: &IndexScan{Index: "tbl_pkey", Range: keyRange{High: int64(3), HighIncl: true}},
+		},
+		{
+			query:    "select * from tbl where price > 1 and price >= 2 and price < 10 and price <= 10",
+			expected: &IndexScan{Index: "tblprice", Range: keyRange{Low: int64(2), LowIncl: true, High: int64(10)}},
+		},
+		{
+			// the equality is preferred
+			query:    "select * from tbl where price between 1 and 5 and id = 2",
+			expected: &IndexScan{Index: "tbl_pkey", Range: eq(int64(2))},
+		},
+		{
+			// int is comparable with float
+			query:    "select * from tbl where price < 5",
+			expected: &IndexScan{Index: "tblprice", Range: keyRange{High: int64(5)}},
+		},
+		{
+			// the longer prefix is preferred
+			query:    "select * from tbl where id = 1 and tenant = 2",
+			expected: &IndexScan{Index: "tbltenant", Range: eq(int64(2), int64(1))},
+		},
+		{
+			query:    "select * from tbl where tenant = 2 and id > 1 and name = 'a'",
+			expected: &IndexScan{Index: "tbltenant", Range: keyRange{Eq: []any{int64(2)}, Low: int64(1)}},
+		},
+		{
+			query:    "select name from tbl where tenant = 2 and id = 1 and name = 'a'",
+			expected: &IndexScan{Index: "tbltenant", Range: eq(int64(2), int64(1), "a"), Covering: true},
+		},
+		{
+			// the column after the prefix is not used
+			query:    "select * from tbl where tenant = 2 and name = 'a'",
+			expected: &IndexScan{Index: "tbltenant", Range: eq(int64(2))},
+		},
+		{
+			query:    "select * from tbl where tenant = 2 order by id desc",
+			expected: &IndexScan{Index: "tbltenant", Range: eq(int64(2)), Ordered: true, Desc: true},
+		},
+		{
+			query:    "select * from tbl where tenant = 2 order by name",
+			expected: &IndexScan{Index: "tbltenant", Range: eq(int64(2))},
+		},
+		{
+			query:    "select id from tbl order by tenant",
+			expected: &IndexScan{Index: "tbltenant", Ordered: true, Covering: true},
+		},
+		{
+			query:    "select id from tbl where id in (1, 2) order by id",
+			expected: &IndexScan{Index: "tbl_pkey", Ordered: true, Covering: true},
+		},
+		{
+			query:    "select price from tbl where price > 1",
+			expected: &IndexScan{Index: "tblprice", Range: keyRange{Low: int64(1)}, Covering: true},
+		},
+		{
+			query:    "select t.name from tbl t where t.tenant = 2 and tbl.id = 1 order by t.id",
+			expected: &IndexScan{Index: "tbltenant", Range: eq(int64(2)), Ordered: true},
+		},
+		{
+			// the subquery may refer the columns not in the index
+			query:    "select id from tbl where id = 1 and exists (select * from tbl2 where tbl2.id = tbl.price)",
+			expected: &IndexScan{Index: "tbl_pkey", Range: eq(int64(1))},
+		},
+		{
+			query:    "select * from tbl where tenant = 2 order by tenant, id, name",
+			expected: &IndexScan{Index: "tbltenant", Range: eq(int64(2)), Ordered: true},
+		},
+		{
+			query:    "select * from tbl order by tenant desc, id desc nulls first",
+			expected: &IndexScan{Index: "tbltenant", Ordered: true, Desc: true},
+		},
+		{
+			query:    "select * from tbl where tenant = 2 order by id desc, name",
+			expected: &IndexScan{Index: "tbltenant", Range: eq(int64(2))},
+		},
+		{query: "select * from tbl order by tenant nulls first"},
+		{query: "select * from tbl order by tenant, name"},
+		{query: "select name as tenant from tbl order by tenant"},
+		{query: "select * from tbl order by 4"},
+		{query: "select * from tbl join tbl2 on tbl.id = tbl2.id where tbl.id = 1"},
+		{query: "select * from tbl"},
+		{query: "select * from tbl order by name"},
+		{query: "select * from tbl where id = 1 or price = 2"},
+		{query: "select * from tbl where not id = 1"},
+		{query: "select * from tbl where id != 1"},
+		{query: "select * from tbl where id = null"},
+		{query: "select * from tbl where id = 'x'"},
+		{query: "select * from tbl where id = true"},
+		{query: "select * from tbl where id = price"},
+		{query: "select * from tbl where name = 'a'"},
+	}
+
+	for _, tc := range tests {
+		q, err := parse(tc.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if scan := chooseIndex(tDef, q.Select); !reflect.DeepEqual(scan, tc.expected) {
+			t.Fatalf("[%s] unexpected index scan: got: %+v, expected: %+v", tc.query, scan, tc.expected)
+		}
+	}
+}
+
+func TestEquiJoinColumns(t *testing.T) {
+	left := &Record{Cols: []string{"id", "name", "dept"}, Types: []string{TypeInt, TypeString, TypeInt}, Tables: []string{"e", "e", "e"}}
+	right := &Record{Cols: []string{"id", "name"}, Types: []string{TypeInt, TypeString}, Tables: []string{"d", "d"}}
+
+	tests := []struct {
+		on    string
+		lcols []int
+		rcols []int
+	}{
+		{on: "e.dept = d.id", lcols: []int{2}, rcols: []int{0}},
+		{on: "d.id = dept and e.name = d.name", lcols: []int{2, 1}, rcols: []int{0, 1}},
+		{on: "e.dept = d.id or e.id = d.id"},
+		{on: "e.dept < d.id"},
+		{on: "e.id = e.dept"},
+		// ambiguous
+		{on: "id = d.id"},
+		// different types
+		{on: "e.dept = d.name"},
+	}
+
+	for _, tc := range tests {
+		q, err := parse("select * from e join d on " + tc.on)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		lcols, rcols := equiJoinColumns(q.Select.Joins[0].On, left, right)
+		if !reflect.DeepEqual(lcols, tc.lcols) || !reflect.DeepEqual(rcols, tc.rcols) {
+			t.Fatalf("[%s] unexpected columns: got: %v/%v, expected: %v/%v", tc.on, lcols, rcols, tc.lcols, tc.rcols)
+		}
+	}
+}
@@ -1,34 +1,93 @@
 package main
 
+import (
+	"fmt"
+	"strings"
+)
+
 type Record struct {
+	RID RID
+
+	// Version is the header of the tuple which tells the transactions the record is visible to.
+	// This is set only on the records read from the heap file.
+	Version Version
+
 	Cols  []string
 	Types []string
-	Vals  []string
+	Vals  []any
+
+	// Tables is the table name (or its alias) of each column to resolve the qualified column references.
+	// This is set only while the select is executed.
+	Tables []string
+
+	// Outer is the record of the outer query on which the correlated subquery is evaluated.
+	// The columns which are not found in the record are looked up in it.
+	Outer *Record
+
+	// Aggregates is the values of the aggregate functions on the group, keyed by the function (e.g. "count(*)").
+	// This is set only on the record of a group to evaluate the having condition.
+	Aggregates map[string]any
+}
+
+// columnNotFoundError is returned if the referred column is not found in the record.
+type columnNotFoundError struct {
+	col string
+}
+
+func (e *columnNotFoundError) Error() string {
+	return fmt.Sprintf("column '%s' is not found", e.col)
+}
+
+func (r *Record) Clone() *Record {
+	return &Record{
+		RID:     r.RID,
+		Version: r.Version,
+		Cols:    append(r.Cols[:0:0], r.Cols...),
+		Types:   append(r.Types[:0:0], r.Types...),
+		Vals:    append(r.Vals[:0:0], r.Vals...),
+		Tables:  append(r.Tables[:0:0], r.Tables...),
+		Outer:   r.Outer,
+	}
 }
 
-func (r *Record) ColIndex(col string) int {
+// ColIndex returns the position of the column. The column can be qualified by the table name (e.g. "tbl.col").
+// An error is returned if the column is not found, or the unqualified column is found in multiple tables.
+func (r *Record) ColIndex(col string) (int, error) {
+	tbl, name, qualified := strings.Cut(col, ".")
+	if !qualified {
+		tbl, name = "", col
+	}
+
+	index := -1
 	for i := range r.Cols {
-		if r.Cols[i] == col {
-			return i
+		if r.Cols[i] != name {
+			continue
+		}
+
+		if qualified && (i >= len(r.Tables) || r.Tables[i] != tbl) {
+			continue
 		}
+
+		if index >= 0 {
+			return -1, fmt.Errorf("column reference '%s' is ambiguous", col)
+		}
+		index = i
 	}
-	return -1
-}
 
-func (r *Record) Value(col string) string {
-	index := r.ColIndex(col)
 	if index < 0 {
-		return ""
+		return -1, &columnNotFoundError{col: col}
 	}
 
-	return r.Vals[index]
+	return index, nil
 }
 
-func (r *Record) Find(col, key string) bool {
-	index := r.ColIndex(col)
-	if index < 0 {
-		return false
+// Join returns the record which has the columns of r and then other. The outer record of r is taken over.
+func (r *Record) Join(other *Record) *Record {
+	return &Record{
+		Cols:   append(r.Cols[:len(r.Cols):len(r.Cols)], other.Cols...),
+		Types:  append(r.Types[:len(r.Types):len(r.Types)], other.Types...),
+		Vals:   append(r.Vals[:len(r.Vals):len(r.Vals)], other.Vals...),
+		Tables: append(r.Tables[:len(r.Tables):len(r.Tables)], other.Tables...),
+		Outer:  r.Outer,
 	}
-
-	return r.Vals[index] == key
 }
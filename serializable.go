@@ -0,0 +1,244 @@
+package main
+
+import (
+	"errors"
+	"maps"
+	"slices"
+	"sync"
+)
+
+// Serializable transactions are run in snapshot isolation with the read/write dependencies among them tracked
+// (serializable snapshot isolation). When a transaction reads what a concurrent one writes, the reader must come
+// before the writer in any serial order, which is called a rw-conflict from the reader to the writer.
+// Every anomaly of snapshot isolation (e.g. write skew) has a transaction with both incoming and outgoing
+// rw-conflicts, so the transaction whose read or write would make such one gets errSerializationFailure.
+//
+// The reads are tracked by the records fetched by rid and the key ranges of the index scans, and by table only if
+// the whole table is scanned. The writes are tracked by the rid and the values of the versions created or deleted,
+// so a write is in the key range read by another even if the record is inserted after the read.
+// The transactions scanning the whole table can be aborted even if they never see the same record.
+// The aborted transaction can succeed on retry.
+//
+// Two transactions are concurrent if neither sees the changes of the other. The committed transactions are tracked
+// until no serializable transaction in progress is concurrent with them.
+
+// sxact is the dependency tracking state of a serializable transaction.
+type sxact struct {
+	tx *Tx
+
+	// tables, rows and ranges are what the transaction reads: the tables scanned as a whole, the records fetched
+	// by rid and the key ranges of the index scans. writes is the values of the versions it writes by rid.
+	tables map[string]bool
+	rows   map[lockKey]bool
+	ranges []*rangeRead
+	writes map[lockKey][]any
+
+	in, out   bool // has rw-conflicts from/to another transaction
+	committed bool
+}
+
+// rangeRead is the key range of the index scanned in the table.
+type rangeRead struct {
+	tbl  string
+	cols []int // position of the indexed columns in the table
+	r    keyRange
+}
+
+// contains returns true if the index key of the values is in the range.
+// The values written in another table definition can lack the indexed columns, then they are taken as in the range.
+func (rr *rangeRead) contains(vals []any) bool {
+	key := make([]any, len(rr.cols))
+	for i, c := range rr.cols {
+		if c >= len(vals) {
+			return true
+		}
+		key[i] = vals[c]
+	}
+	return rr.r.Contains(key)
+}
+
+// reads returns true if the transaction has read the version of the values at the rid in the table.
+func (sx *sxact) reads(key lockKey, vals []any) bool {
+	if sx.tables[key.tbl] || sx.rows[key] {
+		return true
+	}
+
+	return slices.ContainsFunc(sx.ranges, func(rr *rangeRead) bool { return rr.tbl == key.tbl && rr.contains(vals) })
+}
+
+// sxacts is the serializable transactions tracked. It is protected by ssiMu.
+// If txMu is needed with ssiMu, ssiMu must be locked first.
+var (
+	sxacts = map[*Tx]*sxact{}
+	ssiMu  sync.Mutex
+)
+
+var errSerializationFailure = errors.New("could not serialize access due to read/write dependencies among transactions")
+
+// trackSerializable starts or stops tracking the dependencies of the transaction.
+func trackSerializable(tx *Tx, on bool) {
+	ssiMu.Lock()
+	defer ssiMu.Unlock()
+
+	if !on {
+		delete(sxacts, tx)
+		return
+	}
+
+	if _, ok := sxacts[tx]; !ok {
+		sxacts[tx] = &sxact{tx: tx, tables: map[string]bool{}, rows: map[lockKey]bool{}, writes: map[lockKey][]any{}}
+	}
+}
+
+// readTable records that the serializable transaction reads every record in the table.
+// errSerializationFailure is returned if the read makes a dangerous structure of rw-conflicts.
+func (tx *Tx) readTable(tbl string) error {
+	return tx.read(
+		func(sx *sxact) { sx.tables[tbl] = true },
+		func(key lockKey, vals []any) bool { return key.tbl == tbl },
+	)
+}
+
+// readRows records that the serializable transaction fetches the records at the rids in the table.
+// errSerializationFailure is returned if the read makes a dangerous structure of rw-conflicts.
+func (tx *Tx) readRows(tbl string, rids []RID) error {
+	keys := map[lockKey]bool{}
+	for _, rid := range rids {
+		keys[lockKey{tbl: tbl, rid: rid}] = true
+	}
+
+	return tx.read(
+		func(sx *sxact) { maps.Copy(sx.rows, keys) },
+		func(key lockKey, vals []any) bool { return keys[key] },
+	)
+}
+
+// readRange records that the serializable transaction scans the key range of the index in the table.
+// errSerializationFailure is returned if the read makes a dangerous structure of rw-conflicts.
+func (tx *Tx) readRange(tbl string, idx *Index, r keyRange) error {
+	rr := &rangeRead{tbl: tbl, cols: idx.cols, r: r}
+	return tx.read(
+		func(sx *sxact) { sx.ranges = append(sx.ranges, rr) },
+		func(key lockKey, vals []any) bool { return key.tbl == tbl && rr.contains(vals) },
+	)
+}
+
+// read records the read of the serializable transaction by add. reads returns true if the version written
+// by another transaction is in the read, which makes the rw-conflict if the writer is concurrent.
+func (tx *Tx) read(add func(sx *sxact), reads func(key lockKey, vals []any) bool) error {
+	if tx.isolation != serializable {
+		return nil
+	}
+
+	ssiMu.Lock()
+	defer ssiMu.Unlock()
+
+	sx := sxacts[tx]
+	for _, other := range sxacts {
+		if other == sx || !writesAny(other, reads) || tx.sees(other.tx.ID) {
+			continue
+		}
+
+		if err := addConflict(sx, other); err != nil {
+			return err
+		}
+	}
+
+	add(sx)
+	return nil
+}
+
+// writesAny returns true if any version written by the transaction is in the read.
+func writesAny(sx *sxact, reads func(key lockKey, vals []any) bool) bool {
+	for key, vals := range sx.writes {
+		if reads(key, vals) {
+			return true
+		}
+	}
+	return false
+}
+
+// writeRecords records that the serializable transaction writes the versions of the values at the rids in the table.
+// On update, both the old and the new versions are written.
+// errSerializationFailure is returned if the write makes a dangerous structure of rw-conflicts.
+func (tx *Tx) writeRecords(tbl string, rids []RID, vals [][]any) error {
+	if tx.isolation != serializable {
+		return nil
+	}
+
+	ssiMu.Lock()
+	defer ssiMu.Unlock()
+
+	sx := sxacts[tx]
+	for _, other := range sxacts {
+		if other == sx {
+			continue
+		}
+
+		if readsAny(other, tbl, rids, vals) && !tx.sees(other.tx.ID) {
+			if err := addConflict(other, sx); err != nil {
+				return err
+			}
+		}
+	}
+
+	for i, rid := range rids {
+		sx.writes[lockKey{tbl: tbl, rid: rid}] = vals[i]
+	}
+	return nil
+}
+
+// readsAny returns true if the transaction has read any version of the values at the rids in the table.
+func readsAny(sx *sxact, tbl string, rids []RID, vals [][]any) bool {
+	for i, rid := range rids {
+		if sx.reads(lockKey{tbl: tbl, rid: rid}, vals[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// addConflict records the rw-conflict from the reader to the writer. If either of them would have both
+// incoming and outgoing rw-conflicts, nothing is recorded and errSerializationFailure is returned
+// to abort the transaction making the conflict. Callers must hold ssiMu.
+func addConflict(reader, writer *sxact) error {
+	if reader.in || writer.out {
+		return errSerializationFailure
+	}
+
+	reader.out = true
+	writer.in = true
+	return nil
+}
+
+// endSerializable stops tracking the aborted transaction, and the committed ones which are no longer concurrent
+// with any serializable transaction in progress.
+func endSerializable(tx *Tx, committed bool) {
+	ssiMu.Lock()
+	defer ssiMu.Unlock()
+
+	if sx, ok := sxacts[tx]; ok {
+		if committed {
+			sx.committed = true
+		} else {
+			delete(sxacts, tx)
+		}
+	}
+
+	for t, sx := range sxacts {
+		if sx.committed && !concurrentWithActive(sx) {
+			delete(sxacts, t)
+		}
+	}
+}
+
+// concurrentWithActive returns true if any serializable transaction in progress does not see the committed one.
+// Callers must hold ssiMu.
+func concurrentWithActive(committed *sxact) bool {
+	for _, sx := range sxacts {
+		if !sx.committed && !sx.tx.sees(committed.tx.ID) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestSerializable(t *testing.T) {
+	begin := func() *Tx {
+		tx := beginTx()
+		tx.SetIsolation(serializable)
+		t.Cleanup(tx.Rollback)
+		return tx
+	}
+
+	// write skew: tx1 -> tx2 -> tx1
+	tx1, tx2 := begin(), begin()
+	for _, tx := range []*Tx{tx1, tx2} {
+		if err := tx.readTable("t"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write := func(tx *Tx, id int64, slot uint16) error {
+		return tx.writeRecords("t", []RID{{Page: 0, Slot: slot}}, [][]any{{id}})
+	}
+
+	if err := write(tx1, 1, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := write(tx2, 2, 2); err != errSerializationFailure {
+		t.Fatalf("write skew must be detected: %v", err)
+	}
+	tx2.Rollback()
+
+	// the committed transaction is tracked while the concurrent one is in progress
+	tx3 := begin()
+	if err := tx1.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx3.readTable("t"); err != nil {
+		t.Fatal(err)
+	}
+	if !sxacts[tx3].out {
+		t.Fatalf("reading the table written by the concurrent transaction must make rw-conflict")
+	}
+
+	tx3.Rollback()
+	if _, ok := sxacts[tx1]; ok {
+		t.Fatalf("committed transaction must not be tracked after the concurrent ones end")
+	}
+
+	// the transaction beginning after the commit sees the changes, so no conflict is made
+	tx4 := begin()
+	if err := tx4.readTable("t"); err != nil {
+		t.Fatal(err)
+	}
+	if sxacts[tx4].out {
+		t.Fatalf("reading the committed changes must not make rw-conflict")
+	}
+	tx4.Rollback()
+
+	// the transactions reading and writing the disjoint key ranges do not conflict
+	idx := &Index{cols: []int{0}}
+	tx5, tx6 := begin(), begin()
+	for i, tx := range []*Tx{tx5, tx6} {
+		id := int64(i + 1)
+		if err := tx.readRange("u", idx, keyRange{Eq: []any{id}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, tx := range []*Tx{tx5, tx6} {
+		if err := tx.writeRecords("u", []RID{{Page: 0, Slot: uint16(i)}}, [][]any{{int64(i + 1)}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if sxacts[tx5].in || sxacts[tx5].out {
+		t.Fatalf("writing out of the key range read by the other must not make rw-conflict")
+	}
+
+	// the record inserted in the key range read by the other makes rw-conflict even if it is not fetched
+	if err := tx6.writeRecords("u", []RID{{Page: 0, Slot: 2}}, [][]any{{int64(1)}}); err != nil {
+		t.Fatal(err)
+	}
+	if !sxacts[tx5].out || !sxacts[tx6].in {
+		t.Fatalf("writing in the key range read by the other must make rw-conflict")
+	}
+
+	// only the write on the record fetched by rid makes rw-conflict
+	tx7, tx8 := begin(), begin()
+	if err := tx7.readRows("v", []RID{{Page: 0, Slot: 0}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx8.writeRecords("v", []RID{{Page: 0, Slot: 1}}, [][]any{{int64(1)}}); err != nil {
+		t.Fatal(err)
+	}
+	if sxacts[tx7].out {
+		t.Fatalf("writing the record not fetched by the other must not make rw-conflict")
+	}
+	if err := tx8.writeRecords("v", []RID{{Page: 0, Slot: 0}}, [][]any{{int64(1)}}); err != nil {
+		t.Fatal(err)
+	}
+	if !sxacts[tx7].out {
+		t.Fatalf("writing the record fetched by the other must make rw-conflict")
+	}
+}
+
+func TestSerializableConcurrentSavepoint(t *testing.T) {
+	// run with -race: the transactions of the other sessions are read on the end of every serializable transaction
+	tx := beginTx()
+	tx.SetIsolation(serializable)
+	t.Cleanup(tx.Rollback)
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+
+			tx.Savepoint("sp")
+			if err := tx.RollbackTo("sp"); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < 100; i++ {
+		other := beginTx()
+		other.SetIsolation(serializable)
+		if err := other.Commit(); err != nil {
+			t.Error(err)
+			break
+		}
+	}
+	close(done)
+	wg.Wait()
+}
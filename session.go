@@ -0,0 +1,281 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// A session lets a transaction block span multiple queries. The client names its session in the request,
+// and the session is created on the first query with the name. The queries without a session name are
+// run in autocommit mode: every statement is committed on success or rolled back on error.
+//
+// In a transaction block, the statements run in the transaction started by "begin" until "commit" or "rollback".
+// Once a statement fails in the block, the rest are rejected until the end of the block, and "commit" rolls it back.
+// Rolling back to a savepoint recovers the block from the failure, unless the transaction is aborted as the victim
+// of a deadlock or a serialization failure (see serializable.go).
+
+// sessionTimeout is how long an idle session is kept. Its transaction in progress is rolled back on expiry.
+const sessionTimeout = 10 * time.Minute
+
+// Session is the state of a client session. The queries of a session are run one by one under mu.
+type Session struct {
+	Name string
+
+	mu       sync.Mutex
+	tx       *Tx  // the transaction of the block, nil if no block is in progress
+	failed   bool // a statement has failed in the block
+	queried  bool // a statement has run in the block
+	lastUsed time.Time
+
+	lockTimeout time.Duration // how long a statement waits for a row lock, 0 means forever
+}
+
+// sessions is the named sessions. It is protected by sessMu.
+var (
+	sessions = map[string]*Session{}
+	sessMu   sync.Mutex
+)
+
+var errTxAborted = errors.New("current transaction is aborted, commands ignored until end of transaction block")
+
+// getSession returns the session of the name, which is created if not found. The session is locked and must be released.
+// Empty name returns a new session in autocommit mode. The idle sessions are expired here.
+func getSession(name string) *Session {
+	if name == "" {
+		s := &Session{}
+		s.mu.Lock()
+		return s
+	}
+
+	sessMu.Lock()
+	expireSessions(time.Now())
+	s, ok := sessions[name]
+	if !ok {
+		s = &Session{Name: name}
+		sessions[name] = s
+	}
+	s.lastUsed = time.Now() // not to expire while waiting for the lock
+	sessMu.Unlock()
+
+	s.mu.Lock()
+	return s
+}
+
+// release unlocks the session.
+func (s *Session) release() {
+	sessMu.Lock()
+	s.lastUsed = time.Now()
+	sessMu.Unlock()
+
+	s.mu.Unlock()
+}
+
+// expireSessions removes the sessions which have been idle for sessionTimeout, rolling back their transactions.
+// The session running a query is never expired. Callers must hold sessMu.
+func expireSessions(now time.Time) {
+	for name, s := range sessions {
+		if now.Sub(s.lastUsed) < sessionTimeout || !s.mu.TryLock() {
+			continue
+		}
+
+		if s.tx != nil {
+			s.tx.Rollback()
+			s.tx = nil
+		}
+		delete(sessions, name)
+		s.mu.Unlock()
+	}
+}
+
+// Begin starts a transaction block.
+func (s *Session) Begin() error {
+	if s.Name == "" {
+		return fmt.Errorf("transaction block needs a session")
+	}
+
+	if s.failed {
+		return errTxAborted
+	}
+
+	if s.tx != nil {
+		return fmt.Errorf("there is already a transaction in progress")
+	}
+
+	s.tx, s.queried = beginTx(), false
+	return nil
+}
+
+// Commit commits the transaction block. If a statement has failed in the block, it is rolled back instead
+// and false is returned.
+func (s *Session) Commit() (bool, error) {
+	if s.tx == nil {
+		return false, fmt.Errorf("there is no transaction in progress")
+	}
+
+	tx, failed := s.tx, s.failed
+	s.tx, s.failed = nil, false
+	if failed {
+		tx.Rollback()
+		return false, nil
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Rollback rolls back the transaction block.
+func (s *Session) Rollback() error {
+	if s.tx == nil {
+		return fmt.Errorf("there is no transaction in progress")
+	}
+
+	s.tx.Rollback()
+	s.tx, s.failed = nil, false
+	return nil
+}
+
+// Savepoint defines the savepoint in the transaction block.
+func (s *Session) Savepoint(name string) error {
+	if s.tx == nil {
+		return fmt.Errorf("savepoint can only be used in transaction blocks")
+	}
+
+	if s.failed {
+		return errTxAborted
+	}
+
+	s.tx.Savepoint(name)
+	return nil
+}
+
+// RollbackTo rolls back the transaction block to the savepoint. The block is no longer failed.
+func (s *Session) RollbackTo(name string) error {
+	if s.tx == nil {
+		return fmt.Errorf("rollback to savepoint can only be used in transaction blocks")
+	}
+
+	if s.tx.done {
+		return errTxAborted // aborted as a victim
+	}
+
+	if err := s.tx.RollbackTo(name); err != nil {
+		s.failed = true
+		return err
+	}
+
+	s.failed = false
+	return nil
+}
+
+// Release removes the savepoint in the transaction block, keeping the changes made since then.
+func (s *Session) Release(name string) error {
+	if s.tx == nil {
+		return fmt.Errorf("release savepoint can only be used in transaction blocks")
+	}
+
+	if s.failed {
+		return errTxAborted
+	}
+
+	if err := s.tx.Release(name); err != nil {
+		s.failed = true
+		return err
+	}
+	return nil
+}
+
+// SetIsolation sets the isolation level of the transaction block. It must be set before any statement runs in the block.
+func (s *Session) SetIsolation(level isolationLevel) error {
+	if s.tx == nil {
+		return fmt.Errorf("set transaction can only be used in transaction blocks")
+	}
+
+	if s.failed {
+		return errTxAborted
+	}
+
+	if s.queried {
+		s.failed = true
+		return fmt.Errorf("set transaction isolation level must be called before any query")
+	}
+
+	s.tx.SetIsolation(level)
+	return nil
+}
+
+// SetLockTimeout sets how long the statements of the session wait for a row lock. 0 means forever.
+func (s *Session) SetLockTimeout(timeout time.Duration) error {
+	if s.Name == "" {
+		return fmt.Errorf("lock timeout needs a session")
+	}
+
+	if s.failed {
+		return errTxAborted
+	}
+
+	s.lockTimeout = timeout
+	return nil
+}
+
+// Run calls exec with the transaction of the block, or with a new transaction in autocommit mode.
+// The statement which is not transactional (e.g. create table) is given its name in nonTx, and it cannot run
+// in a transaction block. Empty nonTx means the statement is transactional.
+func (s *Session) Run(nonTx string, exec func(tx *Tx) (*Result, error)) (*Result, error) {
+	if s.failed {
+		return nil, errTxAborted
+	}
+
+	if s.tx == nil {
+		tx := beginTx()
+		tx.lockTimeout = s.lockTimeout
+		tx.autocommit = true
+		result, err := exec(tx)
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+
+		if tx.done {
+			return result, nil // committed together with the changes
+		}
+
+		if err := tx.Commit(); err != nil {
+			return nil, err
+		}
+		return result, nil
+	}
+
+	if nonTx != "" {
+		s.failed = true
+		return nil, fmt.Errorf("%s cannot run inside a transaction block", nonTx)
+	}
+
+	// in read committed, every statement sees the changes committed before it begins
+	if s.tx.isolation == readCommitted {
+		s.tx.Resnapshot()
+	}
+
+	s.tx.lockTimeout = s.lockTimeout
+	s.queried = true
+	result, err := exec(s.tx)
+	if err != nil {
+		s.failed = true
+	}
+
+	// the victim of the deadlock or the dependencies is aborted at once to let the others go on
+	if errors.Is(err, errDeadlock) || errors.Is(err, errSerializationFailure) {
+		s.tx.Rollback()
+	}
+	return result, err
+}
+
+// Fail marks the transaction block failed, if in progress.
+func (s *Session) Fail() {
+	if s.tx != nil {
+		s.failed = true
+	}
+}
@@ -2,70 +2,340 @@ package main
 
 import (
 	"fmt"
-	"os"
+	"slices"
+	"sort"
+	"sync"
 )
 
-// schema
-// {
-//   "tbl1": [
-//     {"col1": "val1", "col2": "val2", "col3": "val3"},
-//     {"col1": "val4", "col2": "val5", "col3": "val6"},
-//     {"col1": "val1", "col2": "val9", "col3": "val9"},
-//   ],
-//   "tbl2": [
-//     {"col4": "val1", "col5": "val2", "col6": "val3"},
-//     {"col4": "val4", "col5": "val5", "col6": "val6"},
-//     {"col4": "val1", "col5": "val9", "col6": "val9"},
-//   ]
-// }
-var datafile = "data/incdb.data"
+// Tables are stored in heap files. See heap.go and page.go for the format.
 
-func init() {
-	// test mode
-	if os.Getenv("INCDB_TEST") == "1" {
-		datafile = "data/test.incdb.data"
+// fsm (free space map) is the free space of each page in the heap file of each table.
+// The table is missing if its heap file has never been inserted since the launch.
+var fsm = map[string][]int{}
+
+// tsMu serializes the changes on the tablespace so that WAL entries are applied in LSN order.
+// fsm is also protected by tsMu.
+var tsMu sync.Mutex
+
+// vm (visibility map) is the pages in the heap file of each table on which every tuple is visible to every transaction,
+// so that the index-only scan makes the records on them without reading the heap file.
+// A page is set by vacuum and cleared on any change. vm is kept on memory only, so no page is set after the launch
+// until vacuum runs. It is protected by vmMu, since the index scans read it without tsMu.
+var (
+	vm   = map[string]map[uint32]bool{}
+	vmMu sync.Mutex
+)
+
+// findPage returns the page in which the tuple of the given size can be put.
+// If no page has enough space, the page next to the last page is returned.
+func findPage(tbl string, size int) (uint32, error) {
+	free, ok := fsm[tbl]
+	if !ok {
+		free = []int{}
+		if err := scanHeap(heapPath(tbl), func(n uint32, p Page) error {
+			free = append(free, p.FreeSpace())
+			return nil
+		}); err != nil {
+			return 0, fmt.Errorf("build free space map: %w", err)
+		}
+		fsm[tbl] = free
 	}
 
-	// initialize file if empty
-	f, err := os.OpenFile(datafile, os.O_RDWR|os.O_CREATE, 0755)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "could not read data file for initialization: %s\n", err)
-		os.Exit(1)
+	for n := range free {
+		if free[n] >= size {
+			return uint32(n), nil
+		}
 	}
-	defer f.Close()
 
-	info, err := f.Stat()
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "could not read data file stat for initialization: %s\n", err)
-		os.Exit(1)
+	return uint32(len(free)), nil
+}
+
+func updateFsm(tbl string, n uint32, p Page) {
+	free, ok := fsm[tbl]
+	if !ok {
+		return // built on the next findPage
+	}
+
+	for int(n) >= len(free) {
+		free = append(free, pageSize)
 	}
+	free[n] = p.FreeSpace()
+	fsm[tbl] = free
+}
+
+// setAllVisible sets or clears the n-th page of the table in the visibility map.
+func setAllVisible(tbl string, n uint32, on bool) {
+	vmMu.Lock()
+	defer vmMu.Unlock()
 
-	if info.Size() != 0 {
+	if !on {
+		delete(vm[tbl], n)
 		return
 	}
 
-	if _, err := f.Write([]byte("{}")); err != nil {
-		fmt.Fprintf(os.Stderr, "could not initialize empty data file: %s\n", err)
-		os.Exit(1)
+	if _, ok := vm[tbl]; !ok {
+		vm[tbl] = map[uint32]bool{}
+	}
+	vm[tbl][n] = true
+}
+
+// allVisiblePage returns true if every tuple in the n-th page of the table is visible to every transaction.
+func allVisiblePage(tbl string, n uint32) bool {
+	vmMu.Lock()
+	defer vmMu.Unlock()
+
+	return vm[tbl][n]
+}
+
+// dropVm clears every page of the table in the visibility map.
+func dropVm(tbl string) {
+	vmMu.Lock()
+	defer vmMu.Unlock()
+
+	delete(vm, tbl)
+}
+
+// applyWal applies the change recorded in the WAL entry on the tablespace.
+// This is idempotent, so the entry which is already applied is just ignored.
+func applyWal(e *WalEntry) error {
+	switch e.Op {
+	case WalCreate:
+		// the indexes are dropped after the catalog is changed so that they are not built on the old definition
+		defer dropIndexes(e.Table)
+
+		if err := createHeap(e.Table); err != nil {
+			return err
+		}
+
+		// On redo, the table may have been altered or dropped by the later entries,
+		// but the catalog follows them when they are redone.
+		if err := putTable(e.Def); err != nil {
+			return fmt.Errorf("put table definition in catalog: %w", err)
+		}
+		return nil
+
+	case WalInsert:
+		return putTuple(e.Table, RID{Page: e.Page, Slot: e.Slot}, e.Tuple, e.LSN)
+
+	case WalUpdate:
+		// the new version can be put in the same page, so it must be put before the page LSN is updated
+		if e.To.Page == e.Page {
+			return modifyPage(e.Table, e.Page, e.LSN, func(p Page) error {
+				if err := expireTuple(p, e.Slot, e.Xid); err != nil {
+					return err
+				}
+				return putTupleInPage(e.Table, p, *e.To, e.Tuple)
+			})
+		}
+
+		if err := modifyPage(e.Table, e.Page, e.LSN, func(p Page) error { return expireTuple(p, e.Slot, e.Xid) }); err != nil {
+			return err
+		}
+		return putTuple(e.Table, *e.To, e.Tuple, e.LSN)
+
+	case WalDelete:
+		return modifyPage(e.Table, e.Page, e.LSN, func(p Page) error { return expireTuple(p, e.Slot, e.Xid) })
+
+	case WalCommit:
+		return nil // the state of the transaction is restored by restoreTransactions on recovery
+
+	case WalVacuum:
+		defer bufpool.Drop(e.Table) // the cached versions can be removed or changed
+
+		return modifyPage(e.Table, e.Page, e.LSN, func(p Page) error {
+			for _, slot := range e.ClearXmax {
+				if err := expireTuple(p, slot, 0); err != nil {
+					return err
+				}
+			}
+
+			for _, slot := range e.Slots {
+				if err := removeTupleInPage(e.Table, p, RID{Page: e.Page, Slot: slot}); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+
+	case WalAlter:
+		delete(fsm, e.Table)
+		dropVm(e.Table)
+		bufpool.Drop(e.Table)
+		defer dropIndexes(e.Table)
+		defer dropIndexes(e.Def.Name)
+
+		if e.Def.Name != e.Table {
+			if err := renameHeap(e.Table, e.Def.Name); err != nil {
+				return err
+			}
+		}
+
+		if e.DropCol != nil {
+			// the heap file is missing on redo if the table is dropped later
+			if ok, err := heapExists(e.Def.Name); err != nil {
+				return err
+			} else if ok {
+				if err := dropTupleValue(e.Def.Name, *e.DropCol, e.LSN); err != nil {
+					return fmt.Errorf("drop column values: %w", err)
+				}
+			}
+		}
+
+		// catalog is updated last so that the table is never seen with the new definition until the heap file follows it.
+		if err := replaceTable(e.Table, e.Def); err != nil {
+			return fmt.Errorf("replace table definition in catalog: %w", err)
+		}
+		return nil
+
+	case WalDrop:
+		delete(fsm, e.Table)
+		dropVm(e.Table)
+		bufpool.Drop(e.Table)
+		defer dropIndexes(e.Table)
+
+		if err := removeHeap(e.Table); err != nil {
+			return err
+		}
+
+		if err := removeTable(e.Table); err != nil {
+			return fmt.Errorf("remove table from catalog: %w", err)
+		}
+		return nil
 	}
+
+	return fmt.Errorf("unknown WAL operation: %s", e.Op)
 }
 
-func readData(tbl string) ([]*Record, error) {
-	f, err := os.OpenFile(datafile, os.O_RDONLY|os.O_CREATE, 0755)
+// dropTupleValue removes the i-th value from every tuple in the heap file unless the page has already applied the WAL entry at the LSN.
+// Tuples only shrink, so they stay in the same slots.
+func dropTupleValue(tbl string, i int, lsn uint64) error {
+	return scanHeap(heapPath(tbl), func(n uint32, p Page) error {
+		if p.LSN() >= lsn {
+			return nil // already applied
+		}
+
+		for slot := 0; slot < p.Slots(); slot++ {
+			t := p.Tuple(slot)
+			if t == nil {
+				continue
+			}
+
+			ver, vals, err := decodeHeapTuple(t)
+			if err != nil {
+				return fmt.Errorf("decode tuple at page %d slot %d: %w", n, slot, err)
+			}
+
+			if i >= len(vals) {
+				continue // the column was added after the tuple is written
+			}
+
+			t, err = encodeTuple(append(vals[:i], vals[i+1:]...))
+			if err != nil {
+				return fmt.Errorf("encode tuple: %w", err)
+			}
+			t = makeHeapTuple(ver, t)
+
+			if err := p.Delete(slot); err != nil {
+				return err
+			}
+
+			if err := p.Put(slot, t); err != nil {
+				return err
+			}
+		}
+		p.SetLSN(lsn)
+
+		return writePage(tbl, n, p)
+	})
+}
+
+// modifyPage calls fn to change the n-th page in the heap file then writes it,
+// unless the page has already applied the WAL entry at the LSN. The page is cleared in the visibility map.
+func modifyPage(tbl string, n uint32, lsn uint64, fn func(p Page) error) error {
+	p, err := readPage(tbl, n)
 	if err != nil {
-		return nil, fmt.Errorf("open tablespace file: %w", err)
+		return err
+	}
+
+	if p.LSN() >= lsn {
+		return nil // already applied
 	}
-	defer f.Close()
 
-	d := map[string][]map[string]string{}
+	// cleared before the change so that no index scan takes the changed tuple as visible
+	setAllVisible(tbl, n, false)
 
-	if err := readJsonFile(f, &d); err != nil {
-		return nil, fmt.Errorf("read file: %w", err)
+	if err := fn(p); err != nil {
+		return err
 	}
+	p.SetLSN(lsn)
 
-	t, ok := d[tbl]
-	if !ok {
-		return nil, fmt.Errorf("table '%s' not found", tbl)
+	if err := writePage(tbl, n, p); err != nil {
+		return err
+	}
+
+	updateFsm(tbl, n, p)
+	return nil
+}
+
+// putTuple puts the tuple at the rid unless the page has already applied the WAL entry at the LSN.
+func putTuple(tbl string, rid RID, tuple []byte, lsn uint64) error {
+	return modifyPage(tbl, rid.Page, lsn, func(p Page) error { return putTupleInPage(tbl, p, rid, tuple) })
+}
+
+// putTupleInPage puts the tuple in the page at the rid, and adds it in the indexes.
+func putTupleInPage(tbl string, p Page, rid RID, tuple []byte) error {
+	if err := p.Put(int(rid.Slot), tuple); err != nil {
+		return fmt.Errorf("put tuple in page %d slot %d: %w", rid.Page, rid.Slot, err)
+	}
+
+	return indexTuple(tbl, rid, tuple, true)
+}
+
+// removeTupleInPage removes the tuple from the page and the indexes. The page is compacted to reuse the space.
+func removeTupleInPage(tbl string, p Page, rid RID) error {
+	t := p.Tuple(int(rid.Slot))
+	if t == nil {
+		return fmt.Errorf("slot %d is not in use", rid.Slot)
+	}
+
+	if err := indexTuple(tbl, rid, t, false); err != nil {
+		return err
+	}
+
+	return p.Delete(int(rid.Slot))
+}
+
+// expireTuple sets xmax of the tuple in the slot of the page. 0 clears it. The tuple stays in the index.
+func expireTuple(p Page, slot uint16, xmax uint64) error {
+	t := p.Tuple(int(slot))
+	if t == nil {
+		return fmt.Errorf("slot %d is not in use", slot)
+	}
+
+	setTupleXmax(t, xmax)
+	return nil
+}
+
+// logAndApply appends the entries to WAL at once then applies them on the tablespace.
+// Callers must hold tsMu.
+func logAndApply(es ...*WalEntry) error {
+	if err := appendWal(es...); err != nil {
+		return fmt.Errorf("append WAL: %w", err)
+	}
+
+	for _, e := range es {
+		if err := applyWal(e); err != nil {
+			return fmt.Errorf("apply WAL: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// readData returns the records in the table visible to the transaction. They are read from the buffer pool if cached.
+func readData(tx *Tx, tbl string) ([]*Record, error) {
+	if err := tx.readTable(tbl); err != nil {
+		return nil, err
 	}
 
 	tDef, err := readCatalog(tbl)
@@ -73,38 +343,177 @@ func readData(tbl string) ([]*Record, error) {
 		return nil, fmt.Errorf("read table '%s' definition from catalog: %w", tbl, err)
 	}
 
-	records := make([]*Record, len(t))
-	for i := range t {
-		r := &Record{
-			Cols:  make([]string, len(tDef.Cols)),
-			Types: make([]string, len(tDef.Cols)),
-			Vals:  make([]string, len(tDef.Cols)),
+	rs, err := bufpool.Scan(tbl, func() ([]*Record, error) {
+		if ok, err := heapExists(tbl); err != nil {
+			return nil, err
+		} else if !ok {
+			return nil, fmt.Errorf("table '%s' not found", tbl)
 		}
-		for j := range tDef.Cols {
-			r.Cols[j] = tDef.Cols[j].Name
-			r.Types[j] = tDef.Cols[j].Type
-			r.Vals[j] = t[i][tDef.Cols[j].Name]
+
+		records := []*Record{}
+		if err := scanTuples(heapPath(tbl), func(rid RID, ver Version, vals []any) error {
+			records = append(records, newRecord(tDef, rid, ver, vals))
+			return nil
+		}); err != nil {
+			return nil, fmt.Errorf("scan heap: %w", err)
 		}
-		records[i] = r
+
+		return records, nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	return records, nil
+	return slices.DeleteFunc(rs, func(r *Record) bool { return !tx.Visible(r.Version) }), nil
 }
 
-func save(tbl string, cols, vals []string) error {
-	f, err := os.OpenFile(datafile, os.O_RDWR|os.O_CREATE, 0755)
+// readDataByIndex returns the records in the table found by the index scan which are visible to the transaction.
+// They are read from the buffer pool if cached, or made from the index keys if the index covers the query.
+// The index has every version of the records, so the version is checked on the record unless its page is
+// all-visible in the visibility map. Thus the index-only scan reads the heap file only for the pages changed since vacuum.
+func readDataByIndex(tx *Tx, tbl string, scan *IndexScan) ([]*Record, error) {
+	idxMu.Lock()
+	defer idxMu.Unlock()
+
+	// the catalog is read after locking so that the indexes are never built on the old definition
+	tDef, err := readCatalog(tbl)
 	if err != nil {
-		return fmt.Errorf("open tablespace file: %w", err)
+		return nil, fmt.Errorf("read table '%s' definition from catalog: %w", tbl, err)
+	}
+
+	idxs, err := loadIndexes(tDef)
+	if err != nil {
+		return nil, err
+	}
+
+	i := slices.IndexFunc(idxs, func(idx *Index) bool { return idx.Def.Name == scan.Index })
+	if i < 0 {
+		return nil, fmt.Errorf("index '%s' is not found in table '%s'", scan.Index, tbl)
+	}
+	idx := idxs[i]
+
+	// the range is read even if no key is found in it, so that the records inserted in it later make the rw-conflict
+	if err := tx.readRange(tbl, idx, scan.Range); err != nil {
+		return nil, err
+	}
+
+	type entry struct {
+		key []any
+		rid RID
+	}
+	entries := []entry{}
+	idx.Scan(&scan.Range, func(key []any, rid RID) bool {
+		entries = append(entries, entry{key: key, rid: rid})
+		return true
+	})
+
+	if !scan.Ordered {
+		sort.Slice(entries, func(i, j int) bool { return entries[i].rid.Less(entries[j].rid) })
+	} else if scan.Desc {
+		slices.Reverse(entries)
 	}
-	defer f.Close()
 
-	d := map[string][]map[string]string{}
+	records := make([]*Record, 0, len(entries))
+	fetched := []RID{}
+	for _, e := range entries {
+		// vacuum removes a tuple together with its index entries under idxMu, so the entry still refers to the tuple
+		if scan.Covering && allVisiblePage(tbl, e.rid.Page) {
+			records = append(records, idx.Record(tDef, e.rid, e.key))
+			continue
+		}
+
+		r, err := readRecord(tDef, e.rid)
+		if err != nil {
+			return nil, err
+		}
+
+		if r == nil || !tx.Visible(r.Version) {
+			continue
+		}
+		fetched = append(fetched, e.rid)
+
+		if scan.Covering {
+			r = idx.Record(tDef, e.rid, e.key)
+		}
+		records = append(records, r)
+	}
+
+	if err := tx.readRows(tbl, fetched); err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
 
-	if err := readJsonFile(f, &d); err != nil {
-		return fmt.Errorf("read file: %w", err)
+// readTargets returns the records in the table visible to the transaction on which where can be true.
+// They are read by the index scan if the condition narrows the range of an index, so that
+// the serializable transaction reads only the range instead of the whole table.
+func readTargets(tx *Tx, tDef *CtTable, where *Expr) ([]*Record, error) {
+	if where != nil {
+		slct := &Select{Items: []*SelectItem{{Column: "*"}}, Table: tDef.Name, Where: where}
+		if scan := chooseIndex(tDef, slct); scan != nil {
+			return readDataByIndex(tx, tDef.Name, scan)
+		}
 	}
 
-	if _, ok := d[tbl]; !ok {
+	return readData(tx, tDef.Name)
+}
+
+// readRecord returns the version of the record at the rid in the table. It is read from the buffer pool if cached.
+// nil is returned if the tuple is not found, which can be removed after the index scan.
+func readRecord(tDef *CtTable, rid RID) (*Record, error) {
+	return bufpool.Get(tDef.Name, rid, func() (*Record, error) {
+		p, err := readPage(tDef.Name, rid.Page)
+		if err != nil {
+			return nil, err
+		}
+
+		t := p.Tuple(int(rid.Slot))
+		if t == nil {
+			return nil, nil
+		}
+
+		ver, vals, err := decodeHeapTuple(t)
+		if err != nil {
+			return nil, fmt.Errorf("decode tuple at page %d slot %d: %w", rid.Page, rid.Slot, err)
+		}
+
+		return newRecord(tDef, rid, ver, vals), nil
+	})
+}
+
+// newRecord creates the record of the table from the version and the values in the tuple.
+// If the tuple has less values than the columns, the rest is filled by the default values.
+func newRecord(tDef *CtTable, rid RID, ver Version, vals []any) *Record {
+	r := &Record{
+		RID:     rid,
+		Version: ver,
+		Cols:    make([]string, len(tDef.Cols)),
+		Types:   make([]string, len(tDef.Cols)),
+		Vals:    make([]any, len(tDef.Cols)),
+	}
+	for j := range tDef.Cols {
+		r.Cols[j] = tDef.Cols[j].Name
+		r.Types[j] = tDef.Cols[j].Type
+		if j < len(vals) {
+			r.Vals[j] = vals[j]
+		} else {
+			r.Vals[j], _ = tDef.Cols[j].DefaultValue() // validated on create
+		}
+	}
+	return r
+}
+
+// save inserts the records into the table in the transaction. nil in the values means NULL.
+// The columns which are not given are filled by the default values.
+// Every record is validated before anything is written, then they are written in one batch of WAL entries.
+func save(tx *Tx, tbl string, cols []string, rows [][]*string) error {
+	tsMu.Lock()
+	defer tsMu.Unlock()
+
+	if ok, err := heapExists(tbl); err != nil {
+		return err
+	} else if !ok {
 		return fmt.Errorf("table '%s' not found", tbl)
 	}
 
@@ -113,73 +522,519 @@ func save(tbl string, cols, vals []string) error {
 		return fmt.Errorf("read catalog: %w", err)
 	}
 
-	r := map[string]string{}
+	records := make([][]any, len(rows))
+	tuples := make([][]byte, len(rows))
+	ver := Version{Xmin: tx.cur}
+	for i, vals := range rows {
+		if records[i], err = buildRecord(tDef, cols, vals); err != nil {
+			return err
+		}
+
+		if tuples[i], err = encodeRecord(tDef, ver, records[i]); err != nil {
+			return err
+		}
+	}
+
+	if err := checkUnique(tx, tDef, records, nil); err != nil {
+		return err
+	}
+
+	if len(rows) == 0 {
+		return nil
+	}
+
+	rids, err := placeTuples(tbl, tuples)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.writeRecords(tbl, rids, records); err != nil {
+		delete(fsm, tbl) // the space planned for the tuples is not used
+		return err
+	}
+
+	entries := make([]*WalEntry, len(tuples))
+	for i, t := range tuples {
+		entries[i] = &WalEntry{Op: WalInsert, Xid: tx.cur, Table: tbl, Page: rids[i].Page, Slot: rids[i].Slot, Tuple: t}
+	}
+
+	if err := tx.logChanges(entries...); err != nil {
+		delete(fsm, tbl) // the space planned for the tuples may not be used
+		return fmt.Errorf("insert into table '%s': %w", tbl, err)
+	}
+
+	for i, r := range records {
+		bufpool.Put(tbl, newRecord(tDef, rids[i], ver, r))
+	}
+	return nil
+}
+
+// buildRecord makes the record of the table from the values given on the columns.
+// If no column is given, the values must be given on every column.
+func buildRecord(tDef *CtTable, cols []string, vals []*string) ([]any, error) {
+	r := make([]any, len(tDef.Cols))
+	for i := range tDef.Cols {
+		v, err := tDef.Cols[i].DefaultValue()
+		if err != nil {
+			return nil, fmt.Errorf("invalid default value for column '%s': %w", tDef.Cols[i].Name, err)
+		}
+		r[i] = v
+	}
 
 	if len(cols) != 0 {
 		// in case at least one column is specified, data will be saved on the given columns
-		for i := range cols {
-			givenCol := cols[i]
-			found := false
-			for j := range tDef.Cols {
-				colDef := tDef.Cols[j]
-				// fill specified column value
-				if colDef.Name == givenCol {
-					r[colDef.Name] = vals[i]
-					found = true
-					break
-				}
+		if len(vals) != len(cols) {
+			return nil, fmt.Errorf("%d values must be passed according to the columns", len(cols))
+		}
+
+		if err := setValues(tDef, r, cols, vals); err != nil {
+			return nil, err
+		}
+		return r, nil
+	}
+
+	// if column is not specified, all the data must be given
+	if len(vals) != len(tDef.Cols) {
+		return nil, fmt.Errorf("%d values must be passed according to the table definition", len(tDef.Cols))
+	}
+
+	for i := range tDef.Cols {
+		v, err := parseLiteralValue(tDef.Cols[i].Type, vals[i])
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for column '%s': %w", tDef.Cols[i].Name, err)
+		}
+		r[i] = v
+	}
+	return r, nil
+}
+
+// placeTuples decides the rids where the tuples are put in the table. The pages are filled in order as the tuples
+// are put, and the free space map is updated for the planned tuples.
+func placeTuples(tbl string, tuples [][]byte) ([]RID, error) {
+	pages := map[uint32]Page{}
+	rids := make([]RID, len(tuples))
+	for i, t := range tuples {
+		n, err := findPage(tbl, len(t))
+		if err != nil {
+			return nil, fmt.Errorf("find page: %w", err)
+		}
+
+		p, ok := pages[n]
+		if !ok {
+			if p, err = readPage(tbl, n); err != nil {
+				return nil, fmt.Errorf("read page: %w", err)
 			}
+			pages[n] = p
+		}
+
+		rids[i] = RID{Page: n, Slot: uint16(p.NextSlot())}
+		if err := p.Put(int(rids[i].Slot), t); err != nil {
+			return nil, fmt.Errorf("put tuple in page %d: %w", n, err)
+		}
+		updateFsm(tbl, n, p)
+	}
+	return rids, nil
+}
+
+// update sets the values on the given columns of the records in the table on which where is true in the transaction.
+// If where is nil, every record is updated. The number of the updated records is returned.
+// The old versions of the records are deleted and the new versions are inserted, in one batch of WAL entries.
+func update(tx *Tx, tbl string, cols []string, vals []*string, where *Expr) (int, error) {
+	if err := lockTargets(tx, tbl, where); err != nil {
+		return 0, err
+	}
+
+	tsMu.Lock()
+	defer tsMu.Unlock()
+
+	if ok, err := heapExists(tbl); err != nil {
+		return 0, err
+	} else if !ok {
+		return 0, fmt.Errorf("table '%s' not found", tbl)
+	}
+
+	tDef, err := readCatalog(tbl)
+	if err != nil {
+		return 0, fmt.Errorf("read catalog: %w", err)
+	}
+
+	// validate the columns and values even if no record is updated
+	if err := setValues(tDef, make([]any, len(tDef.Cols)), cols, vals); err != nil {
+		return 0, err
+	}
+
+	rs, err := readTargets(tx, tDef, where)
+	if err != nil {
+		return 0, fmt.Errorf("read records: %w", err)
+	}
+	qualify(rs, tbl) // the condition can refer the columns as "tbl.col"
+
+	// every new tuple is built before anything is written,
+	// so that the table is not partially updated on an invalid value.
+	type change struct {
+		old   *Record
+		vals  []any
+		tuple []byte
+	}
+	changes := []*change{}
+	if where != nil {
+		if rs, err = OpFilter(tx, where)(rs); err != nil {
+			return 0, err
+		}
+	}
+
+	ver := Version{Xmin: tx.cur}
+	for _, rec := range rs {
+		if err := tx.checkWrite(rec.Version); err != nil {
+			return 0, err
+		}
+
+		r := append(rec.Vals[:0:0], rec.Vals...)
+		if err := setValues(tDef, r, cols, vals); err != nil {
+			return 0, err
+		}
+
+		t, err := encodeRecord(tDef, ver, r)
+		if err != nil {
+			return 0, err
+		}
+
+		changes = append(changes, &change{old: rec, vals: r, tuple: t})
+	}
+
+	// the updated records may swap their keys, so the old keys of them are not taken as duplicates
+	exclude := map[RID]bool{}
+	records := make([][]any, len(changes))
+	for i, c := range changes {
+		exclude[c.old.RID] = true
+		records[i] = c.vals
+	}
+
+	if err := checkUnique(tx, tDef, records, exclude); err != nil {
+		return 0, err
+	}
+
+	if len(changes) == 0 {
+		return 0, nil
+	}
+
+	tuples := make([][]byte, len(changes))
+	for i, c := range changes {
+		tuples[i] = c.tuple
+	}
+
+	// the old versions only get xmax set in place, so the new versions are placed as if they are inserted
+	rids, err := placeTuples(tbl, tuples)
+	if err != nil {
+		return 0, err
+	}
+
+	// both the old and the new versions are written
+	written := make([]RID, 0, len(changes)*2)
+	writtenVals := make([][]any, 0, len(changes)*2)
+	for i, c := range changes {
+		written = append(written, c.old.RID, rids[i])
+		writtenVals = append(writtenVals, c.old.Vals, c.vals)
+	}
+	if err := tx.writeRecords(tbl, written, writtenVals); err != nil {
+		delete(fsm, tbl) // the space planned for the new versions is not used
+		return 0, err
+	}
+
+	entries := make([]*WalEntry, len(changes))
+	for i, c := range changes {
+		old := c.old.RID
+		entries[i] = &WalEntry{Op: WalUpdate, Xid: tx.cur, Table: tbl, Page: old.Page, Slot: old.Slot, Tuple: c.tuple, To: &rids[i]}
+	}
+
+	if err := tx.logChanges(entries...); err != nil {
+		delete(fsm, tbl) // the space planned for the new versions may not be used
+		return 0, fmt.Errorf("update table '%s': %w", tbl, err)
+	}
+
+	for i, c := range changes {
+		bufpool.Put(tbl, newRecord(tDef, c.old.RID, Version{Xmin: c.old.Version.Xmin, Xmax: tx.cur}, c.old.Vals))
+		bufpool.Put(tbl, newRecord(tDef, rids[i], ver, c.vals))
+	}
+
+	return len(changes), nil
+}
+
+// remove deletes the records in the table on which where is true in the transaction.
+// If where is nil, every record is deleted. The number of the deleted records is returned.
+// The tuples stay in the heap file with xmax set to the transaction, which is written in one batch of WAL entries.
+func remove(tx *Tx, tbl string, where *Expr) (int, error) {
+	if err := lockTargets(tx, tbl, where); err != nil {
+		return 0, err
+	}
+
+	tsMu.Lock()
+	defer tsMu.Unlock()
+
+	if ok, err := heapExists(tbl); err != nil {
+		return 0, err
+	} else if !ok {
+		return 0, fmt.Errorf("table '%s' not found", tbl)
+	}
+
+	tDef, err := readCatalog(tbl)
+	if err != nil {
+		return 0, fmt.Errorf("read catalog: %w", err)
+	}
+
+	rs, err := readTargets(tx, tDef, where)
+	if err != nil {
+		return 0, fmt.Errorf("read records: %w", err)
+	}
+	qualify(rs, tbl) // the condition can refer the columns as "tbl.col"
+
+	// the condition is evaluated on every record before anything is deleted,
+	// so that the table is not partially deleted on an invalid condition.
+	if where != nil {
+		if rs, err = OpFilter(tx, where)(rs); err != nil {
+			return 0, err
+		}
+	}
+
+	for _, rec := range rs {
+		if err := tx.checkWrite(rec.Version); err != nil {
+			return 0, err
+		}
+	}
+
+	if len(rs) == 0 {
+		return 0, nil
+	}
+
+	rids := make([]RID, len(rs))
+	vals := make([][]any, len(rs))
+	entries := make([]*WalEntry, len(rs))
+	for i, rec := range rs {
+		rids[i], vals[i] = rec.RID, rec.Vals
+		entries[i] = &WalEntry{Op: WalDelete, Xid: tx.cur, Table: tbl, Page: rec.RID.Page, Slot: rec.RID.Slot}
+	}
+
+	if err := tx.writeRecords(tbl, rids, vals); err != nil {
+		return 0, err
+	}
+
+	if err := tx.logChanges(entries...); err != nil {
+		return 0, fmt.Errorf("delete from table '%s': %w", tbl, err)
+	}
+
+	for _, rec := range rs {
+		bufpool.Put(tbl, newRecord(tDef, rec.RID, Version{Xmin: rec.Version.Xmin, Xmax: tx.cur}, rec.Vals))
+	}
+
+	return len(rs), nil
+}
 
-			// Column not found in table definition
-			// This means the column name is incorrect
-			if !found {
-				return fmt.Errorf("column '%s' is not found in table '%s'", givenCol, tbl)
+// lockTargets takes the exclusive row locks of the records in the table on which where is true,
+// so that the records are changed after the transactions holding their locks end.
+// In read committed, the snapshot is retaken if any record is changed while waiting, and the new versions are locked.
+// This must be called before tsMu is locked, because the holders need it to end.
+func lockTargets(tx *Tx, tbl string, where *Expr) error {
+	if ok, err := heapExists(tbl); err != nil {
+		return err
+	} else if !ok {
+		return fmt.Errorf("table '%s' not found", tbl)
+	}
+
+	tDef, err := readCatalog(tbl)
+	if err != nil {
+		return fmt.Errorf("read catalog: %w", err)
+	}
+
+	for {
+		rs, err := readTargets(tx, tDef, where)
+		if err != nil {
+			return fmt.Errorf("read records: %w", err)
+		}
+		qualify(rs, tbl)
+
+		if where != nil {
+			if rs, err = OpFilter(tx, where)(rs); err != nil {
+				return err
 			}
 		}
-	} else {
-		// if column is not specified, all the data must be given
-		if len(vals) != len(tDef.Cols) {
-			return fmt.Errorf("%d values must be passed according to the table definition", len(tDef.Cols))
+
+		changed := false
+		for _, r := range rs {
+			if err := tx.lockRow(tbl, r.RID, lockExclusive); err != nil {
+				return err
+			}
+
+			latest, err := readRecord(tDef, r.RID)
+			if err != nil {
+				return err
+			}
+			changed = changed || latest != nil && tx.checkWrite(latest.Version) != nil
 		}
 
-		for i := range tDef.Cols {
-			r[tDef.Cols[i].Name] = vals[i]
+		if !changed || tx.isolation != readCommitted {
+			return nil
 		}
+		tx.Resnapshot()
 	}
+}
 
-	d[tbl] = append(d[tbl], r)
+// setValues parses the literals and sets them in the record on the given columns.
+func setValues(tDef *CtTable, r []any, cols []string, vals []*string) error {
+	for i := range cols {
+		givenCol := cols[i]
+		found := false
+		for j := range tDef.Cols {
+			colDef := tDef.Cols[j]
+			// fill specified column value
+			if colDef.Name == givenCol {
+				v, err := parseLiteralValue(colDef.Type, vals[i])
+				if err != nil {
+					return fmt.Errorf("invalid value for column '%s': %w", colDef.Name, err)
+				}
+				r[j] = v
+				found = true
+				break
+			}
+		}
 
-	if err := updateJsonFile(f, &d); err != nil {
-		return fmt.Errorf("update tablespace file: %w", err)
+		// Column not found in table definition
+		// This means the column name is incorrect
+		if !found {
+			return fmt.Errorf("column '%s' is not found in table '%s'", givenCol, tDef.Name)
+		}
 	}
 
-	f.Sync()
 	return nil
 }
 
-func createTable(tbl string) error {
-	f, err := os.OpenFile(datafile, os.O_RDWR|os.O_CREATE, 0755)
+// encodeRecord checks the constraints on the record values, then encodes them into a tuple of the version.
+func encodeRecord(tDef *CtTable, ver Version, r []any) ([]byte, error) {
+	for i := range tDef.Cols {
+		if tDef.Cols[i].NotNull && r[i] == nil {
+			return nil, fmt.Errorf("column '%s' must not be null", tDef.Cols[i].Name)
+		}
+	}
+
+	t, err := encodeTuple(r)
+	if err != nil {
+		return nil, fmt.Errorf("encode record: %w", err)
+	}
+	t = makeHeapTuple(ver, t)
+
+	if len(t) > maxTupleSize {
+		return nil, fmt.Errorf("record is too large: %d bytes (must be less than %d bytes)", len(t), maxTupleSize)
+	}
+
+	return t, nil
+}
+
+// dropTable removes the table from the catalog and the tablespace.
+// false is returned without error if the table does not exist and ifExists is true.
+func dropTable(tbl string, ifExists bool) (bool, error) {
+	tsMu.Lock()
+	defer tsMu.Unlock()
+
+	heap, err := heapExists(tbl)
 	if err != nil {
-		return fmt.Errorf("open tablespace file: %w", err)
+		return false, err
 	}
-	defer f.Close()
 
-	d := map[string][]map[string]string{}
+	// the catalog entry may be left without the heap file if the create failed in the middle
+	_, err = readCatalog(tbl)
+	if !heap && err != nil {
+		if ifExists {
+			return false, nil
+		}
+		return false, fmt.Errorf("table '%s' not found", tbl)
+	}
 
-	if err := readJsonFile(f, &d); err != nil {
-		return fmt.Errorf("read tablespace file: %w", err)
+	if err := logAndApply(&WalEntry{Op: WalDrop, Table: tbl}); err != nil {
+		return false, fmt.Errorf("drop table '%s': %w", tbl, err)
 	}
 
-	if _, ok := d[tbl]; ok {
-		return fmt.Errorf("table '%s' already exists", tbl)
+	return true, nil
+}
+
+// alterTable changes the table definition by alter. alter returns the new definition and,
+// if a column is dropped, the index of the column so that its values are removed from the tuples.
+func alterTable(tbl string, alter func(tDef *CtTable) (*CtTable, *int, error)) error {
+	tsMu.Lock()
+	defer tsMu.Unlock()
+
+	if ok, err := heapExists(tbl); err != nil {
+		return err
+	} else if !ok {
+		return fmt.Errorf("table '%s' not found", tbl)
 	}
 
-	d[tbl] = []map[string]string{}
+	tDef, err := readCatalog(tbl)
+	if err != nil {
+		return fmt.Errorf("read catalog: %w", err)
+	}
 
-	if err := updateJsonFile(f, &d); err != nil {
-		return fmt.Errorf("update tablespace file: %w", err)
+	newDef, dropCol, err := alter(tDef)
+	if err != nil {
+		return err
+	}
+
+	if err := validateTable(newDef); err != nil {
+		return err
+	}
+
+	if newDef.Name != tbl {
+		if ok, err := heapExists(newDef.Name); err != nil {
+			return err
+		} else if ok {
+			return fmt.Errorf("table '%s' already exists", newDef.Name)
+		}
+
+		if _, err := readCatalog(newDef.Name); err == nil {
+			return fmt.Errorf("table '%s' already exists in catalog", newDef.Name)
+		}
+	}
+
+	if err := logAndApply(&WalEntry{Op: WalAlter, Table: tbl, Def: newDef, DropCol: dropCol}); err != nil {
+		return fmt.Errorf("alter table '%s': %w", tbl, err)
 	}
 
-	f.Sync()
 	return nil
 }
+
+// createTable creates the table in the catalog and the tablespace.
+// false is returned without error if the table already exists and ifNotExists is true.
+//
+// The catalog and the heap file are changed on applying the WAL entry,
+// so either both or none of them have the table even if the process dies in the middle.
+func createTable(tDef *CtTable, ifNotExists bool) (bool, error) {
+	tsMu.Lock()
+	defer tsMu.Unlock()
+
+	if err := validateTable(tDef); err != nil {
+		return false, err
+	}
+
+	// the catalog entry without the heap file is overwritten
+	if ok, err := heapExists(tDef.Name); err != nil {
+		return false, err
+	} else if ok {
+		if ifNotExists {
+			return false, nil
+		}
+		return false, fmt.Errorf("table '%s' already exists", tDef.Name)
+	}
+
+	e := &WalEntry{Op: WalCreate, Table: tDef.Name, Def: tDef}
+	if err := appendWal(e); err != nil {
+		return false, fmt.Errorf("create table '%s': append WAL: %w", tDef.Name, err)
+	}
+
+	if err := applyWal(e); err != nil {
+		// roll back the half-applied creation so that no orphaned catalog entry or heap file is left
+		if rerr := logAndApply(&WalEntry{Op: WalDrop, Table: tDef.Name}); rerr != nil {
+			return false, fmt.Errorf("create table '%s': %w (rollback failed: %v)", tDef.Name, err, rerr)
+		}
+		return false, fmt.Errorf("create table '%s': %w", tDef.Name, err)
+	}
+
+	return true, nil
+}
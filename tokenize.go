@@ -13,29 +13,31 @@ type Token struct {
 	IVal int // active only if Type is TkInt
 }
 
+// String returns the token and the following ones. They are written in a loop, so the long list is formatted in linear time.
 func (tk *Token) String() string {
-	if tk == nil {
-		return "(end)"
-	}
-
-	if tk.Type == TkInt {
-		return fmt.Sprintf(`"%v" (%s) -- %s`, tk.IVal, string(tk.Type), tk.Next.String())
-	}
-
-	if tk.Type == TkSymbol || tk.Type == TkStr {
-		return fmt.Sprintf(`"%v" (%s) -- %s`, tk.Val, string(tk.Type), tk.Next.String())
+	var sb strings.Builder
+	for t := tk; t != nil; t = t.Next {
+		switch t.Type {
+		case TkInt:
+			fmt.Fprintf(&sb, `"%v" (%s) -- `, t.IVal, string(t.Type))
+		case TkSymbol, TkStr, TkFloat:
+			fmt.Fprintf(&sb, `"%v" (%s) -- `, t.Val, string(t.Type))
+		default:
+			fmt.Fprintf(&sb, "(%s) -- ", string(t.Type))
+		}
 	}
-
-	return fmt.Sprintf("(%s) -- %s", string(tk.Type), tk.Next.String())
+	sb.WriteString("(end)")
+	return sb.String()
 }
 
 type TkType string
 
 const (
 	// Select
-	TkSelect = TkType("select")
-	TkFrom   = TkType("from")
-	TkWhere  = TkType("where")
+	TkSelect   = TkType("select")
+	TkDistinct = TkType("distinct")
+	TkFrom     = TkType("from")
+	TkWhere    = TkType("where")
 
 	TkLimit  = TkType("limit")
 	TkOffset = TkType("offset")
@@ -44,24 +46,92 @@ const (
 	TkBy    = TkType("by")
 	TkAsc   = TkType("asc")
 	TkDesc  = TkType("desc")
+	TkNulls = TkType("nulls")
+
+	TkGroup  = TkType("group")
+	TkHaving = TkType("having")
+
+	TkAs    = TkType("as")
+	TkJoin  = TkType("join")
+	TkInner = TkType("inner")
+	TkLeft  = TkType("left")
+	TkOuter = TkType("outer")
+	TkCross = TkType("cross")
+
+	TkFor = TkType("for")
 
 	// Insert
 	TkInsert = TkType("insert")
 	TkInto   = TkType("into")
 	TkValues = TkType("values")
 
+	// Update
+	TkUpdate = TkType("update")
+	TkSet    = TkType("set")
+
+	// Delete
+	TkDelete = TkType("delete")
+
 	// Create
 	TkCreate = TkType("create")
 	TkTable  = TkType("table")
 
+	// Index
+	TkIndex = TkType("index")
+	TkOn    = TkType("on")
+
+	// Drop/Alter
+	TkDrop   = TkType("drop")
+	TkAlter  = TkType("alter")
+	TkAdd    = TkType("add")
+	TkColumn = TkType("column")
+	TkRename = TkType("rename")
+	TkTo     = TkType("to")
+	TkIf     = TkType("if")
+	TkExists = TkType("exists")
+
+	// Checkpoint
+	TkCheckpoint = TkType("checkpoint")
+
+	// Vacuum
+	TkVacuum = TkType("vacuum")
+
+	// Transaction
+	TkBegin     = TkType("begin")
+	TkCommit    = TkType("commit")
+	TkRollback  = TkType("rollback")
+	TkSavepoint = TkType("savepoint")
+	TkRelease   = TkType("release")
+
 	// Data types
-	TkString = TkType("string")
+	TkString    = TkType("string")
+	TkIntType   = TkType("int")
+	TkFloatType = TkType("float")
+	TkBool      = TkType("bool")
+	TkTimestamp = TkType("timestamp")
 
 	// arbitrary string but not surrounded by quote (e.g. table, column)
 	TkSymbol = TkType("symbol")
 
-	TkStr = TkType("string value")
-	TkInt = TkType("integer value")
+	TkStr   = TkType("string value")
+	TkInt   = TkType("integer value")
+	TkFloat = TkType("float value") // Val holds the literal
+	TkTrue  = TkType("true")
+	TkFalse = TkType("false")
+	TkNull  = TkType("null")
+
+	// Constraints and predicates
+	TkAnd     = TkType("and")
+	TkOr      = TkType("or")
+	TkNot     = TkType("not")
+	TkIs      = TkType("is")
+	TkDefault = TkType("default")
+	TkPrimary = TkType("primary")
+	TkKey     = TkType("key")
+	TkUnique  = TkType("unique")
+	TkLike    = TkType("like")
+	TkIn      = TkType("in")
+	TkBetween = TkType("between")
 
 	// Symbols
 	TkLParen      = TkType("(")
@@ -69,6 +139,10 @@ const (
 	TkComma       = TkType(",")
 	TkEqual       = TkType("=")
 	TkNotEqual    = TkType("!=")
+	TkLess        = TkType("<")
+	TkLessEqual   = TkType("<=")
+	TkGreater     = TkType(">")
+	TkGreaterEq   = TkType(">=")
 	TkExclamation = TkType("!")
 	TkStar        = TkType("*")
 
@@ -154,13 +228,59 @@ func tokenize(query string) *Token {
 			} else {
 				cur.Next = &Token{Type: TkExclamation}
 			}
+
+		case '<':
+			i++
+			if i < len(query) && query[i] == '=' {
+				i++
+				cur.Next = &Token{Type: TkLessEqual}
+			} else {
+				cur.Next = &Token{Type: TkLess}
+			}
+
+		case '>':
+			i++
+			if i < len(query) && query[i] == '=' {
+				i++
+				cur.Next = &Token{Type: TkGreaterEq}
+			} else {
+				cur.Next = &Token{Type: TkGreater}
+			}
+
 		case '*':
 			i++
 			cur.Next = &Token{Type: TkStar}
 
+		case '-':
+			if i+1 >= len(query) || !isNumber(query[i+1]) {
+				panic("unexpected character '-'")
+			}
+			i++
+			var num *Token
+			num, i = tokenizeNumber(query, i)
+			switch num.Type {
+			case TkInt:
+				num.IVal = -num.IVal
+			case TkFloat:
+				num.Val = "-" + num.Val
+			default:
+				panic("unexpected character '-'")
+			}
+			cur.Next = num
+
+		case '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
+			cur.Next, i = tokenizeNumber(query, i)
+
 		default:
 			s := ""
 			for i < len(query) {
+				// qualified column name (e.g. "tbl.col") is a symbol
+				if query[i] == '.' && s != "" && i+1 < len(query) && isAlphabet(query[i+1]) {
+					s += "."
+					i++
+					continue
+				}
+
 				// Some RDB allows using symbol characters in column/table name,
 				// but incdb does not to reduce implementation complexity.
 				if !isAlphabet(query[i]) && !isNumber(query[i]) {
@@ -170,14 +290,15 @@ func tokenize(query string) *Token {
 				i++
 			}
 
-			if i, err := strconv.ParseInt(s, 10, 64); err == nil {
-				cur.Next = &Token{Type: TkInt, IVal: int(i)}
-				break
+			if s == "" {
+				panic(fmt.Sprintf("unexpected character '%c'", query[i]))
 			}
 
 			switch strings.ToLower(s) {
 			case "select":
 				cur.Next = &Token{Type: TkSelect}
+			case "distinct":
+				cur.Next = &Token{Type: TkDistinct}
 			case "from":
 				cur.Next = &Token{Type: TkFrom}
 			case "where":
@@ -194,6 +315,26 @@ func tokenize(query string) *Token {
 				cur.Next = &Token{Type: TkAsc}
 			case "desc":
 				cur.Next = &Token{Type: TkDesc}
+			case "nulls":
+				cur.Next = &Token{Type: TkNulls}
+			case "group":
+				cur.Next = &Token{Type: TkGroup}
+			case "having":
+				cur.Next = &Token{Type: TkHaving}
+			case "as":
+				cur.Next = &Token{Type: TkAs}
+			case "join":
+				cur.Next = &Token{Type: TkJoin}
+			case "inner":
+				cur.Next = &Token{Type: TkInner}
+			case "left":
+				cur.Next = &Token{Type: TkLeft}
+			case "outer":
+				cur.Next = &Token{Type: TkOuter}
+			case "cross":
+				cur.Next = &Token{Type: TkCross}
+			case "for":
+				cur.Next = &Token{Type: TkFor}
 
 			case "insert":
 				cur.Next = &Token{Type: TkInsert}
@@ -202,13 +343,98 @@ func tokenize(query string) *Token {
 			case "values":
 				cur.Next = &Token{Type: TkValues}
 
+			case "update":
+				cur.Next = &Token{Type: TkUpdate}
+			case "set":
+				cur.Next = &Token{Type: TkSet}
+
+			case "delete":
+				cur.Next = &Token{Type: TkDelete}
+
 			case "create":
 				cur.Next = &Token{Type: TkCreate}
 			case "table":
 				cur.Next = &Token{Type: TkTable}
 
+			case "index":
+				cur.Next = &Token{Type: TkIndex}
+			case "on":
+				cur.Next = &Token{Type: TkOn}
+
+			case "drop":
+				cur.Next = &Token{Type: TkDrop}
+			case "alter":
+				cur.Next = &Token{Type: TkAlter}
+			case "add":
+				cur.Next = &Token{Type: TkAdd}
+			case "column":
+				cur.Next = &Token{Type: TkColumn}
+			case "rename":
+				cur.Next = &Token{Type: TkRename}
+			case "to":
+				cur.Next = &Token{Type: TkTo}
+			case "if":
+				cur.Next = &Token{Type: TkIf}
+			case "exists":
+				cur.Next = &Token{Type: TkExists}
+
+			case "checkpoint":
+				cur.Next = &Token{Type: TkCheckpoint}
+
+			case "vacuum":
+				cur.Next = &Token{Type: TkVacuum}
+
+			case "begin":
+				cur.Next = &Token{Type: TkBegin}
+			case "commit":
+				cur.Next = &Token{Type: TkCommit}
+			case "rollback":
+				cur.Next = &Token{Type: TkRollback}
+			case "savepoint":
+				cur.Next = &Token{Type: TkSavepoint}
+			case "release":
+				cur.Next = &Token{Type: TkRelease}
+
 			case "string":
 				cur.Next = &Token{Type: TkString}
+			case "int":
+				cur.Next = &Token{Type: TkIntType}
+			case "float":
+				cur.Next = &Token{Type: TkFloatType}
+			case "bool":
+				cur.Next = &Token{Type: TkBool}
+			case "timestamp":
+				cur.Next = &Token{Type: TkTimestamp}
+
+			case "true":
+				cur.Next = &Token{Type: TkTrue}
+			case "false":
+				cur.Next = &Token{Type: TkFalse}
+			case "null":
+				cur.Next = &Token{Type: TkNull}
+
+			case "and":
+				cur.Next = &Token{Type: TkAnd}
+			case "or":
+				cur.Next = &Token{Type: TkOr}
+			case "not":
+				cur.Next = &Token{Type: TkNot}
+			case "is":
+				cur.Next = &Token{Type: TkIs}
+			case "default":
+				cur.Next = &Token{Type: TkDefault}
+			case "primary":
+				cur.Next = &Token{Type: TkPrimary}
+			case "key":
+				cur.Next = &Token{Type: TkKey}
+			case "unique":
+				cur.Next = &Token{Type: TkUnique}
+			case "like":
+				cur.Next = &Token{Type: TkLike}
+			case "in":
+				cur.Next = &Token{Type: TkIn}
+			case "between":
+				cur.Next = &Token{Type: TkBetween}
 
 			default:
 				cur.Next = &Token{Type: TkSymbol, Val: s}
@@ -221,3 +447,39 @@ func tokenize(query string) *Token {
 	cur.Next = &Token{Type: TkEOF}
 	return tk.Next
 }
+
+// tokenizeNumber reads integer or float literal from query[i:], then returns the token and the next index.
+// If the number is followed by alphabets, it is read as a symbol.
+func tokenizeNumber(query string, i int) (*Token, int) {
+	s := ""
+	for i < len(query) && isNumber(query[i]) {
+		s += string(query[i])
+		i++
+	}
+
+	if i+1 < len(query) && query[i] == '.' && isNumber(query[i+1]) {
+		s += "."
+		i++
+		for i < len(query) && isNumber(query[i]) {
+			s += string(query[i])
+			i++
+		}
+		return &Token{Type: TkFloat, Val: s}, i
+	}
+
+	if i < len(query) && isAlphabet(query[i]) {
+		// symbol beginning with number (e.g. "1col")
+		for i < len(query) && (isAlphabet(query[i]) || isNumber(query[i])) {
+			s += string(query[i])
+			i++
+		}
+		return &Token{Type: TkSymbol, Val: s}, i
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		panic(fmt.Sprintf("invalid number '%s': %s", s, err))
+	}
+
+	return &Token{Type: TkInt, IVal: int(n)}, i
+}
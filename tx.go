@@ -0,0 +1,471 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Transactions are isolated by multi-version concurrency control (MVCC). Each version of a record has
+// the transaction which created it (xmin) and the one which deleted it (xmax) in its tuple header (see heap.go).
+// A transaction reads the snapshot taken when it begins: it sees the versions created by the transactions
+// committed before that and not deleted by them, so readers never wait for writers.
+//
+// The changes are written in the heap files as they are made, and the commit is recorded in WAL.
+// Rollback writes nothing because the versions created by the aborted transaction are never visible
+// and its xmax on the other versions is ignored. A transaction whose commit is not found in WAL is regarded
+// as aborted on recovery. The dead versions are left in the heap files.
+//
+// Two transactions cannot change the same record: the one which changes the record deleted or updated by
+// another transaction in progress or committed after its snapshot gets errSerialization (first updater wins).
+// The writer waits for the row lock of the record held by the other in progress (see lock.go), so it gets the error
+// only if the other commits.
+//
+// A savepoint starts a subtransaction, which has its own id taken from the same sequence. The changes after
+// the savepoint are made in the subtransaction, so rolling back to the savepoint just aborts the subtransactions
+// started since then. The subtransactions are committed together with their transaction: their ids are
+// recorded in the commit entry in WAL.
+
+// The isolation level of a transaction decides when its snapshot is taken. In repeatable read (the default),
+// it is taken once on begin. In read committed, it is retaken for every statement, so the statement sees the changes
+// committed before it begins and the waiting writer goes on with the change committed while waiting.
+// Serializable is repeatable read plus the dependency tracking to prevent write skew (see serializable.go).
+
+// Tx is a transaction. Every statement runs in a transaction; it is started and committed for the statement
+// unless the session is in a transaction block (see session.go).
+type Tx struct {
+	ID   uint64
+	snap snapshot
+
+	// cur is the id which the changes are made in. This is the subtransaction of the latest savepoint, or ID.
+	// xids is the ids of the transaction and its subtransactions which are not aborted.
+	cur  uint64
+	xids map[uint64]bool
+
+	savepoints []*savepoint
+
+	// wrote is true if any change is written, so the commit must be recorded in WAL.
+	wrote bool
+
+	// autocommit is true if the transaction is committed at the end of the statement,
+	// so the commit is recorded in WAL together with the changes (see logChanges).
+	autocommit bool
+
+	// locks is the row locks held by the transaction, and lockTimeout is how long it waits for a row lock
+	// (0 means forever). See lock.go.
+	locks       []lockKey
+	lockTimeout time.Duration
+
+	// done is true once the transaction is committed or aborted.
+	done bool
+
+	isolation isolationLevel
+}
+
+type isolationLevel int
+
+const (
+	repeatableRead isolationLevel = iota
+	readCommitted
+	serializable
+)
+
+var isolationLevels = map[string]isolationLevel{
+	"read committed":  readCommitted,
+	"repeatable read": repeatableRead,
+	"serializable":    serializable,
+}
+
+// savepoint is the subtransaction started by the savepoint of the name.
+type savepoint struct {
+	name string
+	xid  uint64
+}
+
+// snapshot is the transactions whose changes are not visible.
+type snapshot struct {
+	xmax   uint64          // the transactions whose id is equal to or larger than this began after the snapshot
+	active map[uint64]bool // the transactions in progress when the snapshot is taken
+}
+
+// xmin returns the oldest transaction whose changes are not visible in the snapshot.
+func (s snapshot) xmin() uint64 {
+	xmin := s.xmax
+	for xid := range s.active {
+		xmin = min(xmin, xid)
+	}
+	return xmin
+}
+
+// nextXid is the id of the next transaction. 0 is never used as it means the version is visible to every transaction.
+// activeTxs is the transactions in progress, and abortedTxs is the aborted transactions which wrote any change.
+// The transactions which are not in them and whose id is less than nextXid are committed.
+// They are protected by txMu.
+var (
+	nextXid    = uint64(1)
+	activeTxs  = map[uint64]*Tx{}
+	abortedTxs = map[uint64]bool{}
+	txMu       sync.Mutex
+)
+
+var errSerialization = errors.New("could not serialize access due to concurrent update")
+
+func beginTx() *Tx {
+	txMu.Lock()
+	defer txMu.Unlock()
+
+	tx := &Tx{ID: nextXid, snap: takeSnapshot(), xids: map[uint64]bool{}}
+	tx.cur = tx.newXid()
+	return tx
+}
+
+// takeSnapshot returns the snapshot of the transactions at the moment. Callers must hold txMu.
+func takeSnapshot() snapshot {
+	snap := snapshot{xmax: nextXid, active: map[uint64]bool{}}
+	for xid := range activeTxs {
+		snap.active[xid] = true
+	}
+	return snap
+}
+
+// Resnapshot retakes the snapshot of the transaction to see the changes committed since the last one.
+// The own changes are still visible because they are found in xids.
+func (tx *Tx) Resnapshot() {
+	txMu.Lock()
+	defer txMu.Unlock()
+
+	tx.snap = takeSnapshot()
+}
+
+// SetIsolation sets the isolation level of the transaction and retakes its snapshot, as if it begins now.
+func (tx *Tx) SetIsolation(level isolationLevel) {
+	tx.isolation = level
+	tx.Resnapshot()
+	trackSerializable(tx, level == serializable)
+}
+
+// newXid assigns a new id to the transaction or its subtransaction. Callers must hold txMu.
+func (tx *Tx) newXid() uint64 {
+	xid := nextXid
+	nextXid++
+	tx.xids[xid] = true
+	activeTxs[xid] = tx
+	return xid
+}
+
+// Savepoint starts the subtransaction of the savepoint. The savepoint of the same name is hidden until this is released.
+func (tx *Tx) Savepoint(name string) {
+	txMu.Lock()
+	defer txMu.Unlock()
+
+	tx.cur = tx.newXid()
+	tx.savepoints = append(tx.savepoints, &savepoint{name: name, xid: tx.cur})
+}
+
+// findSavepoint returns the position of the latest savepoint of the name, or -1 if not found.
+func (tx *Tx) findSavepoint(name string) int {
+	for i := len(tx.savepoints) - 1; i >= 0; i-- {
+		if tx.savepoints[i].name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// RollbackTo aborts the subtransactions started since the savepoint, then starts a new one for the savepoint.
+// The savepoints later than it are removed, and it stays.
+func (tx *Tx) RollbackTo(name string) error {
+	i := tx.findSavepoint(name)
+	if i < 0 {
+		return fmt.Errorf("savepoint '%s' does not exist", name)
+	}
+
+	txMu.Lock()
+	defer txMu.Unlock()
+
+	// the subtransactions started later have the larger ids
+	sp := tx.savepoints[i]
+	for xid := range tx.xids {
+		if xid >= sp.xid {
+			delete(tx.xids, xid)
+			delete(activeTxs, xid)
+			abortedTxs[xid] = true
+		}
+	}
+
+	tx.savepoints = tx.savepoints[:i+1]
+	tx.cur = tx.newXid()
+	sp.xid = tx.cur
+	return nil
+}
+
+// Release removes the savepoint and the later ones. The changes made since the savepoint are kept in the transaction.
+func (tx *Tx) Release(name string) error {
+	i := tx.findSavepoint(name)
+	if i < 0 {
+		return fmt.Errorf("savepoint '%s' does not exist", name)
+	}
+
+	tx.savepoints = tx.savepoints[:i]
+	return nil
+}
+
+// Commit records the commit in WAL if the transaction wrote any change, then ends the transaction.
+// If the commit cannot be recorded, the transaction is aborted.
+func (tx *Tx) Commit() error {
+	if !tx.wrote {
+		tx.end(true)
+		return nil
+	}
+
+	tsMu.Lock()
+	defer tsMu.Unlock()
+
+	// the state is changed while holding tsMu so that checkpoint never sees the commit in WAL with the transaction in progress
+	if err := logAndApply(tx.commitEntry()); err != nil {
+		tx.end(false)
+		return fmt.Errorf("commit transaction %d: %w", tx.ID, err)
+	}
+
+	tx.end(true)
+	return nil
+}
+
+// commitEntry returns the WAL entry recording the commit of the transaction and its subtransactions.
+func (tx *Tx) commitEntry() *WalEntry {
+	subxids := []uint64{}
+	for xid := range tx.xids {
+		if xid != tx.ID {
+			subxids = append(subxids, xid)
+		}
+	}
+	slices.Sort(subxids)
+
+	return &WalEntry{Op: WalCommit, Xid: tx.ID, Subxids: subxids}
+}
+
+// logChanges appends the entries of the changes made by the transaction to WAL then applies them.
+// In autocommit, the commit entry is appended in the same batch and the transaction is committed,
+// so that the statement syncs WAL only once. Callers must hold tsMu.
+//
+// Once the commit entry is durable, the transaction can no longer be rolled back. If a change fails to be applied then,
+// incdbd exits so that the change is redone on the next launch, instead of reporting the committed change as failed.
+func (tx *Tx) logChanges(es ...*WalEntry) error {
+	tx.wrote = true
+	if !tx.autocommit {
+		return logAndApply(es...)
+	}
+
+	es = append(es, tx.commitEntry())
+	if err := appendWal(es...); err != nil {
+		return fmt.Errorf("append WAL: %w", err)
+	}
+
+	for _, e := range es {
+		if err := applyWal(e); err != nil {
+			fmt.Fprintf(os.Stderr, "could not apply committed WAL entry (LSN: %d): %s\n", e.LSN, err)
+			os.Exit(1)
+		}
+	}
+
+	tx.end(true)
+	return nil
+}
+
+// Rollback aborts the transaction. The changes made by it are never visible.
+func (tx *Tx) Rollback() {
+	tx.end(false)
+}
+
+// end makes the commit or abort of the transaction visible to the others, then releases its row locks.
+// The aborted transaction is remembered only if it wrote any change, because nothing refers to it otherwise.
+func (tx *Tx) end(committed bool) {
+	txMu.Lock()
+	for xid := range tx.xids {
+		delete(activeTxs, xid)
+		if !committed && tx.wrote {
+			abortedTxs[xid] = true
+		}
+	}
+	tx.done = true
+	txMu.Unlock()
+
+	releaseLocks(tx)
+	endSerializable(tx, committed)
+}
+
+// sees returns true if the change made by the transaction xid is visible to tx.
+// txMu is taken for xids and snap too, because they can be read from the other sessions (see serializable.go).
+func (tx *Tx) sees(xid uint64) bool {
+	txMu.Lock()
+	defer txMu.Unlock()
+
+	if tx.xids[xid] {
+		return true // the aborted subtransactions are removed from xids
+	}
+
+	if xid >= tx.snap.xmax || tx.snap.active[xid] {
+		return false
+	}
+
+	return !abortedTxs[xid]
+}
+
+// Visible returns true if the version of the record is visible to tx.
+func (tx *Tx) Visible(ver Version) bool {
+	return tx.sees(ver.Xmin) && (ver.Xmax == 0 || !tx.sees(ver.Xmax))
+}
+
+// checkWrite returns errSerialization if the visible version of the record is deleted by another transaction
+// which is in progress or committed after the snapshot. The version deleted by the aborted transaction can be changed.
+func (tx *Tx) checkWrite(ver Version) error {
+	if ver.Xmax == 0 {
+		return nil
+	}
+
+	txMu.Lock()
+	defer txMu.Unlock()
+
+	if abortedTxs[ver.Xmax] {
+		return nil
+	}
+	return errSerialization
+}
+
+// dead returns true if the version is never visible from now on: it is created by the aborted transaction
+// or deleted by the committed one. The version deleted by tx is also dead for tx itself. tx can be nil.
+func dead(tx *Tx, ver Version) bool {
+	txMu.Lock()
+	defer txMu.Unlock()
+
+	if abortedTxs[ver.Xmin] {
+		return true
+	}
+
+	if ver.Xmax == 0 || abortedTxs[ver.Xmax] {
+		return false
+	}
+
+	return tx != nil && tx.xids[ver.Xmax] || activeTxs[ver.Xmax] == nil
+}
+
+// obsolete returns true if the version is never visible to the transactions in progress or the ones which begin later:
+// it is created by the aborted transaction, or deleted by the one committed before every snapshot in use.
+func obsolete(ver Version) bool {
+	txMu.Lock()
+	defer txMu.Unlock()
+
+	if abortedTxs[ver.Xmin] {
+		return true
+	}
+
+	if ver.Xmax == 0 || abortedTxs[ver.Xmax] || activeTxs[ver.Xmax] != nil {
+		return false
+	}
+
+	for _, tx := range activeTxs {
+		if ver.Xmax >= tx.snap.xmin() {
+			return false
+		}
+	}
+	return true
+}
+
+// allVisible returns true if the version is visible to every transaction in progress and to begin:
+// it is created by the transaction committed before every snapshot and not deleted, or deleted by the aborted one.
+func allVisible(ver Version) bool {
+	txMu.Lock()
+	defer txMu.Unlock()
+
+	if abortedTxs[ver.Xmin] || activeTxs[ver.Xmin] != nil || ver.Xmax != 0 && !abortedTxs[ver.Xmax] {
+		return false
+	}
+
+	for _, tx := range activeTxs {
+		if ver.Xmin >= tx.snap.xmin() {
+			return false
+		}
+	}
+	return true
+}
+
+// abortedXmax returns true if the version is deleted by the aborted transaction, so its xmax can be cleared.
+func abortedXmax(ver Version) bool {
+	txMu.Lock()
+	defer txMu.Unlock()
+
+	return ver.Xmax != 0 && abortedTxs[ver.Xmax]
+}
+
+// forgetAborted removes the aborted transactions from abortedTxs.
+// This must be called only after no version refers to them.
+func forgetAborted(xids []uint64) {
+	txMu.Lock()
+	defer txMu.Unlock()
+
+	for _, xid := range xids {
+		delete(abortedTxs, xid)
+	}
+}
+
+// abortedXids returns the aborted transactions.
+func abortedXids() []uint64 {
+	txMu.Lock()
+	defer txMu.Unlock()
+
+	xids := []uint64{}
+	for xid := range abortedTxs {
+		xids = append(xids, xid)
+	}
+	return xids
+}
+
+// restoreTransactions restores the state of the transactions from the control file and the WAL entries after the checkpoint.
+// The transactions which are not committed are aborted because they never continue after the restart.
+// Callers must hold tsMu.
+func restoreTransactions(ctl *Control, entries []*WalEntry) {
+	txMu.Lock()
+	defer txMu.Unlock()
+
+	nextXid = max(ctl.NextXid, 1)
+	for _, xid := range ctl.Uncommitted {
+		abortedTxs[xid] = true
+	}
+
+	for _, e := range entries {
+		if e.Xid == 0 {
+			continue
+		}
+
+		nextXid = max(nextXid, e.Xid+1)
+		if e.Op == WalCommit {
+			delete(abortedTxs, e.Xid)
+			for _, xid := range e.Subxids {
+				delete(abortedTxs, xid)
+			}
+		} else {
+			abortedTxs[e.Xid] = true // deleted on its commit which comes later
+		}
+	}
+}
+
+// uncommittedTxs returns the transactions which are aborted or in progress to be recorded on checkpoint,
+// and the id of the next transaction.
+// The ones in progress are aborted on recovery unless their commit is found in WAL after the checkpoint.
+func uncommittedTxs() ([]uint64, uint64) {
+	txMu.Lock()
+	defer txMu.Unlock()
+
+	xids := []uint64{}
+	for xid := range abortedTxs {
+		xids = append(xids, xid)
+	}
+	for xid := range activeTxs {
+		xids = append(xids, xid)
+	}
+	slices.Sort(xids)
+	return xids, nextXid
+}
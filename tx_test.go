@@ -0,0 +1,97 @@
+package main
+
+import "testing"
+
+func TestVisible(t *testing.T) {
+	// the transactions are ended without WAL
+	committed := beginTx()
+	committed.end(true)
+
+	aborted := beginTx()
+	aborted.wrote = true
+	aborted.end(false)
+
+	inProgress := beginTx()
+	tx := beginTx()
+	t.Cleanup(func() {
+		inProgress.Rollback()
+		tx.Rollback()
+	})
+
+	later := beginTx()
+	later.end(true)
+
+	for _, tc := range []struct {
+		name     string
+		ver      Version
+		expected bool
+	}{
+		{"frozen", Version{Xmin: 0}, true},
+		{"committed", Version{Xmin: committed.ID}, true},
+		{"aborted", Version{Xmin: aborted.ID}, false},
+		{"in progress", Version{Xmin: inProgress.ID}, false},
+		{"committed after snapshot", Version{Xmin: later.ID}, false},
+		{"own", Version{Xmin: tx.ID}, true},
+		{"deleted by committed", Version{Xmin: committed.ID, Xmax: committed.ID}, false},
+		{"deleted by aborted", Version{Xmin: committed.ID, Xmax: aborted.ID}, true},
+		{"deleted by in progress", Version{Xmin: committed.ID, Xmax: inProgress.ID}, true},
+		{"deleted by committed after snapshot", Version{Xmin: committed.ID, Xmax: later.ID}, true},
+		{"deleted by own", Version{Xmin: tx.ID, Xmax: tx.ID}, false},
+	} {
+		if got := tx.Visible(tc.ver); got != tc.expected {
+			t.Errorf("[%s] visible: got: %v, expected: %v", tc.name, got, tc.expected)
+		}
+	}
+
+	if err := tx.checkWrite(Version{Xmin: committed.ID, Xmax: inProgress.ID}); err != errSerialization {
+		t.Errorf("version deleted by the transaction in progress must not be written: %v", err)
+	}
+
+	if err := tx.checkWrite(Version{Xmin: committed.ID, Xmax: aborted.ID}); err != nil {
+		t.Errorf("version deleted by the aborted transaction must be written: %v", err)
+	}
+}
+
+func TestSavepoint(t *testing.T) {
+	tx := beginTx()
+	t.Cleanup(tx.Rollback)
+	top := tx.cur
+
+	tx.Savepoint("sp1")
+	sp1 := tx.cur
+	tx.Savepoint("sp2")
+	sp2 := tx.cur
+
+	if err := tx.RollbackTo("sp1"); err != nil {
+		t.Fatal(err)
+	}
+	if tx.cur == sp1 || tx.cur == sp2 {
+		t.Fatalf("new subtransaction must be started on rollback to savepoint")
+	}
+
+	for _, tc := range []struct {
+		name     string
+		xid      uint64
+		expected bool
+	}{
+		{"top", top, true},
+		{"rolled back", sp1, false},
+		{"later than rolled back", sp2, false},
+		{"current", tx.cur, true},
+	} {
+		if got := tx.Visible(Version{Xmin: tc.xid}); got != tc.expected {
+			t.Errorf("[%s] visible: got: %v, expected: %v", tc.name, got, tc.expected)
+		}
+	}
+
+	if err := tx.RollbackTo("sp2"); err == nil {
+		t.Fatalf("savepoint later than the one rolled back to must be removed")
+	}
+
+	if err := tx.Release("sp1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Release("sp1"); err == nil {
+		t.Fatalf("released savepoint must be removed")
+	}
+}
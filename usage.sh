@@ -14,18 +14,71 @@ kill_incdbd_if_exists
 INCDB_TEST=1 ./incdbd &
 echo "waiting for incdbd gets up and running..." && sleep 1
 
-./incdb 'create table person (id string, name string, lang string)'
+./incdb 'create table person (id string primary key, name string, lang string)'
 ./incdb 'insert into person (id, name, lang) values ("1", "alice", "En")'
 ./incdb 'insert into person (id, name, lang) values ("2", "bob", "Ja")'
 ./incdb 'insert into person values ("3", "chris", "Ja")'
 ./incdb 'insert into person values ("4", "donald", "En")'
 ./incdb 'insert into person values ("5", "eddie", "Ch")'
 ./incdb 'insert into person values ("6", "fred", "Ch")'
+./incdb 'insert into person values ("6", "george", "En")'
 ./incdb 'select * from person'
 ./incdb 'select name, lang from person'
 ./incdb 'select * from person where id = "2"'
 ./incdb 'select * from person where lang != "Ja"'
+./incdb 'select * from person where id between "2" and "4" and name like "%d%"'
+./incdb 'select * from person where (lang = "En" or lang = "Ch") and not name = "eddie"'
+./incdb 'select * from person where lang in ("En", "Ja")'
 ./incdb 'select * from person order by name desc limit 5 offset 3'
+./incdb 'select distinct lang from person order by lang desc nulls last'
+./incdb 'select name as n, lang from person order by lang, n desc'
+./incdb 'create index personlang on person (lang)'
+./incdb 'select * from person where lang = "Ja"'
+./incdb 'create index personlangname on person (lang, name)'
+./incdb 'select name from person where lang = "En" order by name desc'
+./incdb 'update person set lang = "En" where name = "fred"'
+./incdb 'delete from person where id = "6"'
 ./incdb 'select * from person order by lang'
+./incdb 'alter table person add column age int default 20'
+./incdb 'alter table person rename column lang to language'
+./incdb 'select * from person'
+./incdb 'create table langs (code string primary key, name string)'
+./incdb 'insert into langs values ("En", "English"), ("Ja", "Japanese")'
+./incdb 'create table people (name string, language string)'
+./incdb 'insert into people select name, language from person where age = 20'
+./incdb 'select p.name, l.name from person p join langs l on p.language = l.code'
+./incdb 'select p.name, l.name from person as p left join langs as l on p.language = l.code'
+./incdb 'select name from person where language in (select code from langs)'
+./incdb 'select code from langs l where not exists (select * from person p where p.language = l.code)'
+./incdb 'select x.name from (select * from person where age = 20) as x order by x.name'
+./incdb 'select language, count(*), avg(age) from person group by language having count(*) > 1'
+INCDB_SESSION=s1 ./incdb 'begin'
+INCDB_SESSION=s1 ./incdb 'update person set age = 30 where name = "chris"'
+./incdb 'select name, age from person where name = "chris"'
+INCDB_SESSION=s1 ./incdb 'commit'
+INCDB_SESSION=s1 ./incdb 'begin'
+INCDB_SESSION=s1 ./incdb 'delete from person'
+INCDB_SESSION=s1 ./incdb 'rollback'
+INCDB_SESSION=s1 ./incdb 'begin'
+INCDB_SESSION=s1 ./incdb 'update person set age = 40 where name = "donald"'
+INCDB_SESSION=s1 ./incdb 'savepoint beforedelete'
+INCDB_SESSION=s1 ./incdb 'delete from person where name = "donald"'
+INCDB_SESSION=s1 ./incdb 'rollback to savepoint beforedelete'
+INCDB_SESSION=s1 ./incdb 'commit'
+./incdb 'select name, age from person'
+INCDB_SESSION=s1 ./incdb 'begin'
+INCDB_SESSION=s1 ./incdb 'set transaction isolation level serializable'
+INCDB_SESSION=s1 ./incdb 'select count(*) from person where age = 20'
+INCDB_SESSION=s1 ./incdb 'commit'
+INCDB_SESSION=s1 ./incdb 'set lock timeout 1000'
+INCDB_SESSION=s1 ./incdb 'begin'
+INCDB_SESSION=s1 ./incdb 'select name, age from person where name = "chris" for update'
+INCDB_SESSION=s1 ./incdb 'update person set age = 31 where name = "chris"'
+INCDB_SESSION=s1 ./incdb 'commit'
+./incdb 'vacuum person'
+./incdb 'create table tmp (id int)'
+./incdb 'create table if not exists tmp (id int)'
+./incdb 'drop table tmp'
+./incdb 'checkpoint'
 
 kill_incdbd_if_exists
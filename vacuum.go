@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// Vacuum removes the versions which are never visible again from the heap files, so that their space is reused.
+// The dead versions are left by update, delete and the aborted transactions (see tx.go).
+// The removal is recorded in WAL page by page, and the page is compacted on applying it.
+// The page on which every remaining version is visible to every transaction is set in the visibility map
+// (see tablespace.go), so that the index-only scan skips reading it.
+
+// vacuumInterval is the interval of the vacuum done in background.
+const vacuumInterval = time.Minute
+
+// vacuumStats is the number and the size of the versions removed by vacuum.
+type vacuumStats struct {
+	Rows  int
+	Bytes int
+}
+
+// vacuum removes the obsolete versions in the table. If tbl is empty, every table is vacuumed.
+// On vacuuming every table, the aborted transactions are also forgotten as no version refers to them any longer.
+func vacuum(tbl string) (*vacuumStats, error) {
+	tsMu.Lock()
+	defer tsMu.Unlock()
+
+	tbls := []string{tbl}
+	aborted := []uint64{}
+	if tbl == "" {
+		// listed before the scan so that the transactions aborted during the scan are kept
+		aborted = abortedXids()
+
+		var err error
+		if tbls, err = listHeaps(); err != nil {
+			return nil, err
+		}
+	} else if ok, err := heapExists(tbl); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, fmt.Errorf("table '%s' not found", tbl)
+	}
+
+	stats := &vacuumStats{}
+	for _, t := range tbls {
+		if err := vacuumTable(t, stats); err != nil {
+			return nil, fmt.Errorf("vacuum table %s: %w", t, err)
+		}
+	}
+
+	forgetAborted(aborted)
+	return stats, nil
+}
+
+// vacuumTable removes the obsolete versions in the table, and clears xmax of the versions deleted by the aborted transactions.
+// The pages left with only the versions visible to every transaction are set in the visibility map.
+// Callers must hold tsMu.
+func vacuumTable(tbl string, stats *vacuumStats) error {
+	return scanHeap(heapPath(tbl), func(n uint32, p Page) error {
+		e := &WalEntry{Op: WalVacuum, Table: tbl, Page: n}
+		size := 0
+		visible := true
+		for i := 0; i < p.Slots(); i++ {
+			t := p.Tuple(i)
+			if t == nil {
+				continue
+			}
+
+			ver := tupleVersion(t)
+			if obsolete(ver) {
+				e.Slots = append(e.Slots, uint16(i))
+				size += len(t)
+				continue
+			}
+
+			if abortedXmax(ver) {
+				e.ClearXmax = append(e.ClearXmax, uint16(i))
+			}
+			visible = visible && allVisible(ver)
+		}
+
+		if len(e.Slots) != 0 || len(e.ClearXmax) != 0 {
+			if err := logAndApply(e); err != nil {
+				return fmt.Errorf("vacuum page %d: %w", n, err)
+			}
+		}
+
+		// set after applying the entry, which clears the page
+		setAllVisible(tbl, n, visible)
+
+		stats.Rows += len(e.Slots)
+		stats.Bytes += size
+		return nil
+	})
+}
+
+// runVacuumer vacuums every table periodically. This never returns.
+func runVacuumer(interval time.Duration) {
+	for range time.Tick(interval) {
+		stats, err := vacuum("")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "vacuum: %s\n", err)
+			continue
+		}
+
+		Debug("vacuum removed rows: ", stats.Rows, ", bytes: ", stats.Bytes)
+	}
+}
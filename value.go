@@ -0,0 +1,156 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Column types. A value in a record is represented as the Go type (NULL is nil):
+//
+//	string    -> string
+//	int       -> int64
+//	float     -> float64
+//	bool      -> bool
+//	timestamp -> time.Time (UTC)
+const (
+	TypeString    = "string"
+	TypeInt       = "int"
+	TypeFloat     = "float"
+	TypeBool      = "bool"
+	TypeTimestamp = "timestamp"
+)
+
+var columnTypes = []string{TypeString, TypeInt, TypeFloat, TypeBool, TypeTimestamp}
+
+// timestampLayouts are the accepted formats of timestamp literal. Timestamp without time zone is in UTC.
+var timestampLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+// timestampFormat is the format to show a timestamp value.
+const timestampFormat = "2006-01-02 15:04:05.999999999"
+
+// parseValue converts the literal into the value of the column type.
+func parseValue(typ, lit string) (any, error) {
+	switch typ {
+	case TypeString:
+		return lit, nil
+
+	case TypeInt:
+		i, err := strconv.ParseInt(lit, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("'%s' is not a valid int", lit)
+		}
+		return i, nil
+
+	case TypeFloat:
+		f, err := strconv.ParseFloat(lit, 64)
+		if err != nil {
+			return nil, fmt.Errorf("'%s' is not a valid float", lit)
+		}
+		return f, nil
+
+	case TypeBool:
+		switch strings.ToLower(lit) {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		}
+		return nil, fmt.Errorf("'%s' is not a valid bool", lit)
+
+	case TypeTimestamp:
+		for _, layout := range timestampLayouts {
+			if t, err := time.Parse(layout, lit); err == nil {
+				return t.UTC(), nil
+			}
+		}
+		return nil, fmt.Errorf("'%s' is not a valid timestamp", lit)
+	}
+
+	return nil, fmt.Errorf("unknown type '%s'", typ)
+}
+
+// parseLiteralValue is the same as parseValue, but nil literal means NULL.
+func parseLiteralValue(typ string, lit *string) (any, error) {
+	if lit == nil {
+		return nil, nil
+	}
+
+	return parseValue(typ, *lit)
+}
+
+func formatValue(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "NULL"
+	case string:
+		return v
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case time.Time:
+		return v.UTC().Format(timestampFormat)
+	}
+	return fmt.Sprint(v)
+}
+
+// compareValues returns -1 if a < b, 1 if a > b, otherwise 0.
+// The values are expected to be the same type. If not, they are compared as strings
+// except that int and float are compared as numbers.
+// NULL is larger than any other value for sorting.
+func compareValues(a, b any) int {
+	if a == nil || b == nil {
+		switch {
+		case a == nil && b == nil:
+			return 0
+		case a == nil:
+			return 1
+		default:
+			return -1
+		}
+	}
+
+	switch a := a.(type) {
+	case int64:
+		switch b := b.(type) {
+		case int64:
+			return cmp.Compare(a, b)
+		case float64:
+			return cmp.Compare(float64(a), b)
+		}
+	case float64:
+		switch b := b.(type) {
+		case float64:
+			return cmp.Compare(a, b)
+		case int64:
+			return cmp.Compare(a, float64(b))
+		}
+	case bool:
+		if b, ok := b.(bool); ok {
+			// false < true
+			if a == b {
+				return 0
+			}
+			if !a {
+				return -1
+			}
+			return 1
+		}
+	case time.Time:
+		if b, ok := b.(time.Time); ok {
+			return a.Compare(b)
+		}
+	}
+
+	return cmp.Compare(formatValue(a), formatValue(b))
+}
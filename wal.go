@@ -0,0 +1,403 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Every change on the tablespace is appended to the write-ahead log (WAL) and fsynced
+// before it is applied to the tablespace file, so that the change can be redone on launch
+// even if incdbd dies while the tablespace file is being updated.
+//
+// WAL is split into segment files named after the LSN of their first entry
+// (e.g. "data/incdb.wal.0000000000000001"), so that the segments older than the checkpoint
+// can be removed as a whole. An entry is a JSON object in a line:
+//
+// {"LSN":1,"Op":"create","Table":"tbl1","Def":{"Name":"tbl1","Cols":[{"Name":"col1","Type":"string"}]}}
+// {"LSN":2,"Op":"insert","Xid":1,"Table":"tbl1","Slot":0,"Tuple":"AQAAAAAAAAAAAAAAAAAAAAEAAQQAdmFsMQ=="}
+// {"LSN":3,"Op":"commit","Xid":1}
+// {"LSN":4,"Op":"update","Xid":2,"Table":"tbl1","Slot":0,"Tuple":"AgAAAAAAAAAAAAAAAAAAAAEAAQQAdmFsMg==","To":{"Page":0,"Slot":1}}
+// {"LSN":5,"Op":"delete","Xid":3,"Table":"tbl1","Slot":1}
+// {"LSN":6,"Op":"commit","Xid":2,"Subxids":[3]}
+// {"LSN":7,"Op":"vacuum","Table":"tbl1","Slots":[0,1]}
+// {"LSN":8,"Op":"alter","Table":"tbl1","Def":{"Name":"tbl2","Cols":[{"Name":"col1","Type":"string"}]},"DropCol":1}
+// {"LSN":9,"Op":"drop","Table":"tbl2"}
+//
+// Tuple is the tuple in the heap file (see makeHeapTuple) in base64.
+var walfile = "data/incdb.wal"
+
+// walSegmentSize is the size of a WAL segment file at which the next segment gets started.
+const walSegmentSize = 16 * 1024 * 1024
+
+func init() {
+	// test mode
+	if os.Getenv("INCDB_TEST") == "1" {
+		walfile = "data/test.incdb.wal"
+	}
+}
+
+type WalOp string
+
+const (
+	WalCreate = WalOp("create")
+	WalInsert = WalOp("insert")
+	WalUpdate = WalOp("update")
+	WalDelete = WalOp("delete")
+	WalAlter  = WalOp("alter")
+	WalDrop   = WalOp("drop")
+	WalCommit = WalOp("commit")
+	WalVacuum = WalOp("vacuum")
+)
+
+type WalEntry struct {
+	// LSN (log sequence number) is monotonically increasing number assigned on append.
+	LSN uint64
+	Op  WalOp
+
+	// Xid is the transaction which made the change. active only if Op is WalInsert, WalUpdate, WalDelete or WalCommit.
+	// On the changes made after a savepoint, this is the subtransaction.
+	Xid uint64 `json:",omitempty"`
+
+	// active only if Op is WalCommit. The subtransactions which are committed together.
+	Subxids []uint64 `json:",omitempty"`
+
+	Table string `json:",omitempty"`
+
+	// active only if Op is WalCreate or WalAlter.
+	// On alter, this is the new definition and the table is renamed if the name differs from Table.
+	Def *CtTable `json:",omitempty"`
+
+	// active only if Op is WalAlter and a column is dropped. This is the index of the dropped column.
+	DropCol *int `json:",omitempty"`
+
+	// active only if Op is WalInsert, WalUpdate or WalDelete (Tuple is not set on delete).
+	// On update and delete, Page and Slot are the location of the old version whose xmax is set to Xid.
+	Page  uint32 `json:",omitempty"`
+	Slot  uint16 `json:",omitempty"`
+	Tuple []byte `json:",omitempty"`
+
+	// active only if Op is WalUpdate. The new version is put at To.
+	To *RID `json:",omitempty"`
+
+	// active only if Op is WalVacuum. The tuples in Slots of the page are removed,
+	// and xmax of the tuples in ClearXmax is cleared because it is the aborted transaction.
+	Slots     []uint16 `json:",omitempty"`
+	ClearXmax []uint16 `json:",omitempty"`
+
+	// active only if Op is WalInsert and the entry is written before the heap files are introduced.
+	// This is read only on the migration of the legacy tablespace file.
+	Row map[string]string `json:",omitempty"`
+}
+
+// lastLSN is the LSN assigned to the last appended entry.
+// curSegment is the path of the segment file which entries are appended to.
+// The next segment gets started on the next append if curSegment is empty.
+// walSyncs is the number of the appends, each of which syncs the segment file once.
+// walErr is set if a failed append cannot be undone, then every append is refused, because the entries after
+// the torn or unsynced ones would be discarded on recovery.
+// Callers must hold tsMu to touch them.
+var (
+	lastLSN    uint64
+	curSegment string
+	walSyncs   int64
+	walErr     error
+)
+
+type walSegment struct {
+	path     string
+	firstLSN uint64
+}
+
+func walSegmentPath(firstLSN uint64) string {
+	return fmt.Sprintf("%s.%016d", walfile, firstLSN)
+}
+
+// listWalSegments returns the WAL segment files in LSN order.
+func listWalSegments() ([]*walSegment, error) {
+	paths, err := filepath.Glob(walfile + ".*")
+	if err != nil {
+		return nil, fmt.Errorf("list WAL segment files: %w", err)
+	}
+
+	segs := []*walSegment{}
+	for _, p := range paths {
+		lsn, err := strconv.ParseUint(strings.TrimPrefix(p, walfile+"."), 10, 64)
+		if err != nil {
+			continue // not a segment file
+		}
+		segs = append(segs, &walSegment{path: p, firstLSN: lsn})
+	}
+
+	sort.Slice(segs, func(i, j int) bool { return segs[i].firstLSN < segs[j].firstLSN })
+	return segs, nil
+}
+
+// migrateWalFile renames the WAL file written before the segments are introduced (e.g. "data/incdb.wal")
+// to the segment file named after the LSN of its first entry, so that its entries are read as the first segment.
+// Nothing is done if the file does not exist. The file without any complete entry is removed,
+// because the torn entry is never acknowledged to the client.
+// This must be called on launch before WAL is read.
+func migrateWalFile() error {
+	f, err := os.Open(walfile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("open legacy WAL file: %w", err)
+	}
+
+	var first WalEntry
+	err = json.NewDecoder(f).Decode(&first)
+	f.Close()
+
+	if err != nil {
+		Debug("discard legacy WAL file without complete entry: ", err)
+		if err := os.Remove(walfile); err != nil {
+			return fmt.Errorf("remove legacy WAL file: %w", err)
+		}
+		return syncDir(filepath.Dir(walfile))
+	}
+
+	seg := walSegmentPath(first.LSN)
+	if _, err := os.Stat(seg); err == nil {
+		return fmt.Errorf("WAL segment file %s already exists", seg)
+	}
+
+	if err := os.Rename(walfile, seg); err != nil {
+		return fmt.Errorf("rename legacy WAL file: %w", err)
+	}
+
+	Debug("renamed legacy WAL file to ", seg)
+	return syncDir(filepath.Dir(walfile))
+}
+
+// appendWal assigns the next LSNs to the entries then writes them into the current WAL segment.
+// The entries are written and synced at once, and they are durable once appendWal returns without error.
+// If the write or the sync fails, the segment is truncated to remove the entries, so that the next append
+// neither follows the torn bytes nor reuses the LSNs.
+func appendWal(es ...*WalEntry) error {
+	if walErr != nil {
+		return fmt.Errorf("WAL is unavailable after the failed append: %w", walErr)
+	}
+
+	if curSegment == "" {
+		curSegment = walSegmentPath(lastLSN + 1)
+	}
+
+	f, err := os.OpenFile(curSegment, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0755)
+	if err != nil {
+		return fmt.Errorf("open WAL segment file: %w", err)
+	}
+	defer f.Close()
+
+	buf := []byte{}
+	for i, e := range es {
+		e.LSN = lastLSN + 1 + uint64(i)
+
+		b, err := json.Marshal(e)
+		if err != nil {
+			return fmt.Errorf("marshal WAL entry: %w", err)
+		}
+		buf = append(append(buf, b...), '\n')
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("stat WAL segment file: %w", err)
+	}
+	offset := info.Size()
+
+	if err := writeAndSync(f, buf); err != nil {
+		if terr := truncateWal(f, offset); terr != nil {
+			walErr = terr
+			return fmt.Errorf("%w (WAL is unavailable: %v)", err, terr)
+		}
+		return err
+	}
+	walSyncs++
+
+	lastLSN += uint64(len(es))
+
+	if offset+int64(len(buf)) >= walSegmentSize {
+		curSegment = ""
+	}
+
+	return nil
+}
+
+func writeAndSync(f *os.File, buf []byte) error {
+	if _, err := f.Write(buf); err != nil {
+		return fmt.Errorf("write WAL entry: %w", err)
+	}
+
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("sync WAL segment file: %w", err)
+	}
+
+	return nil
+}
+
+// truncateWal removes the bytes written after the offset from the WAL segment file.
+func truncateWal(f *os.File, offset int64) error {
+	if err := f.Truncate(offset); err != nil {
+		return fmt.Errorf("truncate failed WAL append: %w", err)
+	}
+
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("sync truncated WAL segment file: %w", err)
+	}
+
+	return nil
+}
+
+// readWal reads the entries whose LSN is larger than the given LSN from WAL segment files.
+// An entry which is partially written at the end of the last segment (the process died during append)
+// is never acknowledged to the client, so it is discarded and the file is truncated.
+func readWal(from uint64) ([]*WalEntry, error) {
+	segs, err := listWalSegments()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []*WalEntry{}
+	for i, seg := range segs {
+		if i+1 < len(segs) && segs[i+1].firstLSN-1 <= from {
+			continue // every entry in the segment is older
+		}
+
+		es, err := readWalSegment(seg.path, i == len(segs)-1)
+		if err != nil {
+			return nil, fmt.Errorf("read WAL segment %s: %w", seg.path, err)
+		}
+
+		for _, e := range es {
+			if e.LSN > from {
+				entries = append(entries, e)
+			}
+		}
+	}
+
+	return entries, nil
+}
+
+func readWalSegment(path string, last bool) ([]*WalEntry, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("open WAL segment file: %w", err)
+	}
+	defer f.Close()
+
+	entries := []*WalEntry{}
+	dec := json.NewDecoder(f)
+	for {
+		offset := dec.InputOffset()
+
+		var e WalEntry
+		err := dec.Decode(&e)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			if !last {
+				return nil, fmt.Errorf("decode WAL entry at offset %d: %w", offset, err)
+			}
+
+			Debug("discard torn WAL entry at offset ", offset, ": ", err)
+			if err := f.Truncate(offset); err != nil {
+				return nil, fmt.Errorf("truncate torn WAL entry: %w", err)
+			}
+			break
+		}
+
+		entries = append(entries, &e)
+	}
+
+	return entries, nil
+}
+
+// removeWalSegments removes the segment files which contain only the entries whose LSN is
+// less than or equal to the given LSN. The current segment is also removed if so,
+// then the next segment gets started on the next append.
+func removeWalSegments(upto uint64) (int, error) {
+	segs, err := listWalSegments()
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for i, seg := range segs {
+		last := lastLSN
+		if i+1 < len(segs) {
+			last = segs[i+1].firstLSN - 1
+		}
+
+		if last > upto {
+			break
+		}
+
+		if err := os.Remove(seg.path); err != nil {
+			return removed, fmt.Errorf("remove WAL segment file %s: %w", seg.path, err)
+		}
+
+		if seg.path == curSegment {
+			curSegment = ""
+		}
+		removed++
+	}
+
+	return removed, syncDir(filepath.Dir(walfile))
+}
+
+// recoverFromWal redoes the WAL entries which are not yet applied to the tablespace.
+// This must be called once on launch before accepting queries.
+func recoverFromWal() error {
+	tsMu.Lock()
+	defer tsMu.Unlock()
+
+	ctl, err := readControl()
+	if err != nil {
+		return fmt.Errorf("read control file: %w", err)
+	}
+
+	// The entries until the checkpoint are already in the heap files.
+	entries, err := readWal(ctl.CheckpointLSN)
+	if err != nil {
+		return fmt.Errorf("read WAL: %w", err)
+	}
+
+	lastLSN = ctl.CheckpointLSN
+
+	for _, e := range entries {
+		lastLSN = max(lastLSN, e.LSN)
+
+		// The heap file is missing if the table is dropped or renamed by a later entry, which is already applied
+		// before the crash. The change on the renamed table is already in the heap file of the new name.
+		switch e.Op {
+		case WalInsert, WalUpdate, WalDelete, WalVacuum:
+			if ok, err := heapExists(e.Table); err != nil {
+				return fmt.Errorf("redo WAL entry (LSN: %d): %w", e.LSN, err)
+			} else if !ok {
+				Debug("skip WAL entry on the table dropped or renamed later (LSN: ", e.LSN, ")")
+				continue
+			}
+		}
+
+		if err := applyWal(e); err != nil {
+			return fmt.Errorf("redo WAL entry (LSN: %d): %w", e.LSN, err)
+		}
+	}
+
+	restoreTransactions(ctl, entries)
+
+	Debug("redone WAL entries: ", len(entries))
+	return nil
+}